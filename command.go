@@ -0,0 +1,98 @@
+package main
+
+import (
+	"WeatherInfos/weather"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// commands are the subcommands run instead of the server, such as `WeatherInfos export -format csv`
+var commands = map[string]func(args []string) int{
+	"export": exportCommand,
+	"import": importCommand,
+}
+
+// exportCommand writes the stations of the region tree to a file or stdout
+func exportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", weather.EXPORT_FORMAT_CSV, "The export format: csv, json or geojson")
+	city := fs.String("city", "", "Only export the stations below this region, such as 四川,成都")
+	output := fs.String("o", "", "The output file, stdout by default")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if _, ok := EXPORT_CONTENT_TYPES[*format]; !ok { /*打开输出文件之前检查，不截断已有的文件*/
+		fmt.Fprintln(os.Stderr, "unknown format", *format)
+		return 2
+	}
+
+	handle := weather.New(int(weather.DEFAULT_LIMIT_SIZE), nil)
+	if err := handle.InitRegionTree(); err != nil {
+		fmt.Fprintln(os.Stderr, "load region tree failed:", err)
+		return 1
+	}
+	var path []string
+	if "" != *city {
+		path = strings.Split(*city, STR_SEP)
+	}
+	records, err := handle.RegionRecords(path...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var out io.Writer = os.Stdout
+	if "" != *output {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	if err := weather.WriteRegionRecords(out, *format, records); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if "" != *output {
+		fmt.Printf("export %d stations to %s\n", len(records), *output)
+	}
+	return 0
+}
+
+// importCommand builds the region data file from a curated csv
+func importCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	input := fs.String("i", "", "The csv file to import, in the layout of `export -format csv`")
+	output := fs.String("o", weather.REGION_CACHE_FILE, "The region data file to write")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if "" == *input {
+		fs.Usage()
+		return 2
+	}
+
+	file, err := os.Open(*input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+	records, err := weather.ReadRegionCSV(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	handle := weather.New(int(weather.DEFAULT_LIMIT_SIZE), nil)
+	if err := handle.ImportRegionRecords(records, *output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("import %d stations into %s\n", len(records), *output)
+	return 0
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"WeatherInfos/weather"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportCommand(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	/*未知的格式不截断已有的文件，也不创建新文件*/
+	os.WriteFile("kept.csv", []byte("kept"), 0644)
+	if code := exportCommand([]string{"-format", "xml", "-o", "kept.csv"}); 2 != code {
+		t.Errorf("export -format xml = %d, want 2", code)
+	}
+	if buf, _ := os.ReadFile("kept.csv"); "kept" != string(buf) {
+		t.Errorf("export -format xml truncated the output to %q", buf)
+	}
+	if code := exportCommand([]string{"-format", "xml", "-o", "new.csv"}); 2 != code {
+		t.Errorf("export -format xml = %d, want 2", code)
+	}
+	if _, err := os.Stat("new.csv"); !os.IsNotExist(err) {
+		t.Errorf("export -format xml created the output: %v", err)
+	}
+
+	/*import写入当前目录的地区文件，export从中读取*/
+	os.WriteFile("in.csv", []byte("province,city,county,code\n"+
+		"四川,成都,成都,101270101\n四川,成都,都江堰,101270111\n四川,绵阳,绵阳,101270401\n"), 0644)
+	if code := importCommand([]string{"-i", "in.csv"}); 0 != code {
+		t.Fatalf("import = %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, weather.REGION_CACHE_FILE)); err != nil {
+		t.Fatal(err)
+	}
+	if code := exportCommand([]string{"-city", "四川,成都", "-o", "out.csv"}); 0 != code {
+		t.Fatalf("export -city 四川,成都 = %d", code)
+	}
+	file, err := os.Open("out.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := weather.ReadRegionCSV(file)
+	if err != nil || 2 != len(records) || "101270101" != records[0].Code || "都江堰" != records[1].County || "sichuan,chengdu,dujiangyan" != records[1].Spell {
+		t.Errorf("exported %+v, %v", records, err)
+	}
+	if code := exportCommand([]string{"-city", "拉萨", "-o", "out.csv"}); 1 != code {
+		t.Errorf("export -city 拉萨 = %d, want 1", code)
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"WeatherInfos/weather"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/websocket"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WS_MAX_SUBSCRIPTIONS = 32               /*每个连接最多订阅的城市及告警地区数*/
+	WS_SEND_BUFFER       = 64               /*每个连接待发送的消息数，超出时断开该连接*/
+	WS_HEARTBEAT         = 30 * time.Second /*心跳间隔*/
+	WS_WRITE_TIMEOUT     = 10 * time.Second
+
+	WS_ACTION_SUBSCRIBE   = "subscribe"
+	WS_ACTION_UNSUBSCRIBE = "unsubscribe"
+	WS_ACTION_PING        = "ping"
+
+	WS_TYPE_SUBSCRIBED = "subscribed" /*订阅变化后的全部订阅*/
+	WS_TYPE_ALARM_LIST = "alarm_list" /*告警列表中某个地区的告警发布及解除*/
+	WS_TYPE_HEARTBEAT  = "heartbeat"
+	WS_TYPE_PONG       = "pong"
+	WS_TYPE_ERROR      = "error"
+)
+
+/*告警订阅的地区code前缀，省10101、市1010101或站点code*/
+var alarmPrefixRe = regexp.MustCompile("^[0-9]{5,12}$")
+
+/*客户端发送的消息*/
+type wsRequest struct {
+	Action string   `json:"action"`
+	Cities []string `json:"cities"` /*站点code*/
+	Alarms []string `json:"alarms"` /*地区code前缀*/
+}
+
+/*服务端发送的消息，城市的天气事件直接发送weather.WeatherEvent*/
+type wsReply struct {
+	Type    string             `json:"type"`
+	ECode   string             `json:"ecode,omitempty"`
+	RMsg    string             `json:"rmsg,omitempty"`
+	Cities  []string           `json:"cities,omitempty"`
+	Alarms  []string           `json:"alarms,omitempty"`
+	Code    string             `json:"code,omitempty"`
+	Added   []weather.Location `json:"added,omitempty"`
+	Removed []weather.Location `json:"removed,omitempty"`
+}
+
+/*一个websocket连接*/
+type wsClient struct {
+	conn      *websocket.Conn
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	cities    map[string]struct{} /*hub.mu*/
+	alarms    map[string]struct{} /*hub.mu*/
+}
+
+/*一个被订阅的城市*/
+type wsCity struct {
+	clients map[*wsClient]struct{}
+	stop    func()
+}
+
+// wsHub fans out the events of the weather package to the websocket clients,
+// every city is watched once however many clients subscribe it.
+type wsHub struct {
+	weather   *weather.Weather
+	mu        sync.Mutex
+	cities    map[string]*wsCity
+	clients   map[*wsClient]struct{}
+	alarmList map[string][]weather.Location /*上一次的告警列表*/
+}
+
+var (
+	hub     *wsHub
+	hubOnce sync.Once
+)
+
+// GetHub returns the websocket hub of the weather handle
+func GetHub() *wsHub {
+	hubOnce.Do(func() {
+		hub = &wsHub{
+			weather: GetWeatherHandle(),
+			cities:  make(map[string]*wsCity),
+			clients: make(map[*wsClient]struct{}),
+		}
+		weather.OnAlarmListUpdate(hub.onAlarmList)
+	})
+	return hub
+}
+
+// SubscribeWeather serves a websocket connection, the client sends
+// {"action":"subscribe","cities":["101010100"],"alarms":["10101"]} to subscribe cities and alarm feeds,
+// "unsubscribe" to drop them and "ping" to check the connection.
+func SubscribeWeather(w http.ResponseWriter, r *http.Request) {
+	server := websocket.Server{
+		Handshake: checkOrigin,
+		Handler:   GetHub().serve,
+	}
+	server.ServeHTTP(w, r)
+}
+
+// checkOrigin refuses the pages of other sites unless -ws-origins allows them,
+// a client without Origin such as a kiosk is let in. The handshake answers 403 on error.
+func checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if nil == origin || strings.EqualFold(origin.Host, r.Host) {
+		return nil
+	}
+	for _, allowed := range strings.Split(*wsOrigins, ",") {
+		allowed = strings.TrimRight(strings.TrimSpace(allowed), "/")
+		if "*" == allowed || strings.EqualFold(allowed, origin.Scheme+"://"+origin.Host) {
+			return nil
+		}
+	}
+	log.Println("refuse the websocket from", origin, r.RemoteAddr)
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+func (h *wsHub) serve(conn *websocket.Conn) {
+	client := &wsClient{
+		conn:   conn,
+		send:   make(chan []byte, WS_SEND_BUFFER),
+		done:   make(chan struct{}),
+		cities: make(map[string]struct{}),
+		alarms: make(map[string]struct{}),
+	}
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+	defer h.leave(client)
+	go client.writeLoop()
+
+	for {
+		var req wsRequest
+		if err := websocket.JSON.Receive(conn, &req); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				client.reply(wsReply{Type: WS_TYPE_ERROR, ECode: weather.ECODE_BAD_PARAMETER, RMsg: err.Error()})
+				continue
+			}
+			return
+		}
+		switch req.Action {
+		case WS_ACTION_SUBSCRIBE:
+			h.subscribe(client, req)
+		case WS_ACTION_UNSUBSCRIBE:
+			h.unsubscribe(client, req)
+		case WS_ACTION_PING:
+			client.reply(wsReply{Type: WS_TYPE_PONG})
+		default:
+			client.reply(wsReply{Type: WS_TYPE_ERROR, ECode: weather.ECODE_BAD_PARAMETER, RMsg: "unknown action " + req.Action})
+		}
+	}
+}
+
+// subscribe adds the cities and the alarm feeds of req to client, the cities get their weather at once
+func (h *wsHub) subscribe(client *wsClient, req wsRequest) {
+	var fresh []string
+	h.mu.Lock()
+	for _, code := range req.Cities {
+		code = strings.TrimSpace(code)
+		if _, had := client.cities[code]; had {
+			continue
+		}
+		if err := h.checkLimit(client); nil != err {
+			client.replyError(err)
+			break
+		}
+		if _, err := h.weather.RegionByCode(code); nil != err {
+			client.replyError(fmt.Errorf("%s: %w", code, err))
+			continue
+		}
+		city := h.cities[code]
+		if nil == city {
+			city = h.watch(code)
+			h.cities[code] = city
+		}
+		city.clients[client] = struct{}{}
+		client.cities[code] = struct{}{}
+		fresh = append(fresh, code)
+	}
+	for _, prefix := range req.Alarms {
+		prefix = strings.TrimSpace(prefix)
+		if _, had := client.alarms[prefix]; had {
+			continue
+		}
+		if err := h.checkLimit(client); nil != err {
+			client.replyError(err)
+			break
+		}
+		if !alarmPrefixRe.MatchString(prefix) {
+			client.replyError(fmt.Errorf("%w: invalid alarm region %s", weather.ErrBadParameter, prefix))
+			continue
+		}
+		client.alarms[prefix] = struct{}{}
+		for code, locations := range h.alarmList {
+			if strings.HasPrefix(code, prefix) {
+				client.reply(wsReply{Type: WS_TYPE_ALARM_LIST, Code: code, Added: locations})
+			}
+		}
+	}
+	client.reply(client.subscriptions())
+	h.mu.Unlock()
+
+	for _, code := range fresh {
+		go func(code string) {
+			resp, err := h.weather.ShowCityWeatherByCode(code)
+			if nil != resp {
+				client.reply(weather.WeatherEvent{Type: weather.EVENT_WEATHER, Code: code, Weather: resp})
+			} else if nil != err {
+				client.replyError(fmt.Errorf("%s: %w", code, err))
+			}
+		}(code)
+	}
+}
+
+// unsubscribe drops the cities and the alarm feeds of req from client
+func (h *wsHub) unsubscribe(client *wsClient, req wsRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, code := range req.Cities {
+		h.dropCity(client, strings.TrimSpace(code))
+	}
+	for _, prefix := range req.Alarms {
+		delete(client.alarms, strings.TrimSpace(prefix))
+	}
+	client.reply(client.subscriptions())
+}
+
+// leave drops all the subscriptions of a closed connection
+func (h *wsHub) leave(client *wsClient) {
+	h.mu.Lock()
+	for code := range client.cities {
+		h.dropCity(client, code)
+	}
+	delete(h.clients, client)
+	h.mu.Unlock()
+	client.close()
+}
+
+// checkLimit fails when client has WS_MAX_SUBSCRIPTIONS subscriptions, h.mu must be held
+func (h *wsHub) checkLimit(client *wsClient) error {
+	if len(client.cities)+len(client.alarms) >= WS_MAX_SUBSCRIPTIONS {
+		return fmt.Errorf("%w: at most %d subscriptions on a connection", weather.ErrBadParameter, WS_MAX_SUBSCRIPTIONS)
+	}
+	return nil
+}
+
+// dropCity removes client from the city, the city is no longer watched without clients, h.mu must be held
+func (h *wsHub) dropCity(client *wsClient, code string) {
+	delete(client.cities, code)
+	city := h.cities[code]
+	if nil == city {
+		return
+	}
+	delete(city.clients, client)
+	if 0 == len(city.clients) {
+		city.stop()
+		delete(h.cities, code)
+	}
+}
+
+// watch starts forwarding the events of the city code to its clients,
+// the city is looked up every CURRENT_INFO_GAP to keep it fresh. h.mu must be held
+func (h *wsHub) watch(code string) *wsCity {
+	events, stopWatch := h.weather.Watch(code)
+	quit := make(chan struct{})
+	city := &wsCity{
+		clients: make(map[*wsClient]struct{}),
+		stop: func() {
+			stopWatch()
+			close(quit)
+		},
+	}
+	go func() {
+		refresh := time.NewTicker(weather.CURRENT_INFO_GAP)
+		defer refresh.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case event := <-events:
+				msg, err := json.Marshal(event)
+				if nil != err {
+					log.Println("Error marshaling event:", err)
+					continue
+				}
+				h.mu.Lock()
+				for client := range city.clients {
+					client.push(msg)
+				}
+				h.mu.Unlock()
+			case <-refresh.C:
+				/*刷新由缓存更新时推送，这里只触发查询*/
+				go h.weather.ShowCityWeatherByCode(code)
+			}
+		}
+	}()
+	return city
+}
+
+// onAlarmList pushes the locations added to and removed from the alarm list to the clients of their region
+func (h *wsHub) onAlarmList(infos map[string][]weather.Location) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var changes []wsReply
+	for code, locations := range infos {
+		added, removed := diffLocations(h.alarmList[code], locations)
+		if len(added) > 0 || len(removed) > 0 {
+			changes = append(changes, wsReply{Type: WS_TYPE_ALARM_LIST, Code: code, Added: added, Removed: removed})
+		}
+	}
+	for code, locations := range h.alarmList {
+		if _, ok := infos[code]; !ok {
+			changes = append(changes, wsReply{Type: WS_TYPE_ALARM_LIST, Code: code, Removed: locations})
+		}
+	}
+	h.alarmList = infos
+
+	for _, change := range changes {
+		for client := range h.clients {
+			for prefix := range client.alarms {
+				if strings.HasPrefix(change.Code, prefix) {
+					client.reply(change)
+					break
+				}
+			}
+		}
+	}
+}
+
+// diffLocations returns the locations of cur missing from old and those of old missing from cur
+func diffLocations(old, cur []weather.Location) (added, removed []weather.Location) {
+	in := func(l weather.Location, list []weather.Location) bool {
+		for _, v := range list {
+			if v == l {
+				return true
+			}
+		}
+		return false
+	}
+	for _, l := range cur {
+		if !in(l, old) {
+			added = append(added, l)
+		}
+	}
+	for _, l := range old {
+		if !in(l, cur) {
+			removed = append(removed, l)
+		}
+	}
+	return
+}
+
+// subscriptions is the reply of all the subscriptions of client, hub.mu must be held
+func (client *wsClient) subscriptions() wsReply {
+	reply := wsReply{Type: WS_TYPE_SUBSCRIBED, Cities: []string{}, Alarms: []string{}}
+	for code := range client.cities {
+		reply.Cities = append(reply.Cities, code)
+	}
+	for prefix := range client.alarms {
+		reply.Alarms = append(reply.Alarms, prefix)
+	}
+	return reply
+}
+
+// reply sends v as json to client
+func (client *wsClient) reply(v interface{}) {
+	msg, err := json.Marshal(v)
+	if nil != err {
+		log.Println("Error marshaling reply:", err)
+		return
+	}
+	client.push(msg)
+}
+
+func (client *wsClient) replyError(err error) {
+	client.reply(wsReply{Type: WS_TYPE_ERROR, ECode: weather.ErrorCode(err), RMsg: err.Error()})
+}
+
+// push queues msg without blocking, a client that cannot keep up is disconnected
+func (client *wsClient) push(msg []byte) {
+	select {
+	case <-client.done:
+	case client.send <- msg:
+	default:
+		log.Println("disconnect a slow websocket client", client.conn.Request().RemoteAddr)
+		client.close()
+	}
+}
+
+func (client *wsClient) close() {
+	client.closeOnce.Do(func() {
+		close(client.done)
+		client.conn.Close()
+	})
+}
+
+// writeLoop writes the queued messages and a heartbeat every WS_HEARTBEAT
+func (client *wsClient) writeLoop() {
+	heartbeat := time.NewTicker(WS_HEARTBEAT)
+	defer heartbeat.Stop()
+	ping, _ := json.Marshal(wsReply{Type: WS_TYPE_HEARTBEAT})
+	for {
+		var msg []byte
+		select {
+		case <-client.done:
+			return
+		case msg = <-client.send:
+		case <-heartbeat.C:
+			msg = ping
+		}
+		client.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+		if _, err := client.conn.Write(msg); nil != err {
+			client.close()
+			return
+		}
+	}
+}
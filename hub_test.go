@@ -0,0 +1,336 @@
+package main
+
+import (
+	"WeatherInfos/weather"
+	"fmt"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestHub serves a hub of its own weather handle, which has cached nothing
+func newTestHub(t *testing.T) (*wsHub, string) {
+	t.Helper()
+	h, err := newTestHandle(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	hub := &wsHub{
+		weather: h,
+		cities:  make(map[string]*wsCity),
+		clients: make(map[*wsClient]struct{}),
+	}
+	server := httptest.NewServer(websocket.Handler(hub.serve))
+	t.Cleanup(server.Close)
+	return hub, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dialHub(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, err := websocket.Dial(url, "", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// receive reads the next message of conn but the heartbeats
+func receive(t *testing.T, conn *websocket.Conn) wsReply {
+	t.Helper()
+	for {
+		var reply wsReply
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := websocket.JSON.Receive(conn, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if WS_TYPE_HEARTBEAT != reply.Type {
+			return reply
+		}
+	}
+}
+
+// waitHub waits until cond holds under hub.mu
+func waitHub(t *testing.T, hub *wsHub, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		hub.mu.Lock()
+		ok := cond()
+		hub.mu.Unlock()
+		if ok {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatal("the hub is not in the expected state")
+		}
+	}
+}
+
+func TestHubSubscribe(t *testing.T) {
+	hub, url := newTestHub(t)
+	/*先缓存，订阅时只回复一次天气*/
+	if _, err := hub.weather.ShowCityWeatherByCode("101020100"); err != nil {
+		t.Fatal(err)
+	}
+	conn := dialHub(t, url)
+	send := func(req wsRequest) {
+		t.Helper()
+		if err := websocket.JSON.Send(conn, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	/*未知城市报错，其余照常订阅*/
+	send(wsRequest{Action: WS_ACTION_SUBSCRIBE, Cities: []string{"101999999", " 101020100 "}, Alarms: []string{"10102", "abc"}})
+	if reply := receive(t, conn); WS_TYPE_ERROR != reply.Type || weather.ECODE_CITY_NOT_FOUND != reply.ECode {
+		t.Errorf("subscribe an unknown city: %+v", reply)
+	}
+	if reply := receive(t, conn); WS_TYPE_ERROR != reply.Type || weather.ECODE_BAD_PARAMETER != reply.ECode {
+		t.Errorf("subscribe the alarms of abc: %+v", reply)
+	}
+	if reply := receive(t, conn); WS_TYPE_SUBSCRIBED != reply.Type || "[101020100]" != fmt.Sprint(reply.Cities) || "[10102]" != fmt.Sprint(reply.Alarms) {
+		t.Errorf("subscribed %+v", reply)
+	}
+	/*订阅后立即收到天气*/
+	if reply := receive(t, conn); weather.EVENT_WEATHER != reply.Type || "101020100" != reply.Code {
+		t.Errorf("got %+v, want the weather of 101020100", reply)
+	}
+	if n := hub.weather.Watchers()["101020100"]; 1 != n {
+		t.Errorf("101020100 is watched %d times", n)
+	}
+
+	/*告警列表变化推送给订阅的地区*/
+	beijing := weather.Location{Name: "北京", FileName: "101010100-20260101.html", Code: "101010100"}
+	minhang := weather.Location{Name: "闵行", FileName: "101020200-20260101.html", Code: "101020200"}
+	hub.onAlarmList(map[string][]weather.Location{"10101": {beijing}, "1010202": {minhang}})
+	if reply := receive(t, conn); WS_TYPE_ALARM_LIST != reply.Type || "1010202" != reply.Code || 1 != len(reply.Added) || minhang != reply.Added[0] {
+		t.Errorf("got %+v, want the alarm of 闵行", reply)
+	}
+	hub.onAlarmList(map[string][]weather.Location{"10101": {beijing}})
+	if reply := receive(t, conn); WS_TYPE_ALARM_LIST != reply.Type || 0 != len(reply.Added) || 1 != len(reply.Removed) || minhang != reply.Removed[0] {
+		t.Errorf("got %+v, want the alarm of 闵行 removed", reply)
+	}
+
+	send(wsRequest{Action: WS_ACTION_PING})
+	if reply := receive(t, conn); WS_TYPE_PONG != reply.Type {
+		t.Errorf("ping: %+v", reply)
+	}
+	send(wsRequest{Action: "watch"})
+	if reply := receive(t, conn); WS_TYPE_ERROR != reply.Type || weather.ECODE_BAD_PARAMETER != reply.ECode {
+		t.Errorf("unknown action: %+v", reply)
+	}
+
+	/*退订后不再监听该城市*/
+	send(wsRequest{Action: WS_ACTION_UNSUBSCRIBE, Cities: []string{"101020100"}})
+	if reply := receive(t, conn); WS_TYPE_SUBSCRIBED != reply.Type || 0 != len(reply.Cities) || "[10102]" != fmt.Sprint(reply.Alarms) {
+		t.Errorf("unsubscribed %+v", reply)
+	}
+	if n := hub.weather.Watchers(); 0 != len(n) {
+		t.Errorf("watchers %v after unsubscribe", n)
+	}
+	send(wsRequest{Action: WS_ACTION_UNSUBSCRIBE, Alarms: []string{"10102"}})
+	if reply := receive(t, conn); WS_TYPE_SUBSCRIBED != reply.Type || 0 != len(reply.Cities) || 0 != len(reply.Alarms) {
+		t.Errorf("unsubscribed %+v", reply)
+	}
+	hub.onAlarmList(map[string][]weather.Location{"10101": {beijing}, "1010202": {minhang}})
+	send(wsRequest{Action: WS_ACTION_PING})
+	if reply := receive(t, conn); WS_TYPE_PONG != reply.Type {
+		t.Errorf("got %+v after unsubscribing the alarms", reply)
+	}
+
+	/*断开后释放订阅*/
+	send(wsRequest{Action: WS_ACTION_SUBSCRIBE, Cities: []string{"101020100"}})
+	receive(t, conn)
+	conn.Close()
+	waitHub(t, hub, func() bool { return 0 == len(hub.clients) && 0 == len(hub.cities) })
+	if n := hub.weather.Watchers(); 0 != len(n) {
+		t.Errorf("watchers %v after disconnect", n)
+	}
+}
+
+func TestHubLimit(t *testing.T) {
+	_, url := newTestHub(t)
+	conn := dialHub(t, url)
+	var req wsRequest
+	req.Action = WS_ACTION_SUBSCRIBE
+	req.Cities = []string{"101020100"}
+	for i := 0; i < WS_MAX_SUBSCRIPTIONS+2; i++ {
+		req.Alarms = append(req.Alarms, fmt.Sprintf("101%02d", i))
+	}
+	if err := websocket.JSON.Send(conn, req); err != nil {
+		t.Fatal(err)
+	}
+	if reply := receive(t, conn); WS_TYPE_ERROR != reply.Type || weather.ECODE_BAD_PARAMETER != reply.ECode {
+		t.Errorf("got %+v, want the limit error", reply)
+	}
+	reply := receive(t, conn)
+	if WS_TYPE_SUBSCRIBED != reply.Type || 1 != len(reply.Cities) || WS_MAX_SUBSCRIPTIONS-1 != len(reply.Alarms) {
+		t.Errorf("subscribed %d cities and %d alarms, want 1 and %d", len(reply.Cities), len(reply.Alarms), WS_MAX_SUBSCRIPTIONS-1)
+	}
+
+	/*已订阅的不计入，新的被拒绝*/
+	req.Cities, req.Alarms = []string{"101020100", "101020200"}, []string{"10100"}
+	websocket.JSON.Send(conn, req)
+	for {
+		reply := receive(t, conn)
+		if weather.EVENT_WEATHER == reply.Type {
+			continue /*第一次订阅的天气*/
+		}
+		if WS_TYPE_ERROR != reply.Type || weather.ECODE_BAD_PARAMETER != reply.ECode {
+			t.Errorf("got %+v, want the limit error", reply)
+		}
+		break
+	}
+	if reply := receive(t, conn); WS_TYPE_SUBSCRIBED != reply.Type || WS_MAX_SUBSCRIPTIONS != len(reply.Cities)+len(reply.Alarms) {
+		t.Errorf("subscribed %+v", reply)
+	}
+}
+
+func TestHubFanOut(t *testing.T) {
+	hub, url := newTestHub(t)
+	/*先缓存，订阅时不再推送天气事件*/
+	if _, err := hub.weather.ShowCityWeatherByCode("101020100"); err != nil {
+		t.Fatal(err)
+	}
+	var conns []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		conn := dialHub(t, url)
+		websocket.JSON.Send(conn, wsRequest{Action: WS_ACTION_SUBSCRIBE, Cities: []string{"101020100"}})
+		if reply := receive(t, conn); WS_TYPE_SUBSCRIBED != reply.Type {
+			t.Fatalf("client %d: %+v", i, reply)
+		}
+		if reply := receive(t, conn); weather.EVENT_WEATHER != reply.Type {
+			t.Fatalf("client %d: %+v", i, reply)
+		}
+		conns = append(conns, conn)
+	}
+	if n := hub.weather.Watchers()["101020100"]; 1 != n {
+		t.Errorf("101020100 is watched %d times, want once for all the clients", n)
+	}
+
+	/*重新抓取后推送给每个连接*/
+	hub.weather.RemoveOldest()
+	if _, err := hub.weather.ShowCityWeatherByCode("101020100"); err != nil {
+		t.Fatal(err)
+	}
+	for i, conn := range conns {
+		if reply := receive(t, conn); weather.EVENT_WEATHER != reply.Type || "101020100" != reply.Code {
+			t.Errorf("client %d: %+v", i, reply)
+		}
+	}
+
+	/*一个连接断开不影响其他连接*/
+	conns[0].Close()
+	waitHub(t, hub, func() bool { return 2 == len(hub.cities["101020100"].clients) })
+	hub.weather.RemoveOldest()
+	hub.weather.ShowCityWeatherByCode("101020100")
+	for i, conn := range conns[1:] {
+		if reply := receive(t, conn); weather.EVENT_WEATHER != reply.Type {
+			t.Errorf("client %d: %+v", i+1, reply)
+		}
+	}
+}
+
+func TestHubSlowClient(t *testing.T) {
+	hub, _ := newTestHub(t)
+	/*不启动writeLoop，发送缓冲区满后断开*/
+	clients := make(chan *wsClient)
+	server := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		client := &wsClient{
+			conn:   conn,
+			send:   make(chan []byte, WS_SEND_BUFFER),
+			done:   make(chan struct{}),
+			cities: make(map[string]struct{}),
+			alarms: map[string]struct{}{"10102": {}},
+		}
+		hub.mu.Lock()
+		hub.clients[client] = struct{}{}
+		hub.mu.Unlock()
+		clients <- client
+		<-client.done
+		hub.leave(client)
+	}))
+	defer server.Close()
+	conn := dialHub(t, "ws"+strings.TrimPrefix(server.URL, "http"))
+	client := <-clients
+
+	for i := 0; i < WS_SEND_BUFFER; i++ {
+		hub.onAlarmList(map[string][]weather.Location{"1010202": {{FileName: fmt.Sprintf("%d.html", i)}}})
+	}
+	select {
+	case <-client.done:
+		t.Fatal("disconnected before the send buffer is full")
+	default:
+	}
+	hub.onAlarmList(nil)
+	select {
+	case <-client.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow client is still connected")
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); nil == err {
+		t.Error("the connection of the slow client is open")
+	}
+	waitHub(t, hub, func() bool { return 0 == len(hub.clients) })
+}
+
+func TestWsOrigin(t *testing.T) {
+	/*只检查握手，不启动hub*/
+	server := httptest.NewServer(websocket.Server{
+		Handshake: checkOrigin,
+		Handler:   func(conn *websocket.Conn) { conn.Close() },
+	})
+	defer server.Close()
+	self := server.URL
+	url := "ws" + strings.TrimPrefix(self, "http")
+
+	defer func(origins string) { *wsOrigins = origins }(*wsOrigins)
+	for _, tc := range []struct {
+		origins string /*-ws-origins*/
+		origin  string /*空表示不发送Origin*/
+		allowed bool
+	}{
+		{"", "", true},
+		{"", self, true},
+		{"", strings.ToUpper(self), true},
+		{"", "https://evil.example", false},
+		{"", "null", false},
+		{"https://app.example, https://b.example/", "https://b.example", true},
+		{"https://app.example", "http://app.example", false},
+		{"https://app.example", "https://app.example.evil", false},
+		{"*", "https://evil.example", true},
+	} {
+		*wsOrigins = tc.origins
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if "" != tc.origin {
+			req.Header.Set("Origin", tc.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if allowed := http.StatusSwitchingProtocols == resp.StatusCode; allowed != tc.allowed {
+			t.Errorf("origin %q with -ws-origins %q: status %d", tc.origin, tc.origins, resp.StatusCode)
+		}
+	}
+
+	/*websocket客户端*/
+	*wsOrigins = ""
+	if conn, err := websocket.Dial(url, "", self); err != nil {
+		t.Errorf("Dial() from %s: %v", self, err)
+	} else {
+		conn.Close()
+	}
+	if _, err := websocket.Dial(url, "", "https://evil.example"); nil == err {
+		t.Errorf("Dial() from another site is accepted")
+	}
+}
@@ -1,71 +1,298 @@
 package lrucache
 
-import "container/list"
+import (
+	"container/list"
+	"sync"
+	"time"
+)
 
-/* Cache is an LRU cache. It is not safe for concurrent access.*/
-type Cache struct {
-	MaxEntries int                              /*最大数量限制，0表示不限制*/
-	OnEvicted  func(key Key, value interface{}) /*驱逐回调，从缓存清除条目时执行*/
-	ll         *list.List                       /*用于lru管理的list*/
-	cache      map[interface{}]*list.Element    /*缓存*/
+/*
+Cache is an LRU cache with per-entry expiry. It is safe for concurrent access.
+过期的条目不再由Get返回，在Grace时间内仍可以通过Stale取得；超过Grace后在访问时或由RemoveExpired删除。
+*/
+type Cache[K comparable, V any] struct {
+	MaxEntries int                        /*最大数量限制，0表示不限制*/
+	MaxBytes   int64                      /*占用字节数限制，0表示不限制，需要设置Size*/
+	Size       func(key K, value V) int64 /*条目占用的字节数，nil时按0计算*/
+	TTL        time.Duration              /*Add使用的过期时间，0表示不过期*/
+	Grace      time.Duration              /*过期后仍保留的时间，期间可通过Stale取得*/
+	OnEvicted  func(key K, value V)       /*驱逐回调，从缓存清除条目时执行；执行时持有缓存的锁，不能在回调中访问该缓存*/
+
+	mu     sync.Mutex
+	ll     *list.List          /*用于lru管理的list*/
+	cache  map[K]*list.Element /*缓存*/
+	nbytes int64               /*所有条目的Size之和*/
+	stats  Stats
+}
+
+/*缓存的统计*/
+type Stats struct {
+	Items     int64 /*条目数，包括已过期但未删除的*/
+	Bytes     int64 /*所有条目的Size之和*/
+	Gets      int64 /*Get的次数*/
+	Hits      int64 /*Get命中的次数*/
+	Evictions int64 /*因数量或字节数限制被驱逐的条目数*/
+	Expired   int64 /*因过期被删除的条目数*/
 }
 
-/*key是可以比较的任意类型*/
-type Key interface{}
+/*导出的条目，用于快照及恢复*/
+type Item[K comparable, V any] struct {
+	Key    K
+	Value  V
+	Expire time.Time /*零值表示不过期*/
+}
 
 /*缓存的k/v对*/
-type entry struct {
-	key   Key
-	value interface{}
+type entry[K comparable, V any] struct {
+	key    K
+	value  V
+	size   int64
+	expire time.Time /*零值表示不过期*/
 }
 
-/*创建cache*/
-func New(maxEntries int) *Cache {
-	return &Cache{
+/*创建cache，ttl为Add使用的过期时间*/
+func New[K comparable, V any](maxEntries int, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
 		MaxEntries: maxEntries,
+		TTL:        ttl,
 		ll:         list.New(),
-		cache:      make(map[interface{}]*list.Element),
+		cache:      make(map[K]*list.Element),
 	}
 }
 
-/*向cache添加一个键值对*/
-func (c *Cache) Add(key Key, value interface{}) {
+/*向cache添加一个键值对，使用c.TTL作为过期时间*/
+func (c *Cache[K, V]) Add(key K, value V) {
+	c.AddWithTTL(key, value, c.TTL)
+}
+
+/*向cache添加一个键值对，ttl为0表示不过期*/
+func (c *Cache[K, V]) AddWithTTL(key K, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	c.add(key, value, expire)
+}
+
+/*添加或替换条目并按限制驱逐，c.mu必须已锁定*/
+func (c *Cache[K, V]) add(key K, value V, expire time.Time) {
 	if c.cache == nil { //安全检查
-		c.cache = make(map[interface{}]*list.Element)
+		c.cache = make(map[K]*list.Element)
 		c.ll = list.New()
 	}
-	if ee, ok := c.cache[key]; ok { //如果存在，则在链表中将ee插入到最前，并更新ee的值  set a 123; set a 456
-		c.ll.MoveToFront(ee) //链表的基本操作，1、使用删除获取该元素的地址 2、重新将获取的地址插入到链表最前
-		ee.Value.(*entry).value = value
+	var size int64
+	if c.Size != nil {
+		size = c.Size(key, value)
+	}
+	if c.MaxBytes > 0 && size > c.MaxBytes {
+		//单个条目超过MaxBytes时不保存，也不为它驱逐其他条目；key原来的值已过时，一并删除
+		if ee, ok := c.cache[key]; ok {
+			c.removeElement(ee)
+		}
+		c.stats.Evictions++
 		return
 	}
-	//如果不存在，则创建新的内部对象，并放到最前
-	//链表中保存内部k/v对象的地址，并返回在链表中的元素地址
-	ele := c.ll.PushFront(&entry{key, value})
+	if ee, ok := c.cache[key]; ok { //如果存在，则在链表中将ee插入到最前，并更新ee的值、大小和过期时间
+		c.ll.MoveToFront(ee)
+		e := ee.Value.(*entry[K, V])
+		c.nbytes += size - e.size
+		e.value = value
+		e.size = size
+		e.expire = expire
+	} else {
+		//如果不存在，则创建新的内部对象，并放到最前
+		ele := c.ll.PushFront(&entry[K, V]{key, value, size, expire})
+		c.cache[key] = ele
+		c.nbytes += size
+	}
 
-	//缓存中保存了 [key]内部k/v对象在链表中的元素地址
-	c.cache[key] = ele
+	//判断缓存数量及字节数，是否超过最大值，如果超过，则移出旧的对象（链表末尾表示所有缓存对象中，最长时间未被使用）
+	for c.ll.Len() > 0 && ((c.MaxEntries != 0 && c.ll.Len() > c.MaxEntries) || (c.MaxBytes > 0 && c.nbytes > c.MaxBytes)) {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
 
-	//判断缓存数量，是否超过最大值，如果超过，则移出旧的对象（链表末尾表示所有缓存对象中，最长时间未被使用）
-	if c.MaxEntries != 0 && c.ll.Len() > c.MaxEntries {
-		c.RemoveOldest()
+/*
+用fn返回的值替换key未超过Grace的条目，保留它的过期时间并重新计算大小，返回是否替换。
+fn取得当前的值，返回false时不替换；执行时持有缓存的锁，不能在fn中访问该缓存。
+*/
+func (c *Cache[K, V]) Update(key K, fn func(value V) (V, bool)) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ele := c.lookup(key, time.Now())
+	if ele == nil {
+		return false
+	}
+	e := ele.Value.(*entry[K, V])
+	value, ok := fn(e.value)
+	if !ok {
+		return false
 	}
+	c.add(key, value, e.expire)
+	return true
 }
 
-/*获取时，如果元素存在，则将元素的位置在链表中更新*/
-func (c *Cache) Get(key Key) (value interface{}, ok bool) {
+/*按从旧到新的顺序导出所有未超过Grace的条目，包括它们的过期时间*/
+func (c *Cache[K, V]) Snapshot() []Item[K, V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.cache == nil {
+		return nil
+	}
+	now := time.Now()
+	items := make([]Item[K, V], 0, c.ll.Len())
+	for ele := c.ll.Back(); ele != nil; ele = ele.Prev() {
+		if c.dead(ele, now) {
+			continue
+		}
+		e := ele.Value.(*entry[K, V])
+		items = append(items, Item[K, V]{Key: e.key, Value: e.value, Expire: e.expire})
+	}
+	return items
+}
+
+/*
+按顺序恢复Snapshot导出的条目，保留原来的过期时间，最后一个条目最新。
+已超过Grace的条目及缓存中已存在的key被忽略，返回恢复的数量。
+*/
+func (c *Cache[K, V]) Restore(items []Item[K, V]) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var n int
+	for _, item := range items {
+		if !item.Expire.IsZero() && !now.Before(item.Expire.Add(c.Grace)) {
+			continue
+		}
+		if _, ok := c.cache[item.Key]; ok {
+			continue
+		}
+		c.add(item.Key, item.Value, item.Expire)
+		n++
+	}
+	return n
+}
+
+/*获取未过期的元素，并将元素的位置在链表中更新；已超过Grace的元素被删除*/
+func (c *Cache[K, V]) Get(key K) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Gets++
+	now := time.Now()
+	ele := c.lookup(key, now)
+	if ele == nil || c.expired(ele, now) {
 		return
 	}
-	if ele, hit := c.cache[key]; hit {
-		c.ll.MoveToFront(ele)
-		return ele.Value.(*entry).value, true
+	c.ll.MoveToFront(ele)
+	c.stats.Hits++
+	return ele.Value.(*entry[K, V]).value, true
+}
+
+/*获取未过期的元素，不更新它在链表中的位置*/
+func (c *Cache[K, V]) Peek(key K) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	ele := c.lookup(key, now)
+	if ele == nil || c.expired(ele, now) {
+		return
+	}
+	return ele.Value.(*entry[K, V]).value, true
+}
+
+/*获取元素，包括已过期但仍在Grace时间内的，fresh表示是否未过期；不更新它在链表中的位置*/
+func (c *Cache[K, V]) Stale(key K) (value V, fresh bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	ele := c.lookup(key, now)
+	if ele == nil {
+		return
+	}
+	return ele.Value.(*entry[K, V]).value, !c.expired(ele, now), true
+}
+
+/*查找元素，已超过Grace的元素被删除（惰性过期），c.mu必须已锁定*/
+func (c *Cache[K, V]) lookup(key K, now time.Time) *list.Element {
+	if c.cache == nil {
+		return nil
+	}
+	ele, hit := c.cache[key]
+	if !hit {
+		return nil
+	}
+	if c.dead(ele, now) {
+		c.removeElement(ele)
+		c.stats.Expired++
+		return nil
+	}
+	return ele
+}
+
+/*元素是否已过期*/
+func (c *Cache[K, V]) expired(e *list.Element, now time.Time) bool {
+	expire := e.Value.(*entry[K, V]).expire
+	return !expire.IsZero() && !now.Before(expire)
+}
+
+/*元素是否已超过Grace，可以删除*/
+func (c *Cache[K, V]) dead(e *list.Element, now time.Time) bool {
+	expire := e.Value.(*entry[K, V]).expire
+	return !expire.IsZero() && !now.Before(expire.Add(c.Grace))
+}
+
+/*删除所有超过Grace的元素（主动过期），返回删除的数量*/
+func (c *Cache[K, V]) RemoveExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		return 0
+	}
+	now := time.Now()
+	var n int
+	for ele := c.ll.Back(); ele != nil; {
+		prev := ele.Prev()
+		if c.dead(ele, now) {
+			c.removeElement(ele)
+			n++
+		}
+		ele = prev
+	}
+	c.stats.Expired += int64(n)
+	return n
+}
+
+/*每隔interval执行一次RemoveExpired，调用返回的函数停止，它返回后不会再清理*/
+func (c *Cache[K, V]) StartJanitor(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	var once sync.Once
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.RemoveExpired()
+			}
+		}
+	}()
+	return func() {
+		once.Do(func() { close(done) })
+		<-exited
 	}
-	return
 }
 
 /*从缓存中删除一个元素*/
-func (c *Cache) Remove(key Key) {
+func (c *Cache[K, V]) Remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.cache == nil {
 		return
 	}
@@ -75,7 +302,9 @@ func (c *Cache) Remove(key Key) {
 }
 
 /*移除旧的对象（链表末尾表示所有缓存对象中，最长时间未被使用）*/
-func (c *Cache) RemoveOldest() {
+func (c *Cache[K, V]) RemoveOldest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.cache == nil {
 		return
 	}
@@ -85,31 +314,56 @@ func (c *Cache) RemoveOldest() {
 	}
 }
 
-func (c *Cache) removeElement(e *list.Element) {
+func (c *Cache[K, V]) removeElement(e *list.Element) {
 	c.ll.Remove(e) //从链表中移除旧对象
-	kv := e.Value.(*entry)
+	kv := e.Value.(*entry[K, V])
 	delete(c.cache, kv.key) //从缓存中删除记录
+	c.nbytes -= kv.size
 	if c.OnEvicted != nil {
 		c.OnEvicted(kv.key, kv.value) //删除时回调
 	}
 }
 
-/*获取长度*/
-func (c *Cache) Len() int {
+/*获取长度，包括已过期但未删除的元素*/
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.cache == nil {
 		return 0
 	}
 	return c.ll.Len()
 }
 
+/*获取所有条目的Size之和*/
+func (c *Cache[K, V]) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nbytes
+}
+
+/*获取统计*/
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	if c.ll != nil {
+		stats.Items = int64(c.ll.Len())
+	}
+	stats.Bytes = c.nbytes
+	return stats
+}
+
 /*清空整个缓存*/
-func (c *Cache) Clear() {
+func (c *Cache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.OnEvicted != nil {
 		for _, e := range c.cache {
-			kv := e.Value.(*entry)
+			kv := e.Value.(*entry[K, V])
 			c.OnEvicted(kv.key, kv.value)
 		}
 	}
 	c.ll = nil
 	c.cache = nil
+	c.nbytes = 0
 }
@@ -0,0 +1,185 @@
+package lrucache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+/*已经过期的ttl，Add之后立即过期*/
+const expiredTTL = time.Nanosecond
+
+func addExpired[K comparable, V any](c *Cache[K, V], key K, value V) {
+	c.AddWithTTL(key, value, expiredTTL)
+	time.Sleep(time.Millisecond)
+}
+
+func TestExpiry(t *testing.T) {
+	c := New[string, int](0, time.Hour)
+	c.Add("fresh", 1)
+	c.AddWithTTL("forever", 2, 0)
+	addExpired(c, "expired", 3)
+
+	if v, ok := c.Get("fresh"); !ok || 1 != v {
+		t.Errorf("Get(fresh) = %v, %v", v, ok)
+	}
+	if v, ok := c.Get("forever"); !ok || 2 != v {
+		t.Errorf("Get(forever) = %v, %v", v, ok)
+	}
+	if v, ok := c.Get("expired"); ok {
+		t.Errorf("Get(expired) = %v, want a miss", v)
+	}
+	/*没有Grace，过期的条目在访问时删除*/
+	if _, _, ok := c.Stale("expired"); ok {
+		t.Error("Stale(expired) without grace found the entry")
+	}
+	if stats := c.Stats(); 2 != stats.Items || 1 != stats.Expired || 3 != stats.Gets || 2 != stats.Hits {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestGrace(t *testing.T) {
+	c := New[string, int](0, time.Hour)
+	c.Grace = time.Hour
+	c.Add("fresh", 1)
+	addExpired(c, "stale", 2)
+
+	if v, ok := c.Get("stale"); ok {
+		t.Errorf("Get(stale) = %v, want a miss", v)
+	}
+	if v, ok := c.Peek("stale"); ok {
+		t.Errorf("Peek(stale) = %v, want a miss", v)
+	}
+	if v, fresh, ok := c.Stale("stale"); !ok || fresh || 2 != v {
+		t.Errorf("Stale(stale) = %v, %v, %v; want 2, not fresh", v, fresh, ok)
+	}
+	if v, fresh, ok := c.Stale("fresh"); !ok || !fresh || 1 != v {
+		t.Errorf("Stale(fresh) = %v, %v, %v; want 1, fresh", v, fresh, ok)
+	}
+	if n := c.RemoveExpired(); 0 != n || 2 != c.Len() {
+		t.Errorf("RemoveExpired() removed %d entries in grace, %d left", n, c.Len())
+	}
+	if items := c.Snapshot(); 2 != len(items) {
+		t.Errorf("Snapshot() = %+v, want the stale entry too", items)
+	}
+
+	c.Grace = 0
+	if n := c.RemoveExpired(); 1 != n || 1 != c.Len() {
+		t.Errorf("RemoveExpired() after grace removed %d entries, %d left", n, c.Len())
+	}
+}
+
+func TestPeekKeepsRecency(t *testing.T) {
+	c := New[string, int](2, 0)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Peek("a")
+	c.Stale("a")
+	c.Add("c", 3)
+	if _, ok := c.Peek("a"); ok {
+		t.Error("Peek() made a recent, want it evicted as the oldest")
+	}
+
+	c = New[string, int](2, 0)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Get("a")
+	c.Add("c", 3)
+	if _, ok := c.Peek("b"); ok {
+		t.Error("Get() did not make a recent, want b evicted")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	c := New[string, string](0, time.Hour)
+	c.Size = func(key, value string) int64 { return int64(len(value)) }
+	c.Add("k", "v")
+	expire := c.Snapshot()[0].Expire
+
+	if c.Update("k", func(v string) (string, bool) { return "ignored", false }) {
+		t.Error("Update() replaced the value when fn refused")
+	}
+	if !c.Update("k", func(v string) (string, bool) { return v + "alue", true }) {
+		t.Fatal("Update() did not replace the value")
+	}
+	items := c.Snapshot()
+	if 1 != len(items) || "value" != items[0].Value || !items[0].Expire.Equal(expire) {
+		t.Errorf("after Update() %+v, want value with the expiry %v", items, expire)
+	}
+	if 5 != c.Bytes() {
+		t.Errorf("Bytes() = %d after Update(), want 5", c.Bytes())
+	}
+	if c.Update("missing", func(v string) (string, bool) { return "x", true }) {
+		t.Error("Update() added a missing key")
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	c := New[string, int](0, time.Hour)
+	addExpired(c, "a", 1)
+	c.Add("b", 2)
+	stop := c.StartJanitor(time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for 1 != c.Len() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the janitor left %d entries", c.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	stop()
+
+	addExpired(c, "c", 3)
+	time.Sleep(20 * time.Millisecond)
+	if 2 != c.Len() {
+		t.Errorf("the stopped janitor still sweeps, %d entries left", c.Len())
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	c := New[string, []byte](0, 0)
+	c.MaxBytes = 100
+	c.Size = func(key string, value []byte) int64 { return int64(len(key) + len(value)) }
+	var evicted []string
+	c.OnEvicted = func(key string, value []byte) { evicted = append(evicted, key) }
+
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		c.Add(key, make([]byte, 29)) /*每个条目30字节*/
+		if c.Bytes() > c.MaxBytes {
+			t.Fatalf("Bytes() = %d after adding %s, over MaxBytes %d", c.Bytes(), key, c.MaxBytes)
+		}
+	}
+	if 90 != c.Bytes() || 3 != c.Len() || 3 != c.Stats().Evictions {
+		t.Errorf("Bytes() = %d, Len() = %d, %+v; want the newest 3 entries", c.Bytes(), c.Len(), c.Stats())
+	}
+	if want := "a,b,c"; want != strings.Join(evicted, ",") {
+		t.Errorf("evicted %s, want %s", strings.Join(evicted, ","), want)
+	}
+
+	/*替换条目时重新计算大小*/
+	c.Add("d", make([]byte, 9))
+	if 70 != c.Bytes() {
+		t.Errorf("Bytes() = %d after shrinking d, want 70", c.Bytes())
+	}
+	c.Add("d", make([]byte, 49))
+	if 80 != c.Bytes() || 2 != c.Len() {
+		t.Errorf("Bytes() = %d, Len() = %d after growing d, want e evicted", c.Bytes(), c.Len())
+	}
+	if _, ok := c.Peek("e"); ok {
+		t.Error("growing d did not evict the oldest e")
+	}
+
+	/*超过整个预算的条目被拒绝，不驱逐其他条目*/
+	c.Add("huge", make([]byte, 200))
+	if _, ok := c.Peek("huge"); ok {
+		t.Error("an entry over the budget is kept")
+	}
+	if 80 != c.Bytes() || 2 != c.Len() {
+		t.Errorf("Bytes() = %d, Len() = %d, want the other entries kept", c.Bytes(), c.Len())
+	}
+	/*替换为超过预算的值时，旧值也不再保留*/
+	c.Add("d", make([]byte, 200))
+	if _, ok := c.Peek("d"); ok || 30 != c.Bytes() {
+		t.Errorf("d is still cached after an oversized replace, Bytes() = %d", c.Bytes())
+	}
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	"WeatherInfos/weather"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/mozillazg/go-pinyin"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -23,15 +27,43 @@ const (
 	LOG_FILE        = "./logs/weather.log"
 	FIELD_NAME      = "city"
 	FIELD_NAME_CODE = "cityCode"
+	FIELD_LAT       = "lat"
+	FIELD_LON       = "lon"
+	FIELD_QUERY     = "q"
+	FIELD_LIMIT     = "limit"
+	FIELD_FORMAT    = "format"
+	FIELD_CITIES    = "cities"
 	STR_SEP         = ","
+
+	ECODE_METHOD_NOT_ALLOWED = "method_not_allowed"
+	ECODE_FORBIDDEN          = "forbidden"
+
+	API_V1_PREFIX = "/v1" /*与不带版本的路径相同*/
+	API_V2_PREFIX = "/v2" /*规范化后的数据格式，见weather/v2.go*/
+
+	BATCH_BODY_LIMIT = 64 << 10         /*批量查询请求体的最大字节数*/
+	STREAM_KEEPALIVE = 30 * time.Second /*SSE的心跳间隔，避免代理断开空闲连接*/
 )
 
+var EXPORT_CONTENT_TYPES = map[string]string{
+	weather.EXPORT_FORMAT_CSV:     "text/csv; charset=utf-8",
+	weather.EXPORT_FORMAT_JSON:    "application/json; charset=utf-8",
+	weather.EXPORT_FORMAT_GEOJSON: "application/geo+json; charset=utf-8",
+}
+
 var (
 	iServices = flag.Bool("s", false, "To running as a services")
 	port      = flag.Int("port", 3244, "The TCP port that the server listens on")
 	address   = flag.String("address", "", "The net address that the server listens")
 	crt       = flag.String("crt", "", "Specify the server credential file")
 	key       = flag.String("key", "", "Specify the server key file")
+	regionGap = flag.Int("region-refresh", 24*7, "The interval in hours to crawl the region tree again, 0 to disable")
+	crawlers  = flag.Int("crawl-workers", weather.CRAWL_DEFAULT_WORKERS, "The number of province pages crawled at the same time")
+	crawlGap  = flag.Int("crawl-interval", int(weather.CRAWL_DEFAULT_INTERVAL/time.Millisecond), "The minimum interval in milliseconds between two requests to weather.com.cn while crawling")
+	cacheFile = flag.String("cache-file", weather.CACHE_SNAPSHOT_FILE, "The file the weather caches are saved to and restored from across restarts, empty to disable")
+	cacheGap  = flag.Int("cache-snapshot", int(weather.CACHE_SNAPSHOT_INTERVAL/time.Minute), "The interval in minutes to save the weather caches, 0 to save only on shutdown")
+	adminKey  = flag.String("admin-token", os.Getenv("WEATHER_ADMIN_TOKEN"), "The bearer token of the /admin endpoints, without it only the clients on the loopback may call them")
+	wsOrigins = flag.String("ws-origins", "", "The comma separated origins such as https://example.com whose pages may open /weather/ws besides this server's own, * for any")
 	handle    *weather.Weather
 	once      sync.Once
 	sigs      = make(chan os.Signal, 1)
@@ -40,18 +72,32 @@ var (
 
 func init() {
 	flag.CommandLine.Usage = help
+}
+
+// setupLog writes the log to LOG_FILE when the logs directory exists, it is not done in init so the tests leave no log file
+func setupLog() {
 	if logout, err := os.OpenFile(LOG_FILE, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666); err == nil {
 		log.SetOutput(logout)
 		log.SetPrefix("[Info] ")
 		log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 		log.Println(time.Now().Format(time.RFC3339), strings.Title(runtime.GOARCH), strings.Title(runtime.GOOS))
 	} else {
-		fmt.Println(time.Now().Format(time.RFC3339), strings.Title(runtime.GOARCH), strings.Title(runtime.GOOS))
-		fmt.Printf("Not found the [ logs ] directory, the log will be displayed on the terminal\n")
+		var out io.Writer = os.Stdout
+		if len(os.Args) > 1 && nil != commands[os.Args[1]] { /*子命令可能输出到stdout*/
+			out = os.Stderr
+		}
+		fmt.Fprintln(out, time.Now().Format(time.RFC3339), strings.Title(runtime.GOARCH), strings.Title(runtime.GOOS))
+		fmt.Fprintf(out, "Not found the [ logs ] directory, the log will be displayed on the terminal\n")
 	}
 }
 
 func main() {
+	setupLog()
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
 	if !flag.Parsed() {
 		flag.Parse()
 	}
@@ -64,14 +110,15 @@ func main() {
 	//根据设定的间隔去进行告警列表的获取
 	go weather.CheckAlarmListFromWeatherCom()
 
-	GetWeatherHandle()
+	weatherHandle := GetWeatherHandle()
+	if *regionGap > 0 {
+		weatherHandle.StartRegionRefresh(time.Duration(*regionGap) * time.Hour)
+	}
+	if "" != *cacheFile && *cacheGap > 0 {
+		weatherHandle.StartCacheSnapshot(*cacheFile, time.Duration(*cacheGap)*time.Minute)
+	}
 
-	router := http.NewServeMux()
-	router.HandleFunc("/", safe_http_handle(safe_statement))
-	router.HandleFunc("/weather", safe_http_handle(ShowWeather))
-	router.HandleFunc("/weather/forty", safe_http_handle(ShowFortyWeather))
-	router.HandleFunc("/citylist", safe_http_handle(ShowCityList))
-	router.HandleFunc("/weather/status", safe_http_handle(ShowStatus))
+	router := newRouter()
 
 	fmt.Printf("Service listen on %s:%d\n", *address, *port)
 	log.Printf("Service listen on %s:%d\n", *address, *port)
@@ -88,6 +135,37 @@ func main() {
 	}
 }
 
+// newRouter routes every endpoint described in openapi.json
+func newRouter() *http.ServeMux {
+	router := http.NewServeMux()
+	router.HandleFunc("/", safe_http_handle(safe_statement))
+	router.HandleFunc("/openapi.json", safe_http_handle(ShowOpenAPI))
+	/*旧客户端使用的不带版本的路径与/v1的响应完全相同*/
+	for pattern, fn := range map[string]http.HandlerFunc{
+		"/weather":              ShowWeather,
+		"/weather/forty":        ShowFortyWeather,
+		"/weather/batch":        ShowBatchWeather,
+		"/weather/stream":       StreamWeather,
+		"/weather/ws":           SubscribeWeather,
+		"/citylist":             ShowCityList,
+		"/search":               SearchRegions,
+		"/weather/status":       ShowStatus,
+		"/admin/region/refresh": adminOnly(RefreshRegion),
+	} {
+		router.HandleFunc(pattern, safe_http_handle(fn))
+		router.HandleFunc(API_V1_PREFIX+pattern, safe_http_handle(fn))
+	}
+	/*同一个处理函数，由versioned按路径转换为v2的格式*/
+	for pattern, fn := range map[string]http.HandlerFunc{
+		"/weather":       ShowWeather,
+		"/weather/forty": ShowFortyWeather,
+		"/weather/batch": ShowBatchWeather,
+	} {
+		router.HandleFunc(API_V2_PREFIX+pattern, safe_http_handle(fn))
+	}
+	return router
+}
+
 func safe_statement(w http.ResponseWriter, r *http.Request) {
 	t := time.Now().Format("2006-01-02 15:04:05Z07:00")
 	fmt.Fprintf(w, "<h1 align=\"center\">This is a laboratory test environment.</h1>")
@@ -97,74 +175,175 @@ func safe_statement(w http.ResponseWriter, r *http.Request) {
 
 func GetWeatherHandle() (weatherhandle *weather.Weather) {
 	once.Do(func() {
-		handle = weather.New(int(weather.DEFAULT_LIMIT_SIZE))
+		handle = weather.New(int(weather.DEFAULT_LIMIT_SIZE), weather.NewWeatherCom())
+		crawler := weather.NewCrawler()
+		crawler.Workers = *crawlers
+		crawler.Interval = time.Duration(*crawlGap) * time.Millisecond
+		handle.SetCrawler(crawler)
 		if err := handle.InitRegionTree(); err != nil {
 			log.Println(err)
 		}
+		if "" != *cacheFile {
+			if _, err := handle.LoadCache(*cacheFile); err != nil && !os.IsNotExist(err) {
+				log.Println("Load the weather caches from", *cacheFile, "failed", err)
+			}
+		}
+		weather.OnAlarmListUpdate(func(infos map[string][]weather.Location) {
+			if n := handle.SeedCoordinates(infos); n > 0 {
+				log.Println("seed coordinates of", n, "stations from the alarm list")
+			}
+		})
 	})
 	return handle
 }
 
 func ShowStatus(w http.ResponseWriter, r *http.Request) {
 	weatherHandle := GetWeatherHandle()
-	status := weatherHandle.Stats()
-	w.Header().Add("Content-Type", "application/json")
-	strStatus, _ := json.Marshal(status)
-	w.Write(strStatus)
+	okResp(w, weatherHandle.Stats())
+}
+
+// adminOnly lets fn answer only the requests with the admin token, or from the loopback when no token is set
+func adminOnly(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			errResp(w, http.StatusForbidden, ECODE_FORBIDDEN, http.StatusText(http.StatusForbidden))
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func isAdmin(r *http.Request) bool {
+	if "" != *adminKey {
+		auth := r.Header.Get("Authorization")
+		return strings.HasPrefix(auth, "Bearer ") &&
+			1 == subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(*adminKey))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return nil != ip && ip.IsLoopback()
+}
+
+// RefreshRegion starts a background crawl of the region tree on POST, GET shows the report of the last one
+func RefreshRegion(w http.ResponseWriter, r *http.Request) {
+	weatherHandle := GetWeatherHandle()
+	switch r.Method {
+	case http.MethodGet:
+		okResp(w, weatherHandle.RegionRefreshReport())
+	case http.MethodPost:
+		if err := weatherHandle.RefreshRegionTreeAsync(); err != nil {
+			weatherErrResp(w, r, err)
+			return
+		}
+		writeResp(w, http.StatusAccepted, weather.ECODE_OK, http.StatusText(http.StatusAccepted), nil)
+	default:
+		errResp(w, http.StatusMethodNotAllowed, ECODE_METHOD_NOT_ALLOWED, http.StatusText(http.StatusMethodNotAllowed))
+	}
 }
 
 func ShowCityList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, http.ErrBodyNotAllowed.Error(), http.StatusMethodNotAllowed)
+		errResp(w, http.StatusMethodNotAllowed, ECODE_METHOD_NOT_ALLOWED, http.ErrBodyNotAllowed.Error())
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
 	r.ParseForm()
 
+	if format := r.Form.Get(FIELD_FORMAT); "" != format {
+		ExportCityList(w, r, format)
+		return
+	}
 	weatherHandle := GetWeatherHandle()
-	if prov, ok := r.Form[FIELD_NAME]; ok {
-		resp, _ := weatherHandle.ShowCityList(prov[0])
-		w.Write(resp)
+	var resp map[string]interface{}
+	var err error
+	if cityCode, hasCode := r.Form[FIELD_NAME_CODE]; hasCode {
+		resp, err = weatherHandle.ShowCityListByCode(cityCode[0])
 	} else {
-		resp, _ := weatherHandle.ShowCityList("")
-		w.Write(resp)
+		var provinceName string
+		if prov, ok := r.Form[FIELD_NAME]; ok {
+			provinceName = prov[0]
+		}
+		resp, err = weatherHandle.ShowCityList(provinceName)
 	}
+	if err != nil {
+		weatherErrResp(w, r, err)
+		return
+	}
+	okResp(w, resp)
+}
 
+func SearchRegions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errResp(w, http.StatusMethodNotAllowed, ECODE_METHOD_NOT_ALLOWED, http.ErrBodyNotAllowed.Error())
+		return
+	}
+	r.ParseForm()
+
+	var limit int
+	if strLimit := r.Form.Get(FIELD_LIMIT); "" != strLimit {
+		var err error
+		if limit, err = strconv.Atoi(strLimit); nil != err {
+			errResp(w, http.StatusBadRequest, weather.ECODE_BAD_PARAMETER, "parameter error: invalid limit")
+			return
+		}
+	}
+	resp, err := GetWeatherHandle().SearchRegions(r.Form.Get(FIELD_QUERY), limit)
+	if nil != err {
+		weatherErrResp(w, r, err)
+		return
+	}
+	okResp(w, resp)
+}
+
+// ExportCityList writes the stations as a flat csv, json or geojson file, optionally below the region of the city parameter
+func ExportCityList(w http.ResponseWriter, r *http.Request, format string) {
+	contentType, ok := EXPORT_CONTENT_TYPES[format]
+	if !ok {
+		errResp(w, http.StatusBadRequest, weather.ECODE_BAD_PARAMETER, "parameter error: format should be csv, json or geojson")
+		return
+	}
+	var path []string
+	if prov, ok := r.Form[FIELD_NAME]; ok {
+		path = strings.Split(prov[0], STR_SEP)
+	}
+	records, err := GetWeatherHandle().RegionRecords(path...)
+	if nil != err {
+		weatherErrResp(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"citylist.%s\"", format))
+	if err := weather.WriteRegionRecords(w, format, records); nil != err {
+		log.Println("export city list failed", err)
+	}
 }
 
 func ShowWeather(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		errResp(w, http.StatusMethodNotAllowed, http.ErrBodyNotAllowed.Error())
+		errResp(w, http.StatusMethodNotAllowed, ECODE_METHOD_NOT_ALLOWED, http.ErrBodyNotAllowed.Error())
 		return
 	}
 	r.ParseForm()
 
+	if cityCode, hasCode := r.Form[FIELD_NAME_CODE]; hasCode {
+		Resp, err := GetWeatherHandle().ShowCityWeatherByCodeContext(r.Context(), cityCode[0])
+		weatherResp(w, r, cityCode[0], Resp, err)
+		return
+	}
+	if _, hasLat := r.Form[FIELD_LAT]; hasLat {
+		ShowNearestWeather(w, r)
+		return
+	}
 	prov, has := r.Form[FIELD_NAME]
-	//cityCode, hasCode := r.Form[FIELD_NAME_CODE]
 	if !has {
-		errResp(w, http.StatusBadRequest, "parameter error")
+		errResp(w, http.StatusBadRequest, weather.ECODE_BAD_PARAMETER, "parameter error")
 		return
 	}
 
 	strCity := prov[0]
 	params := strings.Split(strCity, STR_SEP)
-	var spellParams []string = make([]string, 0)
-
-	for i := 0; i < len(params); i++ {
-		var spellStrCity = ""
-		for _, v := range pinyin.LazyConvert(params[i], nil) {
-			spellStrCity += v
-		}
-		spellParams = append(spellParams, spellStrCity)
-
-	}
-	paramsLen := len(params)
-
-	for k, v := range spellParams {
-		if "" == v {
-			spellParams[k] = params[k]
-		}
-	}
 
 	weatherHandle := GetWeatherHandle()
 	var err error
@@ -172,127 +351,297 @@ func ShowWeather(w http.ResponseWriter, r *http.Request) {
 
 	if nil == weatherHandle {
 		log.Printf("weatherHandle is nil, please check")
-		errResp(w, http.StatusInternalServerError, "Internal Server Error")
+		errResp(w, http.StatusInternalServerError, weather.ECODE_INTERNAL, "Internal Server Error")
 		return
 	}
-	switch paramsLen {
-	case 3:
-		Resp, err = weatherHandle.ShowCityWeather(spellParams[0], spellParams[1], spellParams[2])
-	case 2:
-		Resp, err = weatherHandle.ShowCityWeather(spellParams[0], spellParams[1], spellParams[1])
-	case 1:
-		Resp, err = weatherHandle.ShowCityWeather(spellParams[0], spellParams[0], spellParams[0])
+	Resp, err = weatherHandle.ShowCityWeatherContext(r.Context(), params...)
+
+	weatherResp(w, r, strCity, Resp, err)
+}
+
+// ShowBatchWeather answers the weather of many cities in one request:
+// GET or a form POST with repeated city= (region path or code) and cityCode=,
+// or a POST of {"cities": [...]} in json. Every city has its own ecode in data.
+func ShowBatchWeather(w http.ResponseWriter, r *http.Request) {
+	var queries []string
+	switch r.Method {
+	case http.MethodGet, http.MethodPost:
 	default:
-		errResp(w, http.StatusBadRequest, "parameter error")
+		errResp(w, http.StatusMethodNotAllowed, ECODE_METHOD_NOT_ALLOWED, http.StatusText(http.StatusMethodNotAllowed))
 		return
 	}
+	r.Body = http.MaxBytesReader(w, r.Body, BATCH_BODY_LIMIT)
+	if http.MethodPost == r.Method && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body map[string][]string
+		if err := json.NewDecoder(r.Body).Decode(&body); nil != err {
+			errResp(w, http.StatusBadRequest, weather.ECODE_BAD_PARAMETER, "parameter error: "+err.Error())
+			return
+		}
+		queries = body[FIELD_CITIES]
+	} else {
+		if err := r.ParseForm(); nil != err {
+			errResp(w, http.StatusBadRequest, weather.ECODE_BAD_PARAMETER, "parameter error: "+err.Error())
+			return
+		}
+		queries = append(append([]string{}, r.Form[FIELD_NAME]...), r.Form[FIELD_NAME_CODE]...)
+	}
 
-	w.Header().Add("Content-Type", "application/json")
+	items, err := GetWeatherHandle().ShowCitiesWeatherContext(r.Context(), queries)
 	if nil != err {
-		errResp(w, http.StatusBadRequest, err.Error())
+		weatherErrResp(w, r, err)
 		return
 	}
-	if nil == Resp {
-		errResp(w, http.StatusInternalServerError, "Internal Server Error")
+	okResp(w, versioned(r, items))
+}
+
+// StreamWeather pushes the weather of city= or cityCode= as Server-Sent Events:
+// the whole weather at first and on every refresh, then the current weather and the alarms as they change.
+// The stream keeps the city fresh by looking it up every weather.CURRENT_INFO_GAP while it is open.
+func StreamWeather(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errResp(w, http.StatusMethodNotAllowed, ECODE_METHOD_NOT_ALLOWED, http.ErrBodyNotAllowed.Error())
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errResp(w, http.StatusInternalServerError, weather.ECODE_INTERNAL, "streaming is not supported")
 		return
 	}
-	jsonStr, err := json.Marshal(Resp)
+	r.ParseForm()
+	weatherHandle := GetWeatherHandle()
+	var region weather.RegionInfo
+	var err error
+	if cityCode := r.Form.Get(FIELD_NAME_CODE); "" != cityCode {
+		region, err = weatherHandle.RegionByCode(cityCode)
+	} else if city := r.Form.Get(FIELD_NAME); "" != city {
+		region, err = weatherHandle.ResolveRegion(strings.Split(city, STR_SEP)...)
+	} else {
+		err = weather.ErrBadParameter
+	}
 	if nil != err {
-		errResp(w, http.StatusInternalServerError, "Internal Server Error")
+		weatherErrResp(w, r, err)
+		return
+	}
+	Resp, err := weatherHandle.ShowCityWeatherByCodeContext(r.Context(), region.Code_)
+	if nil != err && !errors.Is(err, weather.ErrStaleData) {
+		weatherErrResp(w, r, err)
+		return
+	}
+	events, stop := weatherHandle.Watch(region.Code_)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	var id int
+	send := func(event weather.WeatherEvent) bool {
+		data, err := json.Marshal(event)
+		if nil != err {
+			log.Println("Error marshaling event:", err)
+			return true
+		}
+		id++
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event.Type, data)
+		flusher.Flush()
+		return nil == err
+	}
+	if !send(weather.WeatherEvent{Type: weather.EVENT_WEATHER, Code: region.Code_, Weather: Resp}) {
+		return
+	}
+
+	keepalive := time.NewTicker(STREAM_KEEPALIVE)
+	defer keepalive.Stop()
+	refresh := time.NewTicker(weather.CURRENT_INFO_GAP)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if !send(event) {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); nil != err {
+				return
+			}
+			flusher.Flush()
+		case <-refresh.C:
+			/*刷新由缓存更新时推送，这里只触发查询*/
+			go weatherHandle.ShowCityWeatherByCodeContext(r.Context(), region.Code_)
+		}
+	}
+}
+
+// ShowNearestWeather answers the weather of the station closest to lat/lon
+func ShowNearestWeather(w http.ResponseWriter, r *http.Request) {
+	lat, err1 := strconv.ParseFloat(r.Form.Get(FIELD_LAT), 64)
+	lon, err2 := strconv.ParseFloat(r.Form.Get(FIELD_LON), 64)
+	if nil != err1 || nil != err2 {
+		errResp(w, http.StatusBadRequest, weather.ECODE_BAD_PARAMETER, "parameter error: invalid lat or lon")
+		return
+	}
+	region, dist, err := GetWeatherHandle().FindNearest(lat, lon)
+	if nil != err {
+		weatherErrResp(w, r, err)
+		return
+	}
+	log.Printf("nearest station of (%f,%f) is %s, %.1fkm", lat, lon, region.FullName_, dist)
+	Resp, err := GetWeatherHandle().ShowCityWeatherByCodeContext(r.Context(), region.Code_)
+	weatherResp(w, r, region.FullName_, Resp, err)
+}
+
+// weatherResp answers the result of a weather lookup, stale data is still returned with a Warning header
+func weatherResp(w http.ResponseWriter, r *http.Request, strCity string, Resp *weather.WeatherInfo, err error) {
+	if errors.Is(err, weather.ErrStaleData) && nil != Resp {
+		log.Printf("return the stale weather data of [%s]: %v", strCity, err)
+		w.Header().Add("Warning", `110 - "Response is Stale"`)
+		writeResp(w, http.StatusOK, weather.ErrorCode(err), err.Error(), versioned(r, Resp))
+		return
+	} else if nil != err {
+		weatherErrResp(w, r, err)
+		return
+	}
+	if nil == Resp {
+		errResp(w, http.StatusInternalServerError, weather.ECODE_INTERNAL, "Internal Server Error")
 		return
 	}
-	w.Write(jsonStr)
+	okResp(w, versioned(r, Resp))
 }
 
 func ShowFortyWeather(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		errResp(w, http.StatusMethodNotAllowed, http.ErrBodyNotAllowed.Error())
+		errResp(w, http.StatusMethodNotAllowed, ECODE_METHOD_NOT_ALLOWED, http.ErrBodyNotAllowed.Error())
 		return
 	}
 	r.ParseForm()
 
-	prov, has := r.Form[FIELD_NAME]
-	if !has {
-		errResp(w, http.StatusBadRequest, "parameter error: missing city parameter")
+	var strCity string
+	if cityCode, hasCode := r.Form[FIELD_NAME_CODE]; hasCode {
+		strCity = cityCode[0]
+	} else if prov, has := r.Form[FIELD_NAME]; has {
+		strCity = prov[0]
+	} else {
+		errResp(w, http.StatusBadRequest, weather.ECODE_BAD_PARAMETER, "parameter error: missing city parameter")
 		return
 	}
-
-	strCity := prov[0]
 	if strCity == "" {
-		errResp(w, http.StatusBadRequest, "parameter error: empty city parameter")
+		errResp(w, http.StatusBadRequest, weather.ECODE_BAD_PARAMETER, "parameter error: empty city parameter")
 		return
 	}
 
 	params := strings.Split(strCity, STR_SEP)
-	var spellParams []string = make([]string, 0)
-
-	for i := 0; i < len(params); i++ {
-		var spellStrCity = ""
-		for _, v := range pinyin.LazyConvert(params[i], nil) {
-			spellStrCity += v
-		}
-		spellParams = append(spellParams, spellStrCity)
-	}
-	paramsLen := len(params)
-
-	for k, v := range spellParams {
-		if "" == v {
-			spellParams[k] = params[k]
-		}
-	}
 
 	weatherHandle := GetWeatherHandle()
 	if nil == weatherHandle {
 		log.Printf("weatherHandle is nil, please check")
-		errResp(w, http.StatusInternalServerError, "Internal Server Error: weather service unavailable")
+		errResp(w, http.StatusInternalServerError, weather.ECODE_INTERNAL, "Internal Server Error: weather service unavailable")
 		return
 	}
 
 	var err error
 	var Resp []weather.FortyDaysInfo
 
-	switch paramsLen {
-	case 3:
-		Resp, err = weatherHandle.GetFortyDaysInfoWeatherCom(spellParams[0], spellParams[1], spellParams[2])
-	case 2:
-		Resp, err = weatherHandle.GetFortyDaysInfoWeatherCom(spellParams[0], spellParams[1], spellParams[1])
-	case 1:
-		Resp, err = weatherHandle.GetFortyDaysInfoWeatherCom(spellParams[0], spellParams[0], spellParams[0])
-	default:
-		errResp(w, http.StatusBadRequest, fmt.Sprintf("parameter error: invalid number of parameters (%d)", paramsLen))
-		return
+	if _, hasCode := r.Form[FIELD_NAME_CODE]; hasCode {
+		Resp, err = weatherHandle.GetFortyDaysInfoByCodeContext(r.Context(), strCity)
+	} else {
+		Resp, err = weatherHandle.GetFortyDaysInfoWeatherComContext(r.Context(), params...)
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	
 	if err != nil {
 		log.Printf("Error fetching weather data: %v", err)
-		errResp(w, http.StatusBadRequest, fmt.Sprintf("failed to fetch weather data: %v", err))
+		weatherErrResp(w, r, err)
 		return
 	}
 	
 	if Resp == nil || len(Resp) == 0 {
 		log.Printf("No weather data available for city: %s", strCity)
-		errResp(w, http.StatusNotFound, "no weather data available for the specified city")
+		errResp(w, http.StatusNotFound, weather.ECODE_CITY_NOT_FOUND, "no weather data available for the specified city")
 		return
 	}
 
-	jsonStr, err := json.Marshal(Resp)
+	okResp(w, versioned(r, Resp))
+}
+
+// writeResp answers every request with the {rcode, ecode, rmsg, data} envelope,
+// rcode is the same as the http status
+func writeResp(w http.ResponseWriter, rCode int, eCode, rMsg string, data interface{}) {
+	var Jmap = make(map[string]interface{})
+	Jmap[weather.RESP_RCODE_FIELD] = rCode
+	Jmap[weather.RESP_ECODE_FIELD] = eCode
+	Jmap[weather.RESP_RMSG_FIELD] = rMsg
+	Jmap[weather.RESP_DATA_FIELD] = data
+	strResp, err := json.Marshal(Jmap)
 	if err != nil {
 		log.Printf("Error marshaling response: %v", err)
-		errResp(w, http.StatusInternalServerError, "Internal Server Error: failed to process weather data")
+		rCode = http.StatusInternalServerError
+		strResp, _ = json.Marshal(map[string]interface{}{
+			weather.RESP_RCODE_FIELD: rCode,
+			weather.RESP_ECODE_FIELD: weather.ECODE_INTERNAL,
+			weather.RESP_RMSG_FIELD:  "Internal Server Error: failed to process response data",
+			weather.RESP_DATA_FIELD:  nil,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(rCode)
+	w.Write(strResp)
+}
+
+func okResp(w http.ResponseWriter, data interface{}) {
+	writeResp(w, http.StatusOK, weather.ECODE_OK, http.StatusText(http.StatusOK), data)
+}
+
+func errResp(w http.ResponseWriter, rCode int, eCode, rMsg string) {
+	writeResp(w, rCode, eCode, rMsg, nil)
+}
+
+// weatherErrResp answers an error returned by the weather package, the candidates of an ambiguous name go into data
+func weatherErrResp(w http.ResponseWriter, r *http.Request, err error) {
+	var ambiguous *weather.AmbiguousRegionError
+	if errors.As(err, &ambiguous) {
+		writeResp(w, statusOfError(err), weather.ErrorCode(err), err.Error(), versioned(r, ambiguous.Candidates))
 		return
 	}
+	errResp(w, statusOfError(err), weather.ErrorCode(err), err.Error())
+}
 
-	w.Write(jsonStr)
+// versioned converts the data of a weather response to the schema of the api version in the path of r,
+// the unversioned paths and /v1 answer the data as it is
+func versioned(r *http.Request, data interface{}) interface{} {
+	if !strings.HasPrefix(r.URL.Path, API_V2_PREFIX+"/") {
+		return data
+	}
+	switch v := data.(type) {
+	case *weather.WeatherInfo:
+		return v.V2()
+	case []weather.FortyDaysInfo:
+		return weather.FortyDaysV2(v)
+	case []weather.BatchItem:
+		return weather.BatchV2(v)
+	case []weather.RegionInfo:
+		return weather.RegionsV2(v)
+	}
+	return data
 }
 
-func errResp(w http.ResponseWriter, rCode int, rMsg string) {
-	var Jmap = make(map[string]interface{})
-	Jmap[weather.RESP_RCODE_FIELD] = rCode
-	Jmap[weather.RESP_RMSG_FIELD] = rMsg
-	strResp, _ := json.Marshal(Jmap)
-	w.Write(strResp)
+// statusOfError maps the errors of the weather package to the http status
+func statusOfError(err error) int {
+	switch {
+	case nil == err, errors.Is(err, weather.ErrStaleData):
+		return http.StatusOK
+	case errors.Is(err, weather.ErrBadParameter):
+		return http.StatusBadRequest
+	case errors.Is(err, weather.ErrCityNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, weather.ErrAmbiguousRegion), errors.Is(err, weather.ErrRefreshRunning):
+		return http.StatusConflict
+	case errors.Is(err, weather.ErrUpstreamParse):
+		return http.StatusBadGateway
+	case errors.Is(err, weather.ErrUpstreamUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
 }
 
 func safe_http_handle(fn http.HandlerFunc) http.HandlerFunc {
@@ -300,7 +649,8 @@ func safe_http_handle(fn http.HandlerFunc) http.HandlerFunc {
 		defer func() {
 			err, ok := recover().(error)
 			if ok {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				log.Println(r.URL, err)
+				errResp(w, http.StatusInternalServerError, weather.ECODE_INTERNAL, err.Error())
 			}
 		}()
 		fn(w, r)
@@ -315,7 +665,17 @@ func help() {
 	fmt.Println("     -port\tSet the listener port, using port [3244] by default")
 	fmt.Println("     -crt\tSpecify the server credential file")
 	fmt.Println("     -key\tSpecify the server key file")
+	fmt.Println("     -region-refresh\tSet the interval in hours to crawl the region tree again, using [168] by default, 0 to disable")
+	fmt.Println("     -crawl-workers\tSet the number of province pages crawled at the same time, using [4] by default")
+	fmt.Println("     -crawl-interval\tSet the minimum interval in milliseconds between two crawl requests, using [200] by default")
+	fmt.Println("     -cache-file\tSet the file the weather caches are saved to across restarts, using [.weather_cache.gob] by default, empty to disable")
+	fmt.Println("     -cache-snapshot\tSet the interval in minutes to save the weather caches, using [10] by default, 0 to save only on shutdown")
+	fmt.Println("     -admin-token\tSet the bearer token of the /admin endpoints, using $WEATHER_ADMIN_TOKEN by default, without it only the loopback may call them")
+	fmt.Println("     -ws-origins\tSet the comma separated origins of other sites allowed to open /weather/ws, * for any")
 	fmt.Println("     -help\tdisplay help info and exit")
+	fmt.Printf("Commands:\n")
+	fmt.Printf("     %s export [-format csv|json|geojson] [-city xx,xx] [-o file]\n", filepath.Base(os.Args[0]))
+	fmt.Printf("     %s import -i file.csv [-o %s]\n", filepath.Base(os.Args[0]), weather.REGION_CACHE_FILE)
 }
 
 func runAsServices() {
@@ -331,6 +691,11 @@ func runAsServices() {
 
 func handleSignals(signal os.Signal) {
 	log.Println("Recv a signal:", signal)
+	if nil != handle && "" != *cacheFile {
+		if err := handle.SaveCache(*cacheFile); err != nil {
+			log.Println(err)
+		}
+	}
 	exit <- true
 	os.Exit(0)
 }
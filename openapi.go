@@ -0,0 +1,21 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPIDocument describes every endpoint and response of the server, the tests validate the handlers against it
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// ShowOpenAPI answers the OpenAPI 3 document of the server
+func ShowOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errResp(w, http.StatusMethodNotAllowed, ECODE_METHOD_NOT_ALLOWED, http.ErrBodyNotAllowed.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(openAPIDocument)
+}
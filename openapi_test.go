@@ -0,0 +1,553 @@
+package main
+
+import (
+	"WeatherInfos/weather"
+	"WeatherInfos/weather/weathertest"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fixtureProvider asks for the months recorded in the fixtures instead of the current ones
+type fixtureProvider struct {
+	*weather.WeatherCom
+}
+
+func (p fixtureProvider) FortyDays(ctx context.Context, year, month int, code string, r *[]weather.FortyDaysInfo) error {
+	now := time.Now()
+	if year == now.Year() && month == int(now.Month()) {
+		return p.WeatherCom.FortyDays(ctx, 2024, 7, code, r)
+	}
+	return p.WeatherCom.FortyDays(ctx, 2024, 8, code, r)
+}
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "weatherinfos")
+	if err != nil {
+		log.Fatal(err)
+	}
+	once.Do(func() {})
+	if handle, err = newTestHandle(dir); err != nil {
+		log.Fatal(err)
+	}
+	code := m.Run()
+	handle.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestHandle answers the fixtures for the regions of testdata/citylist.csv, its region data file is written in dir
+func newTestHandle(dir string) (*weather.Weather, error) {
+	file, err := os.Open(filepath.Join("testdata", "citylist.csv"))
+	if err != nil {
+		return nil, err
+	}
+	records, err := weather.ReadRegionCSV(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	h := weather.New(0, fixtureProvider{weather.NewWeatherComWithTransport(weathertest.Transport{})})
+	if err := h.ImportRegionRecords(records, filepath.Join(dir, weather.REGION_CACHE_FILE)); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// openAPI is the decoded openapi.json with a minimal validator of the schema objects it uses
+type openAPI map[string]interface{}
+
+func loadOpenAPI(t *testing.T) openAPI {
+	var doc openAPI
+	if err := json.Unmarshal(openAPIDocument, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// resolve follows a local $ref such as #/components/schemas/WeatherInfo
+func (doc openAPI) resolve(node map[string]interface{}) map[string]interface{} {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		var cur interface{} = map[string]interface{}(doc)
+		for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			cur = cur.(map[string]interface{})[key]
+		}
+		node = cur.(map[string]interface{})
+	}
+}
+
+// responseSchema returns the schema of the response of path, method and status in the given content type,
+// a method that is not described answers 405 with the common error response.
+func (doc openAPI) responseSchema(path, method string, status int, contentType string) (map[string]interface{}, error) {
+	item, ok := doc["paths"].(map[string]interface{})[path].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("path %s is not described", path)
+	}
+	var response map[string]interface{}
+	if op, ok := item[strings.ToLower(method)].(map[string]interface{}); ok {
+		if response, ok = op["responses"].(map[string]interface{})[fmt.Sprint(status)].(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("status %d of %s %s is not described", status, method, path)
+		}
+	} else if http.StatusMethodNotAllowed == status {
+		response = map[string]interface{}{"$ref": "#/components/responses/Error"}
+	} else {
+		return nil, fmt.Errorf("%s %s is not described", method, path)
+	}
+	content, ok := doc.resolve(response)["content"].(map[string]interface{})[contentType].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s of %s %s is not described", contentType, method, path)
+	}
+	return content["schema"].(map[string]interface{}), nil
+}
+
+// validate returns the places where value does not match schema
+func (doc openAPI) validate(schema map[string]interface{}, value interface{}, at string) []string {
+	schema = doc.resolve(schema)
+	var errs []string
+	if all, ok := schema["allOf"].([]interface{}); ok && nil != value {
+		for _, sub := range all {
+			errs = append(errs, doc.validate(sub.(map[string]interface{}), value, at)...)
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enum {
+			if v == value {
+				found = true
+			}
+		}
+		if !found {
+			return append(errs, fmt.Sprintf("%s: %v is not one of %v", at, value, enum))
+		}
+	}
+	if nil == value {
+		if nullable, _ := schema["nullable"].(bool); !nullable {
+			errs = append(errs, at+": null is not allowed")
+		}
+		return errs
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: %T is not an object", at, value))
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := obj[name.(string)]; !ok {
+					errs = append(errs, fmt.Sprintf("%s: %s is required", at, name))
+				}
+			}
+		}
+		for name, v := range obj {
+			if prop, ok := props[name]; ok {
+				errs = append(errs, doc.validate(prop.(map[string]interface{}), v, at+"."+name)...)
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				errs = append(errs, doc.validate(additional, v, at+"."+name)...)
+			} else if allowed, ok := schema["additionalProperties"].(bool); ok && !allowed {
+				errs = append(errs, fmt.Sprintf("%s: %s is not described", at, name))
+			}
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: %T is not an array", at, value))
+		}
+		if min, ok := schema["minItems"].(float64); ok && float64(len(list)) < min {
+			errs = append(errs, fmt.Sprintf("%s: %d items, want at least %v", at, len(list), min))
+		}
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(list)) > max {
+			errs = append(errs, fmt.Sprintf("%s: %d items, want at most %v", at, len(list), max))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, v := range list {
+				errs = append(errs, doc.validate(items, v, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return append(errs, fmt.Sprintf("%s: %T is not a string", at, value))
+		}
+		if "date-time" == schema["format"] {
+			if _, err := time.Parse(time.RFC3339Nano, s); nil != err {
+				errs = append(errs, fmt.Sprintf("%s: %s is not a date-time", at, s))
+			}
+		}
+		if "date" == schema["format"] {
+			if _, err := time.Parse("2006-01-02", s); nil != err {
+				errs = append(errs, fmt.Sprintf("%s: %s is not a date", at, s))
+			}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			errs = append(errs, fmt.Sprintf("%s: %v is not an integer", at, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			errs = append(errs, fmt.Sprintf("%s: %v is not a number", at, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%s: %v is not a boolean", at, value))
+		}
+	}
+	return errs
+}
+
+func TestOpenAPIPathsRouted(t *testing.T) {
+	doc := loadOpenAPI(t)
+	router := newRouter()
+	for path := range doc["paths"].(map[string]interface{}) {
+		_, pattern := router.Handler(httptest.NewRequest(http.MethodGet, path, nil))
+		if pattern != path {
+			t.Errorf("%s is routed to %s", path, pattern)
+		}
+	}
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	doc := loadOpenAPI(t)
+	router := newRouter()
+	tooMany := `{"cities":["101020100"` + strings.Repeat(`,"101020100"`, weather.BATCH_MAX_CITIES) + `]}`
+	for _, tc := range []struct {
+		method      string
+		target      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{http.MethodGet, "/weather?cityCode=101020100", "", "", http.StatusOK},
+		{http.MethodGet, "/weather?city=上海", "", "", http.StatusOK},
+		{http.MethodGet, "/weather?city=上海,上海,闵行", "", "", http.StatusBadGateway}, /*页面在天气列表中截断*/
+		{http.MethodGet, "/weather?city=nowhere", "", "", http.StatusNotFound},
+		{http.MethodGet, "/weather?lat=north&lon=east", "", "", http.StatusBadRequest},
+		{http.MethodPost, "/weather", "", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/weather/forty?cityCode=101020100", "", "", http.StatusOK},
+		{http.MethodGet, "/weather/forty", "", "", http.StatusBadRequest},
+		{http.MethodGet, "/weather/batch?city=上海&cityCode=101020300&city=nowhere", "", "", http.StatusOK},
+		{http.MethodPost, "/weather/batch", "application/json", `{"cities":["上海","101020100","1"]}`, http.StatusOK},
+		{http.MethodPost, "/weather/batch", "application/x-www-form-urlencoded", "city=上海&cityCode=101020300", http.StatusOK},
+		{http.MethodPost, "/weather/batch", "application/json", tooMany, http.StatusBadRequest},
+		{http.MethodGet, "/citylist", "", "", http.StatusOK},
+		{http.MethodGet, "/citylist?city=上海", "", "", http.StatusOK},
+		{http.MethodGet, "/citylist?city=上海,上海", "", "", http.StatusOK},
+		{http.MethodGet, "/citylist?cityCode=101020100", "", "", http.StatusOK},
+		{http.MethodGet, "/citylist?cityCode=1", "", "", http.StatusBadRequest},
+		{http.MethodGet, "/search?q=shanghai", "", "", http.StatusOK},
+		{http.MethodGet, "/search", "", "", http.StatusBadRequest},
+		{http.MethodGet, "/weather/status", "", "", http.StatusOK},
+		{http.MethodGet, "/admin/region/refresh", "", "", http.StatusForbidden}, /*httptest的请求不是来自本机*/
+		{http.MethodGet, "/openapi.json", "", "", http.StatusOK},
+		{http.MethodGet, "/v1/weather?cityCode=101020100", "", "", http.StatusOK},
+		{http.MethodGet, "/v1/weather/forty?cityCode=101020100", "", "", http.StatusOK},
+		{http.MethodGet, "/v1/citylist?city=上海", "", "", http.StatusOK},
+		{http.MethodGet, "/v2/weather?cityCode=101020100", "", "", http.StatusOK},
+		{http.MethodGet, "/v2/weather?city=上海", "", "", http.StatusOK},
+		{http.MethodGet, "/v2/weather?city=nowhere", "", "", http.StatusNotFound},
+		{http.MethodPost, "/v2/weather", "", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v2/weather/forty?cityCode=101020100", "", "", http.StatusOK},
+		{http.MethodGet, "/v2/weather/batch?city=上海&cityCode=101020300&city=nowhere", "", "", http.StatusOK},
+		{http.MethodPost, "/v2/weather/batch", "application/json", `{"cities":["上海","101020100","1"]}`, http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		if "" != tc.contentType {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s %s: got status %d, want %d: %s", tc.method, tc.target, rec.Code, tc.status, rec.Body)
+			continue
+		}
+		schema, err := doc.responseSchema(req.URL.Path, tc.method, rec.Code, "application/json")
+		if err != nil {
+			t.Errorf("%s %s: %v", tc.method, tc.target, err)
+			continue
+		}
+		var body interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s %s: %v", tc.method, tc.target, err)
+			continue
+		}
+		for _, e := range doc.validate(schema, body, "response") {
+			t.Errorf("%s %s: %s", tc.method, tc.target, e)
+		}
+	}
+}
+
+func TestAdminAccess(t *testing.T) {
+	doc := loadOpenAPI(t)
+	router := newRouter()
+	defer func(key string) { *adminKey = key }(*adminKey)
+	for _, tc := range []struct {
+		key        string /*配置的令牌*/
+		method     string
+		remoteAddr string
+		auth       string
+		status     int
+	}{
+		{"", http.MethodGet, "127.0.0.1:40000", "", http.StatusOK},
+		{"", http.MethodGet, "[::1]:40000", "", http.StatusOK},
+		{"", http.MethodDelete, "127.0.0.1:40000", "", http.StatusMethodNotAllowed},
+		{"", http.MethodGet, "192.0.2.1:40000", "", http.StatusForbidden},
+		{"", http.MethodPost, "192.0.2.1:40000", "Bearer anything", http.StatusForbidden},
+		{"secret", http.MethodGet, "192.0.2.1:40000", "Bearer secret", http.StatusOK},
+		{"secret", http.MethodGet, "127.0.0.1:40000", "", http.StatusForbidden},
+		{"secret", http.MethodGet, "192.0.2.1:40000", "Bearer wrong", http.StatusForbidden},
+		{"secret", http.MethodGet, "192.0.2.1:40000", "secret", http.StatusForbidden},
+	} {
+		*adminKey = tc.key
+		for _, prefix := range []string{"", API_V1_PREFIX} {
+			req := httptest.NewRequest(tc.method, prefix+"/admin/region/refresh", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if "" != tc.auth {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tc.status {
+				t.Errorf("%s %s from %s with %q and the token %q: got status %d, want %d", tc.method, req.URL.Path, tc.remoteAddr, tc.auth, tc.key, rec.Code, tc.status)
+				continue
+			}
+			schema, err := doc.responseSchema(req.URL.Path, tc.method, rec.Code, "application/json")
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			var body interface{}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			for _, e := range doc.validate(schema, body, "response") {
+				t.Errorf("%s %s: %s", tc.method, req.URL.Path, e)
+			}
+		}
+	}
+}
+
+// gateTransport holds every request until release is closed, then answers 404
+type gateTransport struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (g *gateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	g.once.Do(func() { close(g.started) })
+	<-g.release
+	return &http.Response{
+		StatusCode: http.StatusNotFound,
+		Status:     "404 Not Found",
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}, nil
+}
+
+func TestRefreshRegionConcurrent(t *testing.T) {
+	doc := loadOpenAPI(t)
+	router := newRouter()
+	gate := &gateTransport{started: make(chan struct{}), release: make(chan struct{})}
+	crawler := weather.NewCrawler()
+	crawler.Upstream = weather.NewUpstream(gate)
+	crawler.Retries, crawler.Interval = 0, 0
+	handle.SetCrawler(crawler)
+	defer func() {
+		idle := weather.NewCrawler()
+		idle.Upstream = weather.NewUpstream(weathertest.Transport{})
+		handle.SetCrawler(idle)
+	}()
+
+	/*两个同时的POST只有一个开始抓取*/
+	const n = 2
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/admin/region/refresh", nil)
+			req.RemoteAddr = "127.0.0.1:40000"
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			codes <- rec.Code
+			schema, err := doc.responseSchema(req.URL.Path, req.Method, rec.Code, "application/json")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var body interface{}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			for _, e := range doc.validate(schema, body, "response") {
+				t.Errorf("POST %s: %s", req.URL.Path, e)
+			}
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	got := make(map[int]int)
+	for code := range codes {
+		got[code]++
+	}
+	if 1 != got[http.StatusAccepted] || 1 != got[http.StatusConflict] {
+		t.Errorf("statuses %v, want one 202 and one 409", got)
+	}
+
+	<-gate.started
+	if report := handle.RegionRefreshReport(); !report.Running {
+		t.Errorf("report %+v while crawling", report)
+	}
+	close(gate.release)
+	for deadline := time.Now().Add(5 * time.Second); handle.RegionRefreshReport().Running; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the refresh did not finish")
+		}
+	}
+	/*抓取失败，原来的地区树不变*/
+	if report := handle.RegionRefreshReport(); "" == report.Error {
+		t.Errorf("report %+v, want the crawl error", report)
+	}
+	if _, err := handle.RegionByCode("101020100"); err != nil {
+		t.Errorf("RegionByCode() after the failed refresh = %v", err)
+	}
+}
+
+// TestV1Unchanged makes sure /v1 answers the same bytes as the unversioned paths used by the existing clients
+func TestV1Unchanged(t *testing.T) {
+	router := newRouter()
+	/*服务器时间和农历时辰随请求的时间变化*/
+	now := regexp.MustCompile(`"(servertime|lunar)":"[^"]*"`)
+	for _, target := range []string{
+		"/weather?cityCode=101020100",
+		"/weather?city=nowhere",
+		"/weather/forty?cityCode=101020100",
+		"/weather/batch?city=上海&cityCode=101020300&city=nowhere",
+		"/citylist?city=上海",
+		"/search?q=shanghai",
+	} {
+		var bodies [2][]byte
+		for i, prefix := range []string{"", API_V1_PREFIX} {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, prefix+target, nil))
+			bodies[i] = now.ReplaceAll(rec.Body.Bytes(), []byte(`"$1":""`))
+		}
+		if !bytes.Equal(bodies[0], bodies[1]) {
+			t.Errorf("%s\n got %s\nwant %s", API_V1_PREFIX+target, bodies[1], bodies[0])
+		}
+	}
+}
+
+func TestOpenAPIStream(t *testing.T) {
+	doc := loadOpenAPI(t)
+	server := httptest.NewServer(newRouter())
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/weather/stream?cityCode=101020100", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("got Content-Type %s", resp.Header.Get("Content-Type"))
+	}
+	schema, err := doc.responseSchema("/weather/stream", http.MethodGet, resp.StatusCode, "text/event-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		data := strings.TrimPrefix(scanner.Text(), "data: ")
+		if data == scanner.Text() {
+			continue
+		}
+		var event interface{}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range doc.validate(schema, event, "event") {
+			t.Error(e)
+		}
+		return
+	}
+	t.Fatal("no event", scanner.Err())
+}
+
+// TestStreamRelease makes sure the stream stops watching the city once the client is gone
+func TestStreamRelease(t *testing.T) {
+	server := httptest.NewServer(newRouter())
+	defer server.Close()
+	watchers := func() int { return handle.Watchers()["101020100"] }
+	waitWatchers := func(want int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); want != watchers(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d watchers, want %d", watchers(), want)
+			}
+		}
+	}
+	base := watchers()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/weather/stream?cityCode=101020100", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() && "event: weather" != scanner.Text() {
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	waitWatchers(base + 1)
+
+	/*客户端断开后释放订阅*/
+	cancel()
+	waitWatchers(base)
+}
+
+// TestOpenAPIRejectsRename makes sure the schemas are strict enough to catch a renamed field
+func TestOpenAPIRejectsRename(t *testing.T) {
+	doc := loadOpenAPI(t)
+	schema, err := doc.responseSchema("/weather/forty", http.MethodGet, http.StatusOK, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := json.Marshal(weather.FortyDaysInfo{})
+	var renamed map[string]interface{}
+	json.Unmarshal(info, &renamed)
+	renamed["highTemp"] = renamed["htemp"]
+	delete(renamed, "htemp")
+	body := map[string]interface{}{"rcode": 200, "ecode": "ok", "rmsg": "OK", "data": []interface{}{renamed}}
+	var decoded interface{}
+	encoded, _ := json.Marshal(body)
+	json.Unmarshal(encoded, &decoded)
+	if errs := doc.validate(schema, decoded, "response"); 2 != len(errs) {
+		t.Errorf("got %q, want htemp required and highTemp not described", errs)
+	}
+}
@@ -0,0 +1,117 @@
+package singleflight
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError is the error of a call whose fn panicked, the panic does not take down the process
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: panic: %v\n%s", e.Value, e.Stack)
+}
+
+/*一次正在进行的调用*/
+type call struct {
+	done     chan struct{}
+	val      interface{}
+	err      error
+	waiters  int                /*仍在等待结果的调用者*/
+	detached bool               /*后台调用，等待者都离开也不取消*/
+	cancel   context.CancelFunc /*取消fn使用的ctx*/
+}
+
+/*
+Group 合并相同key的并发调用，同一时刻每个key只有一次调用在进行，所有等待者共享它的结果。
+零值可以直接使用。
+*/
+type Group struct {
+	mu sync.Mutex
+	m  map[string]*call
+}
+
+/*
+执行并返回fn的结果，key已有调用在进行时等待它的结果，shared表示结果来自别的调用者发起的调用。
+fn在单独的goroutine中执行，它的ctx只在所有等待者都因自己的ctx结束而离开时取消；
+调用者的ctx结束时立即返回ctx.Err()。
+*/
+func (g *Group) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	c, shared := g.m[key]
+	if shared {
+		c.waiters++
+		g.mu.Unlock()
+	} else {
+		c = g.start(key, false, fn)
+		g.mu.Unlock()
+	}
+
+	select {
+	case <-c.done:
+		return c.val, c.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if 0 == c.waiters && !c.detached {
+			/*之后的调用者发起新的调用，不加入已取消的这次*/
+			c.cancel()
+			if g.m[key] == c {
+				delete(g.m, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err(), shared
+	}
+}
+
+/*在后台执行fn，key已有调用在进行时什么都不做，返回是否发起了新的调用*/
+func (g *Group) Go(key string, fn func(ctx context.Context) (interface{}, error)) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.m[key]; ok {
+		return false
+	}
+	g.start(key, true, fn)
+	return true
+}
+
+/*发起一次调用，g.mu必须已锁定*/
+func (g *Group) start(key string, detached bool, fn func(ctx context.Context) (interface{}, error)) *call {
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &call{done: make(chan struct{}), detached: detached, cancel: cancel}
+	if !detached {
+		c.waiters = 1
+	}
+	g.m[key] = c
+
+	go func() {
+		c.run(ctx, fn)
+		cancel()
+		g.mu.Lock()
+		if g.m[key] == c { /*取消时已删除，key可能已属于新的调用*/
+			delete(g.m, key)
+		}
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	return c
+}
+
+/*执行fn，fn的panic作为PanicError保存在c.err中*/
+func (c *call) run(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) {
+	defer func() {
+		if v := recover(); nil != v {
+			c.val, c.err = nil, &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	c.val, c.err = fn(ctx)
+}
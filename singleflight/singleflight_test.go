@@ -0,0 +1,242 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoShared(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "v", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	var shared int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, s := g.Do(context.Background(), "k", fn)
+			if "v" != v || nil != err {
+				t.Errorf("Do() = %v, %v", v, err)
+			}
+			if s {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+	/*等所有调用者都加入同一次调用*/
+	for {
+		g.mu.Lock()
+		c := g.m["k"]
+		joined := nil != c && n == c.waiters
+		g.mu.Unlock()
+		if joined {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if 1 != calls || n-1 != shared {
+		t.Errorf("fn called %d times, %d shared results; want 1 and %d", calls, shared, n-1)
+	}
+}
+
+func TestDoCancelOne(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	started := make(chan struct{})
+	fnCtx := make(chan context.Context, 1)
+	fn := func(ctx context.Context) (interface{}, error) {
+		fnCtx <- ctx
+		close(started)
+		<-release
+		return "v", ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err, _ := g.Do(ctx, "k", fn)
+		first <- err
+	}()
+	<-started
+	second := make(chan error, 1)
+	go func() {
+		v, err, _ := g.Do(context.Background(), "k", fn)
+		if "v" != v {
+			err = errors.New("unexpected value")
+		}
+		second <- err
+	}()
+	for {
+		g.mu.Lock()
+		waiters := g.m["k"].waiters
+		g.mu.Unlock()
+		if 2 == waiters {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled caller got %v, want context.Canceled", err)
+	}
+	if err := (<-fnCtx).Err(); nil != err {
+		t.Errorf("fn ctx is %v while another caller waits", err)
+	}
+	close(release)
+	if err := <-second; nil != err {
+		t.Errorf("remaining caller got %v", err)
+	}
+}
+
+func TestDoCancelAll(t *testing.T) {
+	var g Group
+	done := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go g.Do(ctx, "k", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		done <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	for {
+		g.mu.Lock()
+		_, ok := g.m["k"]
+		g.mu.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("fn ctx ended with %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fn is not cancelled after every caller left")
+	}
+}
+
+func TestGoDetached(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	fn := func(ctx context.Context) (interface{}, error) {
+		<-release
+		done <- ctx.Err()
+		return "v", nil
+	}
+	if !g.Go("k", fn) {
+		t.Fatal("Go() did not start a call")
+	}
+	if g.Go("k", fn) {
+		t.Error("Go() started a second call of the same key")
+	}
+
+	/*加入后台调用的调用者离开，调用不应被取消*/
+	ctx, cancel := context.WithCancel(context.Background())
+	left := make(chan struct{})
+	go func() {
+		g.Do(ctx, "k", fn)
+		close(left)
+	}()
+	cancel()
+	<-left
+	close(release)
+	if err := <-done; nil != err {
+		t.Errorf("detached fn ctx ended with %v", err)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	var g Group
+	v, err, _ := g.Do(context.Background(), "k", func(ctx context.Context) (interface{}, error) {
+		var m map[string]int
+		m["x"] = 1
+		return 1, nil
+	})
+	var perr *PanicError
+	if nil != v || !errors.As(err, &perr) || 0 == len(perr.Stack) {
+		t.Fatalf("Do() = %v, %v; want a *PanicError", v, err)
+	}
+	/*panic之后同一key仍可调用*/
+	if v, err, _ := g.Do(context.Background(), "k", func(ctx context.Context) (interface{}, error) { return 2, nil }); 2 != v || nil != err {
+		t.Errorf("Do() after a panic = %v, %v", v, err)
+	}
+}
+
+func TestDoAfterCancel(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	cancelled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	left := make(chan struct{})
+	go func() {
+		g.Do(ctx, "k", func(ctx context.Context) (interface{}, error) {
+			<-ctx.Done()
+			close(cancelled)
+			<-release /*被取消的fn还没有返回*/
+			return nil, ctx.Err()
+		})
+		close(left)
+	}()
+	for {
+		g.mu.Lock()
+		_, ok := g.m["k"]
+		g.mu.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-left
+	<-cancelled
+
+	/*取消后才到的调用者发起新的调用，而不是得到context.Canceled*/
+	v, err, shared := g.Do(context.Background(), "k", func(ctx context.Context) (interface{}, error) { return "v", ctx.Err() })
+	if "v" != v || nil != err || shared {
+		t.Errorf("Do() after the cancellation = %v, %v, shared %v", v, err, shared)
+	}
+
+	/*被取消的调用结束时不删除新的调用*/
+	started := make(chan struct{})
+	newRelease := make(chan struct{})
+	result := make(chan interface{}, 1)
+	go func() {
+		v, _, _ := g.Do(context.Background(), "k", func(ctx context.Context) (interface{}, error) {
+			close(started)
+			<-newRelease
+			return "new", nil
+		})
+		result <- v
+	}()
+	<-started
+	close(release)
+	time.Sleep(10 * time.Millisecond)
+	g.mu.Lock()
+	_, ok := g.m["k"]
+	g.mu.Unlock()
+	if !ok {
+		t.Error("the cancelled call removed the running one")
+	}
+	close(newRelease)
+	if v := <-result; "new" != v {
+		t.Errorf("Do() = %v", v)
+	}
+}
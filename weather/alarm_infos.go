@@ -1,12 +1,11 @@
 package weather
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"golang.org/x/net/html"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -21,8 +20,9 @@ const (
 
 // 备用 https://d1.weather.com.cn/dingzhi/101020100.html?_=1721292263961
 var (
-	mu         sync.RWMutex
-	alarmInfos map[string][]Location
+	mu             sync.RWMutex
+	alarmInfos     map[string][]Location
+	alarmListHooks []func(map[string][]Location)
 )
 
 func init() {
@@ -34,7 +34,7 @@ func GetLocationInfoByID(cityCode string) (details []Location, ok bool) {
 	mu.Lock()
 	defer mu.Unlock()
 	c, ok := alarmInfos[cityCode] //city : cityCode for example is 101220101
-	if !ok {
+	if !ok && len(cityCode) > 4 {
 		d, ok := alarmInfos[cityCode[:len(cityCode)-2]] //district 1012201
 		if !ok {
 			p, ok := alarmInfos[cityCode[:len(cityCode)-4]] //province 1012201
@@ -48,39 +48,78 @@ func GetLocationInfoByID(cityCode string) (details []Location, ok bool) {
 	return c, ok
 }
 
+// OnAlarmListUpdate registers fn to be called with every new alarm list,
+// fn is called at once when a list has already been fetched
+func OnAlarmListUpdate(fn func(map[string][]Location)) {
+	mu.Lock()
+	alarmListHooks = append(alarmListHooks, fn)
+	infos := alarmInfos
+	mu.Unlock()
+	if len(infos) > 0 {
+		fn(infos)
+	}
+}
+
 // CheckAlarmListFromWeatherCom 定时轮询告警列表
 func CheckAlarmListFromWeatherCom() {
+	CheckAlarmListFromWeatherComContext(context.Background())
+}
+
+// CheckAlarmListFromWeatherComContext 定时轮询告警列表，ctx结束时返回
+func CheckAlarmListFromWeatherComContext(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(UPDATE_WEATHERINFO_GAP_MINUTES) * time.Minute)
+	defer ticker.Stop()
 	for {
-		req, err := http.NewRequest("GET", ALARM_LIST_API+fmt.Sprintf("%d", time.Now().Nanosecond()), nil)
-		if err != nil {
-			log.Println(err)
-			return
+		if infos, err := defaultWeatherCom.getAlarmList(ctx); err == nil {
+			setAlarmList(infos)
 		}
-		handle := &http.Client{Timeout: 10 * time.Second}
-		resp, err := handle.Do(req)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			log.Println(req.URL, err)
+
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
 		}
-		defer resp.Body.Close()
-		buf, _ := ioutil.ReadAll(resp.Body)
-		var alarmInfoResp AlarmInfoResp
-
-		err = json.Unmarshal(buf[14:len(buf)-1], &alarmInfoResp)
-		{
-			mu.Lock()
-			alarmInfos = make(map[string][]Location) //每次清空map，以免数据重复
-			for _, v := range alarmInfoResp.Data {
-				var id string = strings.Split(v[1], "-")[0]
-				alarmInfos[id] = append(alarmInfos[id], Location{Name: v[0], FileName: v[1], Longitude: v[2], Latitude: v[3], Code: v[4], Code2: v[5]})
-			}
-			mu.Unlock()
-		}
+	}
+}
 
-		<-time.Tick(time.Duration(UPDATE_WEATHERINFO_GAP_MINUTES) * time.Minute)
+// setAlarmList replaces the alarm list and calls the hooks with it
+func setAlarmList(infos map[string][]Location) {
+	mu.Lock()
+	alarmInfos = infos //每次替换map，以免数据重复
+	hooks := alarmListHooks
+	mu.Unlock()
+	for _, fn := range hooks {
+		fn(infos)
 	}
 }
 
+// getAlarmList gets the alarm list and groups the locations by the city code
+func (p *WeatherCom) getAlarmList(ctx context.Context) (map[string][]Location, error) {
+	rawURL := ALARM_LIST_API + fmt.Sprintf("%d", time.Now().Nanosecond())
+	buf, err := p.upstream().Get(ctx, ENDPOINT_ALARM_LIST, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) <= 15 {
+		return nil, &ParseError{Page: rawURL, Section: "alarm list", Reason: "response too short"}
+	}
+	var alarmInfoResp AlarmInfoResp
+	if err = json.Unmarshal(buf[14:len(buf)-1], &alarmInfoResp); err != nil {
+		log.Println(rawURL, err)
+		return nil, &ParseError{Page: rawURL, Section: "alarm list", Reason: err.Error()}
+	}
+
+	infos := make(map[string][]Location)
+	for _, v := range alarmInfoResp.Data {
+		if len(v) < 6 {
+			continue
+		}
+		var id string = strings.Split(v[1], "-")[0]
+		infos[id] = append(infos[id], Location{Name: v[0], FileName: v[1], Longitude: v[2], Latitude: v[3], Code: v[4], Code2: v[5]})
+	}
+	return infos, nil
+}
+
 // 提取表格中的文本信息
 func extractTableText(n *html.Node) (string, error) {
 	if n.Type == html.ElementNode && n.Data == "tr" {
@@ -97,25 +136,28 @@ func extractTableText(n *html.Node) (string, error) {
 	return "", fmt.Errorf("no table text found")
 }
 
-func GetAlarmDetails(url string, r *WeatherInfo) {
-	req, err := http.NewRequest("GET", url, nil)
+func GetAlarmDetails(url string, r *WeatherInfo) error {
+	return GetAlarmDetailsContext(context.Background(), url, r)
+}
+
+// GetAlarmDetailsContext is GetAlarmDetails, the requests give up once ctx is done
+func GetAlarmDetailsContext(ctx context.Context, url string, r *WeatherInfo) error {
+	return defaultWeatherCom.getAlarmDetails(ctx, url, r)
+}
+
+func (p *WeatherCom) getAlarmDetails(ctx context.Context, url string, r *WeatherInfo) error {
+	buf, err := p.upstream().Get(ctx, ENDPOINT_ALARM_DETAILS, url)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	handle := &http.Client{Timeout: 10 * time.Second}
-	resp, err := handle.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		log.Println(req.URL, err)
-		return
+	if len(buf) <= 14 {
+		return &ParseError{Page: url, Section: "alarm details", Reason: "response too short"}
 	}
-	defer resp.Body.Close()
-
-	buf, _ := ioutil.ReadAll(resp.Body)
 	var st1 STEMP1
 	err = json.Unmarshal(buf[14:], &st1)
 	if nil != err {
-		fmt.Println(err.Error())
+		log.Println(url, err)
+		return &ParseError{Page: url, Section: "alarm details", Reason: err.Error()}
 	}
 
 	fileName, _ := getFileNameFromURL(url)
@@ -128,30 +170,26 @@ func GetAlarmDetails(url string, r *WeatherInfo) {
 	ainfo.LevelCode = st1.LevelCode
 	ainfo.SignalType = st1.SignalType
 	ainfo.SignalLevel = st1.SignalLevel
-	ainfo.Title = strings.Split(st1.Head, "发布")[1]
+	if title := strings.SplitN(st1.Head, "发布", 2); len(title) == 2 {
+		ainfo.Title = title[1]
+	} else {
+		ainfo.Title = st1.Head
+	}
 	//ainfo.Color = st1.YJYCEN
 	//ainfo.PicUri = fmt.Sprintf("http://www.weather.com.cn/m2/i/about/alarmpic/%s%s.gif", ainfo.TypeCode, ainfo.LevelCode)
-	getAlarmFormINfo(fmt.Sprintf(ALARM_FORM_INFO, fileName, time.Now().Nanosecond()), &ainfo)
+	p.getAlarmFormINfo(ctx, fmt.Sprintf(ALARM_FORM_INFO, fileName, time.Now().Nanosecond()), &ainfo)
 	r.AlarmInfo_ = append(r.AlarmInfo_, ainfo)
+	return nil
 }
 
-func getAlarmFormINfo(rawURL string, details *AlarmDetails) {
-	req, err := http.NewRequest("GET", rawURL, nil)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	handle := &http.Client{Timeout: 10 * time.Second}
-	resp, err := handle.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		log.Println(req.URL, err)
+func (p *WeatherCom) getAlarmFormINfo(ctx context.Context, rawURL string, details *AlarmDetails) {
+	buf, err := p.upstream().Get(ctx, ENDPOINT_ALARM_FORM, rawURL)
+	if err != nil || len(buf) <= 15 {
 		return
 	}
-	defer resp.Body.Close()
-	buf, _ := ioutil.ReadAll(resp.Body)
 
 	data := strings.Split(string(buf[14:len(buf)-1]), ",")
-	if len(data) > 30 {
+	if len(data) > 30 || len(data) < 4 {
 		return
 	}
 	details.Title = strings.Replace(data[1], "\"", "", -1)
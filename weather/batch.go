@@ -0,0 +1,102 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	BATCH_MAX_CITIES = 100 /*一次批量查询最多的城市数*/
+	BATCH_WORKERS    = 8   /*同时向上游抓取的城市数*/
+)
+
+// ShowCitiesWeather returns the weather of many cities at once, see ShowCitiesWeatherContext
+func (c *Weather) ShowCitiesWeather(queries []string) ([]BatchItem, error) {
+	return c.ShowCitiesWeatherContext(context.Background(), queries)
+}
+
+// ShowCitiesWeatherContext returns the weather of every query in the same order,
+// a query is a station code or a region path joined by STR_SEP, such as "北京,朝阳".
+// Cached cities are answered at once, the others are fetched by at most BATCH_WORKERS at the same time.
+// The error of one city goes into its item, only a bad batch fails as a whole.
+func (c *Weather) ShowCitiesWeatherContext(ctx context.Context, queries []string) ([]BatchItem, error) {
+	if 0 == len(queries) || len(queries) > BATCH_MAX_CITIES {
+		return nil, fmt.Errorf("%w: a batch takes 1 to %d cities", ErrBadParameter, BATCH_MAX_CITIES)
+	}
+	items := make([]BatchItem, len(queries))
+	missed := make(map[int]RegionInfo)
+	for i, query := range queries {
+		items[i].Query = query
+		cityinfo, err := c.resolveQuery(query)
+		if err != nil {
+			items[i].setResult(nil, err)
+			continue
+		}
+		if resp, ok := c.cachedWeather(cityinfo); ok {
+			items[i].setResult(resp, nil)
+			continue
+		}
+		missed[i] = cityinfo
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	workers := BATCH_WORKERS
+	if len(missed) < workers {
+		workers = len(missed)
+	}
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				items[i].setResult(c.showRegionWeather(ctx, missed[i]))
+			}
+		}()
+	}
+	for i := range missed {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return items, nil
+}
+
+// resolveQuery resolves a station code or a region path of a batch query
+func (c *Weather) resolveQuery(query string) (RegionInfo, error) {
+	query = strings.TrimSpace(query)
+	if cityCodeRe.MatchString(query) {
+		return c.RegionByCode(query)
+	}
+	return c.ResolveRegion(strings.Split(query, STR_SEP)...)
+}
+
+// cachedWeather returns the weather of cityinfo when it can be answered without the upstream
+func (c *Weather) cachedWeather(cityinfo RegionInfo) (*WeatherInfo, bool) {
+	if _, recent := c.currentlru.Peek(cityinfo.Code_); !recent {
+		return nil, false
+	}
+	resp, ok := c.weatherlru.Get(cityinfo.Code_)
+	if !ok {
+		return nil, false
+	}
+	return withServerTime(resp), true
+}
+
+// setResult fills the item with the result of a weather lookup, stale data is kept along with its error
+func (item *BatchItem) setResult(resp *WeatherInfo, err error) {
+	item.ECode = ErrorCode(err)
+	if nil != err {
+		item.RMsg = err.Error()
+	}
+	if nil == err || errors.Is(err, ErrStaleData) {
+		item.Weather = resp
+	}
+	var ambiguous *AmbiguousRegionError
+	if errors.As(err, &ambiguous) {
+		item.Candidates = ambiguous.Candidates
+	}
+}
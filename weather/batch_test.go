@@ -0,0 +1,150 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowProvider answers every city after a while and counts the fetches running at the same time
+type slowProvider struct {
+	mu       sync.Mutex
+	running  int
+	peak     int
+	fetched  map[string]int
+	failCode string /*该城市上游失败*/
+}
+
+func (p *slowProvider) SevenDays(ctx context.Context, cityinfo RegionInfo) (*WeatherInfo, error) {
+	p.mu.Lock()
+	p.running++
+	if p.running > p.peak {
+		p.peak = p.running
+	}
+	p.fetched[cityinfo.Code_]++
+	p.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+	if cityinfo.Code_ == p.failCode {
+		return nil, &UpstreamError{URL: cityinfo.Url_, StatusCode: 503}
+	}
+	return &WeatherInfo{Code_: cityinfo.Code_, Name_: cityinfo.Name_, FullName_: cityinfo.FullName_}, nil
+}
+
+func (p *slowProvider) Current(ctx context.Context, code string, r *WeatherInfo) error { return nil }
+func (p *slowProvider) Hours(ctx context.Context, code string, r *WeatherInfo) error   { return nil }
+func (p *slowProvider) FortyDays(ctx context.Context, year, month int, code string, r *[]FortyDaysInfo) error {
+	return nil
+}
+func (p *slowProvider) Alarm(ctx context.Context, fileName string, r *WeatherInfo) error { return nil }
+
+func TestShowCitiesWeather(t *testing.T) {
+	p := &slowProvider{fetched: make(map[string]int), failCode: "101270105"}
+	c := New(0, p)
+	defer c.Close()
+	/*四川,成都下的30个区县*/
+	counties := make(map[string]*TreeRegionInfo)
+	var queries []string
+	for i := 1; i <= 30; i++ {
+		code := fmt.Sprintf("1012701%02d", i)
+		counties[fmt.Sprintf("xian%d", i)] = &TreeRegionInfo{RegionInfo: RegionInfo{
+			Code_: code, Url_: "/weather/" + code + ".shtml", Name_: fmt.Sprintf("县%d", i), FullName_: fmt.Sprintf("四川,成都,县%d", i),
+		}}
+		queries = append(queries, code)
+	}
+	c.treeRegion = &TreeRegionInfo{Regions: map[string]*TreeRegionInfo{
+		"sichuan": {RegionInfo: RegionInfo{Name_: "四川", Spell_: "sichuan"}, Regions: map[string]*TreeRegionInfo{
+			"chengdu": {RegionInfo: RegionInfo{Name_: "成都", FullName_: "四川,成都", Spell_: "chengdu"}, Regions: counties},
+		}},
+	}}
+	c.indexRegions()
+
+	/*不合法的code、不存在的城市、上游失败的城市夹在中间*/
+	queries = append(queries[:3], append([]string{"1", "101999999", "四川,成都,县7", "拉萨"}, queries[3:]...)...)
+	items, err := c.ShowCitiesWeather(queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != len(items) {
+		t.Fatalf("%d items for %d queries", len(items), len(queries))
+	}
+	for i, item := range items {
+		if queries[i] != item.Query {
+			t.Errorf("item %d is %q, want %q", i, item.Query, queries[i])
+		}
+		var want string
+		switch item.Query {
+		case "1", "101999999", "拉萨":
+			want = ECODE_CITY_NOT_FOUND
+		case p.failCode:
+			want = ECODE_UPSTREAM_UNAVAILABLE
+		default:
+			want = ECODE_OK
+		}
+		if want != item.ECode {
+			t.Errorf("item %d %q: ecode %s (%s), want %s", i, item.Query, item.ECode, item.RMsg, want)
+			continue
+		}
+		if ECODE_OK != want {
+			if nil != item.Weather || "" == item.RMsg {
+				t.Errorf("item %d %q failed with %+v", i, item.Query, item)
+			}
+			continue
+		}
+		if nil == item.Weather || (!strings.Contains(item.Query, STR_SEP) && item.Query != item.Weather.Code_) {
+			t.Errorf("item %d %q = %+v", i, item.Query, item.Weather)
+		}
+	}
+	if "101270107" != items[5].Weather.Code_ {
+		t.Errorf("四川,成都,县7 = %+v", items[5].Weather)
+	}
+
+	p.mu.Lock()
+	peak, fetched := p.peak, len(p.fetched)
+	p.mu.Unlock()
+	if peak > BATCH_WORKERS || peak < 2 {
+		t.Errorf("%d fetches at the same time, want 2 to %d", peak, BATCH_WORKERS)
+	}
+	if 30 != fetched {
+		t.Errorf("%d cities fetched, want 30", fetched)
+	}
+
+	/*已缓存的城市不再抓取*/
+	if _, err := c.ShowCitiesWeather([]string{"101270101", "101270102"}); err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	again := p.fetched["101270101"] + p.fetched["101270102"]
+	p.mu.Unlock()
+	if 2 != again {
+		t.Errorf("the cached cities were fetched %d times, want once each", again)
+	}
+}
+
+func TestShowCitiesWeatherLimit(t *testing.T) {
+	c := New(0, &slowProvider{fetched: make(map[string]int)})
+	defer c.Close()
+	c.treeRegion = testRegionTree()
+	c.indexRegions()
+	for _, n := range []int{0, BATCH_MAX_CITIES + 1} {
+		queries := make([]string, n)
+		for i := range queries {
+			queries[i] = "101020100"
+		}
+		if items, err := c.ShowCitiesWeather(queries); ECODE_BAD_PARAMETER != ErrorCode(err) || nil != items {
+			t.Errorf("a batch of %d cities = %d items, %v", n, len(items), err)
+		}
+	}
+	queries := make([]string, BATCH_MAX_CITIES)
+	for i := range queries {
+		queries[i] = "101020100"
+	}
+	if items, err := c.ShowCitiesWeather(queries); err != nil || BATCH_MAX_CITIES != len(items) || ECODE_OK != items[BATCH_MAX_CITIES-1].ECode {
+		t.Errorf("a batch of %d cities = %d items, %v", BATCH_MAX_CITIES, len(items), err)
+	}
+}
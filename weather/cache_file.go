@@ -0,0 +1,190 @@
+package weather
+
+import (
+	"WeatherInfos/lrucache"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+/*
+*  Cache file layout:
+*  CACHE_FILE_MAGIC | gob(CacheFileHeader) | gob([]cacheRecord)
+*  every value is encoded on its own, an entry that cannot be encoded is skipped instead of the whole snapshot
+ */
+const (
+	CACHE_FILE_MAGIC        = "WICACHE"
+	CACHE_FILE_VERSION      = 1
+	CACHE_SNAPSHOT_FILE     = ".weather_cache.gob"
+	CACHE_SNAPSHOT_INTERVAL = 10 * time.Minute /*定期保存缓存快照的间隔*/
+
+	CACHE_NAME_WEATHER = "weather"
+	CACHE_NAME_FORTY   = "forty"
+	CACHE_NAME_CURRENT = "current"
+)
+
+// CacheFileHeader describes the cache snapshot stored in a cache file
+type CacheFileHeader struct {
+	Version int
+	Saved   time.Time
+	Records int
+}
+
+/*快照中的一个缓存条目，Expire保留了原来的抓取时间*/
+type cacheRecord struct {
+	Cache  string
+	Key    string
+	Expire time.Time
+	Value  []byte /*值的gob，值的类型没有字段时为空*/
+}
+
+// SaveCache writes the weather, forty days and current caches to path atomically,
+// the entries keep their expiry so the staleness checks work the same after LoadCache.
+func (c *Weather) SaveCache(path string) error {
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+
+	var records []cacheRecord
+	records = append(records, snapshotCache(CACHE_NAME_WEATHER, c.weatherlru)...)
+	records = append(records, snapshotCache(CACHE_NAME_FORTY, c.fortydayslru)...)
+	records = append(records, snapshotCache(CACHE_NAME_CURRENT, c.currentlru)...)
+	header := CacheFileHeader{Version: CACHE_FILE_VERSION, Saved: time.Now(), Records: len(records)}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		log.Println("Cannot create", path, err)
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriter(tmp)
+	writer.WriteString(CACHE_FILE_MAGIC)
+	encoder := gob.NewEncoder(writer)
+	if err = encoder.Encode(header); nil == err {
+		err = encoder.Encode(records)
+	}
+	if nil == err {
+		err = writer.Flush()
+	}
+	if nil == err {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); nil == err {
+		err = closeErr
+	}
+	if err != nil {
+		log.Println("Cannot save to", path, err)
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadCache restores the caches saved by SaveCache and returns the number of entries restored.
+// Entries that expired beyond their grace time while the service was down are dropped.
+func (c *Weather) LoadCache(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	magic := make([]byte, len(CACHE_FILE_MAGIC))
+	if _, err := io.ReadFull(reader, magic); err != nil || CACHE_FILE_MAGIC != string(magic) {
+		return 0, fmt.Errorf("%s is not a cache file", path)
+	}
+	var header CacheFileHeader
+	var records []cacheRecord
+	decoder := gob.NewDecoder(reader)
+	if err := decoder.Decode(&header); err != nil {
+		return 0, err
+	}
+	if CACHE_FILE_VERSION != header.Version {
+		/*缓存可以重新抓取，不做迁移*/
+		return 0, fmt.Errorf("cache file %s of version %d is not supported", path, header.Version)
+	}
+	if err := decoder.Decode(&records); err != nil {
+		return 0, err
+	}
+
+	byCache := make(map[string][]cacheRecord)
+	for _, record := range records {
+		byCache[record.Cache] = append(byCache[record.Cache], record)
+	}
+	n := restoreCache(byCache[CACHE_NAME_WEATHER], c.weatherlru)
+	n += restoreCache(byCache[CACHE_NAME_FORTY], c.fortydayslru)
+	n += restoreCache(byCache[CACHE_NAME_CURRENT], c.currentlru)
+	log.Printf("restore %d of %d cache entries saved at %s", n, len(records), header.Saved.Format(time.RFC3339))
+	return n, nil
+}
+
+// StartCacheSnapshot saves the caches to path every interval in the background
+func (c *Weather) StartCacheSnapshot(path string, interval time.Duration) {
+	c.StartCacheSnapshotContext(context.Background(), path, interval)
+}
+
+// StartCacheSnapshotContext is StartCacheSnapshot, the snapshots stop once ctx is done
+func (c *Weather) StartCacheSnapshotContext(ctx context.Context, path string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := c.SaveCache(path); err != nil {
+				log.Println("scheduled cache snapshot failed", err)
+			}
+		}
+	}()
+}
+
+// snapshotCache encodes the live entries of lru from the oldest to the newest
+func snapshotCache[V any](name string, lru *lrucache.Cache[string, V]) []cacheRecord {
+	items := lru.Snapshot()
+	records := make([]cacheRecord, 0, len(items))
+	for _, item := range items {
+		record := cacheRecord{Cache: name, Key: item.Key, Expire: item.Expire}
+		if hasFields[V]() {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(item.Value); err != nil {
+				log.Println("Cannot encode the cache entry", name, item.Key, err)
+				continue
+			}
+			record.Value = buf.Bytes()
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// restoreCache decodes records into lru, an entry that cannot be decoded is skipped
+func restoreCache[V any](records []cacheRecord, lru *lrucache.Cache[string, V]) int {
+	items := make([]lrucache.Item[string, V], 0, len(records))
+	for _, record := range records {
+		item := lrucache.Item[string, V]{Key: record.Key, Expire: record.Expire}
+		if hasFields[V]() {
+			if err := gob.NewDecoder(bytes.NewReader(record.Value)).Decode(&item.Value); err != nil {
+				log.Println("Cannot decode the cache entry", record.Cache, record.Key, err)
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	return lru.Restore(items)
+}
+
+// hasFields reports whether gob has anything to encode for V, gob rejects a struct{}
+func hasFields[V any]() bool {
+	t := reflect.TypeOf((*V)(nil)).Elem()
+	return !(reflect.Struct == t.Kind() && 0 == t.NumField())
+}
@@ -0,0 +1,127 @@
+package weather
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*抓取上海的天气，并添加一个四十天预报，返回保存的快照路径*/
+func savedCache(t *testing.T) (*Weather, string) {
+	c := New(0, newFixtureWeatherCom())
+	t.Cleanup(c.Close)
+	if _, err := c.showRegionWeather(context.Background(), shanghai); err != nil {
+		t.Fatal(err)
+	}
+	c.fortydayslru.Add(shanghai.Code_, []FortyDaysInfo{{Date: "20240701", Festival: "建党节"}})
+	path := filepath.Join(t.TempDir(), CACHE_SNAPSHOT_FILE)
+	if err := c.SaveCache(path); err != nil {
+		t.Fatal(err)
+	}
+	return c, path
+}
+
+func TestCacheRoundTrip(t *testing.T) {
+	c, path := savedCache(t)
+	r := New(0, newFixtureWeatherCom())
+	defer r.Close()
+	n, err := r.LoadCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if 3 != n {
+		t.Errorf("LoadCache() restored %d entries, want 3", n)
+	}
+
+	want, _ := c.weatherlru.Peek(shanghai.Code_)
+	got, fresh := r.weatherlru.Peek(shanghai.Code_)
+	if !fresh || want.Name_ != got.Name_ || want.CurrentInfo != got.CurrentInfo || len(want.HoursPredict_) != len(got.HoursPredict_) {
+		t.Errorf("restored weather %+v, want %+v", got, want)
+	}
+	if *want.Weather_[0] != *got.Weather_[0] {
+		t.Errorf("restored the first day %+v, want %+v", *got.Weather_[0], *want.Weather_[0])
+	}
+	if days, ok := r.fortydayslru.Peek(shanghai.Code_); !ok || 1 != len(days) || "建党节" != days[0].Festival {
+		t.Errorf("restored forty days %+v", days)
+	}
+	if _, ok := r.currentlru.Peek(shanghai.Code_); !ok {
+		t.Error("the current weather refresh mark is not restored")
+	}
+	/*恢复后过期时间不变，staleness检查与保存前相同*/
+	for name, pair := range map[string][2]time.Time{
+		CACHE_NAME_WEATHER: {c.weatherlru.Snapshot()[0].Expire, r.weatherlru.Snapshot()[0].Expire},
+		CACHE_NAME_CURRENT: {c.currentlru.Snapshot()[0].Expire, r.currentlru.Snapshot()[0].Expire},
+	} {
+		if !pair[0].Equal(pair[1]) {
+			t.Errorf("%s expires at %v after restore, want %v", name, pair[1], pair[0])
+		}
+	}
+}
+
+func TestCacheDropExpired(t *testing.T) {
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	c.fortydayslru.AddWithTTL("101020100", []FortyDaysInfo{{Date: "20240701"}}, 20*time.Millisecond)
+	c.fortydayslru.AddWithTTL("101010100", []FortyDaysInfo{{Date: "20240701"}}, time.Hour)
+	path := filepath.Join(t.TempDir(), CACHE_SNAPSHOT_FILE)
+	if err := c.SaveCache(path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	r := New(0, newFixtureWeatherCom())
+	defer r.Close()
+	r.fortydayslru.Grace = 0 /*停机期间超过Grace的条目不恢复*/
+	n, err := r.LoadCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if 1 != n || 1 != r.fortydayslru.Len() {
+		t.Errorf("LoadCache() restored %d entries, want only the unexpired one", n)
+	}
+	if _, ok := r.fortydayslru.Peek("101020100"); ok {
+		t.Error("the expired entry is restored")
+	}
+}
+
+func TestCacheCorrupt(t *testing.T) {
+	_, path := savedCache(t)
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"magic", append([]byte("NOTCACHE"), buf[len(CACHE_FILE_MAGIC):]...)},
+		{"header", buf[:len(CACHE_FILE_MAGIC)+4]},
+		{"truncated", buf[:len(buf)/2]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(dir, tc.name)
+			if err := ioutil.WriteFile(file, tc.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			r := New(0, newFixtureWeatherCom())
+			defer r.Close()
+			if n, err := r.LoadCache(file); nil == err || 0 != n {
+				t.Errorf("LoadCache() = %d, %v; want an error", n, err)
+			}
+			if 0 != r.weatherlru.Len()+r.fortydayslru.Len()+r.currentlru.Len() {
+				t.Error("a corrupt snapshot restored entries")
+			}
+		})
+	}
+
+	r := New(0, newFixtureWeatherCom())
+	defer r.Close()
+	if _, err := r.LoadCache(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("LoadCache() of a missing file = %v, want not exist", err)
+	}
+}
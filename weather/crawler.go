@@ -0,0 +1,262 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	CRAWL_DEFAULT_WORKERS  = 4
+	CRAWL_DEFAULT_INTERVAL = 200 * time.Millisecond /*同一主机两次请求的最小间隔*/
+	CRAWL_DEFAULT_RETRIES  = 3
+)
+
+var (
+	regionInfoRe = regexp.MustCompile(REGEXP_GET_REGION_URL_INFO)
+	regionUrlRe  = regexp.MustCompile(REGEXP_GET_REGION_URL)
+	wordRe       = regexp.MustCompile(REGEXP_GET_WORD)
+	cityInfoRe   = regexp.MustCompile(REGEXP_GET_CITY_URL_INFO)
+	cityStartRe  = regexp.MustCompile(REGEXP_GET_CITY_START)
+	cityEndRe    = regexp.MustCompile(REGEXP_GET_CITY_END)
+	cityUrlRe    = regexp.MustCompile(REGEXP_GET_CITY_URL)
+	cityCodeFind = regexp.MustCompile(REGEXP_GET_CITY_CODE)
+)
+
+// IncompleteCrawlError is returned when some pages of the region crawl failed or came back empty,
+// the partial tree must not be saved as the region data file
+type IncompleteCrawlError struct {
+	Failed map[string]error /*页面 -> 错误*/
+}
+
+func (e *IncompleteCrawlError) Error() string {
+	for page, err := range e.Failed {
+		return fmt.Sprintf("%s: %d pages failed, such as %s: %v", ErrIncompleteCrawl, len(e.Failed), page, err)
+	}
+	return ErrIncompleteCrawl.Error()
+}
+
+func (e *IncompleteCrawlError) Is(target error) bool {
+	return target == ErrIncompleteCrawl
+}
+
+// Crawler scrapes the region hierarchy of weather.com.cn with a bounded worker pool
+type Crawler struct {
+	Upstream *Upstream     /*退避与断路由Upstream负责，nil时使用共享的Upstream*/
+	Retries  int           /*每个页面的重试次数*/
+	Workers  int           /*并发抓取的省份数*/
+	Interval time.Duration /*同一主机两次请求的最小间隔*/
+
+	mu   sync.Mutex
+	next map[string]time.Time /*主机 -> 下次可以请求的时间*/
+}
+
+// NewCrawler creates a crawler on the shared upstream client, so the crawls and the weather fetches share the breakers
+func NewCrawler() *Crawler {
+	return &Crawler{
+		Retries:  CRAWL_DEFAULT_RETRIES,
+		Workers:  CRAWL_DEFAULT_WORKERS,
+		Interval: CRAWL_DEFAULT_INTERVAL,
+	}
+}
+
+// Crawl builds a new region tree. When some pages fail the partial tree is returned
+// together with an *IncompleteCrawlError.
+func (cr *Crawler) Crawl() (*TreeRegionInfo, error) {
+	return cr.CrawlContext(context.Background())
+}
+
+// CrawlContext is Crawl, the pages not fetched yet fail once ctx is done
+func (cr *Crawler) CrawlContext(ctx context.Context) (*TreeRegionInfo, error) {
+	body, err := cr.fetch(ctx, REGION_SITE)
+	if err != nil {
+		return nil, err
+	}
+	var provinces []*TreeRegionInfo
+	seen := make(map[string]bool)
+	for _, strUrl := range regionInfoRe.FindAllString(string(body), -1) {
+		url, name := regionUrlRe.FindString(strUrl), wordRe.FindString(strUrl)
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		provinces = append(provinces, &TreeRegionInfo{
+			RegionInfo: RegionInfo{Name_: name, Url_: url, Spell_: Spell(name)},
+			Regions:    make(map[string]*TreeRegionInfo),
+		})
+	}
+	if 0 == len(provinces) {
+		return nil, &ParseError{Page: REGION_SITE, Section: "region", Reason: "no province found"}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	jobs := make(chan *TreeRegionInfo)
+	workers := cr.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for info := range jobs {
+				if err := cr.crawlProvince(ctx, info); err != nil {
+					mu.Lock()
+					failed[WEATHER_SITE+info.Url_] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, info := range provinces {
+		jobs <- info
+	}
+	close(jobs)
+	wg.Wait()
+
+	tree := &TreeRegionInfo{Regions: make(map[string]*TreeRegionInfo)}
+	for _, info := range provinces {
+		if _, had := tree.Regions[info.Spell_]; had {
+			failed[WEATHER_SITE+info.Url_] = fmt.Errorf("duplicate province spell %s", info.Spell_)
+			continue
+		}
+		tree.Regions[info.Spell_] = info
+	}
+	if len(failed) > 0 {
+		return tree, &IncompleteCrawlError{Failed: failed}
+	}
+	return tree, nil
+}
+
+// crawlProvince fills the cities and counties of a province, a province without any county is an error
+func (cr *Crawler) crawlProvince(ctx context.Context, info *TreeRegionInfo) error {
+	buf, err := cr.fetch(ctx, WEATHER_SITE+info.Url_)
+	if err != nil {
+		return err
+	}
+	parseCityOrCountyInfo(info, buf)
+	if 0 == len(info.Regions) {
+		return &ParseError{Page: WEATHER_SITE + info.Url_, Section: "city", Reason: "no city found"}
+	}
+	for _, city := range info.Regions {
+		if 0 == len(city.Regions) {
+			return &ParseError{Page: WEATHER_SITE + info.Url_, Section: "county", Reason: "no county found in " + city.Name_}
+		}
+	}
+	return nil
+}
+
+// fetch gets a page once the rate limit of its host allows it
+func (cr *Crawler) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	if err := cr.wait(ctx, rawURL); err != nil {
+		return nil, &UpstreamError{URL: rawURL, Err: err}
+	}
+	return cr.upstream().getRetries(ctx, ENDPOINT_REGION, rawURL, cr.Retries)
+}
+
+// wait blocks until the host of rawURL may be requested again, or ctx is done
+func (cr *Crawler) wait(ctx context.Context, rawURL string) error {
+	if cr.Interval <= 0 {
+		return ctx.Err()
+	}
+	var host string
+	if u, err := url.Parse(rawURL); nil == err {
+		host = u.Host
+	}
+	cr.mu.Lock()
+	if nil == cr.next {
+		cr.next = make(map[string]time.Time)
+	}
+	now := time.Now()
+	at := cr.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	cr.next[host] = at.Add(cr.Interval)
+	cr.mu.Unlock()
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (cr *Crawler) upstream() *Upstream {
+	if nil == cr.Upstream {
+		return defaultUpstream
+	}
+	return cr.Upstream
+}
+
+// parseCityOrCountyInfo fills the cities and counties of a province page into info
+func parseCityOrCountyInfo(info *TreeRegionInfo, buf []byte) {
+	seen := make(map[string]bool)
+	for _, index := range cityStartRe.FindAllStringIndex(string(buf), -1) {
+		end_index := cityEndRe.FindStringIndex(string(buf[index[1]:]))
+		if nil == end_index {
+			break
+		}
+		sinfo := string(buf[index[0] : index[1]+end_index[1]])
+		counties := cityInfoRe.FindAllString(sinfo, -1)
+		cityName := wordRe.FindString(sinfo)
+		if seen[cityName] {
+			break /*repeat，后面是其他日期的同样内容*/
+		}
+		seen[cityName] = true
+		spellCityName := siblingKey(info.Regions, Spell(cityName), cityName)
+
+		regionInfo := &TreeRegionInfo{
+			RegionInfo: RegionInfo{Name_: cityName, FullName_: fmt.Sprintf("%s,%s", info.Name_, cityName), Spell_: spellCityName},
+			Regions:    make(map[string]*TreeRegionInfo),
+		}
+		for _, cinfo := range counties {
+			countyName := wordRe.FindString(cinfo)
+			if DISCARD_INFO_FIELD == countyName { /*skip the description*/
+				continue
+			}
+			spellCountyName := siblingKey(regionInfo.Regions, Spell(countyName), countyName)
+
+			county := &TreeRegionInfo{
+				RegionInfo: RegionInfo{
+					Url_:      cityUrlRe.FindString(cinfo),
+					Code_:     cityCodeFind.FindString(cinfo),
+					Name_:     countyName,
+					Spell_:    fmt.Sprintf("%s,%s,%s", info.Spell_, regionInfo.Spell_, spellCountyName),
+					FullName_: fmt.Sprintf("%s,%s,%s", info.Name_, cityName, countyName),
+				},
+			}
+			regionInfo.Regions[spellCountyName] = county
+		}
+		info.Regions[spellCityName] = regionInfo
+	}
+	if cinfos, ok := info.Regions[info.Spell_]; ok {
+		if county_info, had := cinfos.Regions[info.Spell_]; had {
+			info.Code_ = county_info.Code_
+		}
+	}
+}
+
+// siblingKey returns the key of a sub region in regions, a number is appended to the spell
+// when a sibling with another name already has it, such as 和平 and 河平 stored as heping and heping2
+func siblingKey(regions map[string]*TreeRegionInfo, spell, name string) string {
+	key := spell
+	for i := 2; ; i++ {
+		if sub, had := regions[key]; !had || sub.Name_ == name {
+			break
+		}
+		key = fmt.Sprintf("%s%d", spell, i)
+	}
+	if key != spell {
+		log.Println("region", name, "shares the spell", spell, "with", regions[spell].Name_, "stored as", key)
+	}
+	return key
+}
@@ -0,0 +1,102 @@
+package weather
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCrawl(t *testing.T) {
+	pages := regionPages(
+		stubProvince{Spell: "shanghai", Name: "上海", Cities: []stubCity{
+			{Name: "上海", Counties: [][2]string{{"101020100", "上海"}, {"101020200", "闵行"}}},
+		}},
+		/*同一城市中拼音相同的区县，同一省份中拼音相同的城市*/
+		stubProvince{Spell: "tianjin", Name: "天津", Cities: []stubCity{
+			{Name: "天津", Counties: [][2]string{{"101030100", "天津"}, {"101030101", "和平"}, {"101030102", "河平"}}},
+			{Name: "通州", Counties: [][2]string{{"101030201", "通州"}}},
+			{Name: "同州", Counties: [][2]string{{"101030301", "同州"}}},
+		}},
+	)
+	tree, err := newStubCrawler(pages).Crawl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := regionCodes(tree)
+	if 7 != len(codes) {
+		t.Errorf("crawled %d stations, want 7", len(codes))
+	}
+	for code, want := range map[string]string{
+		"101020200": "上海,上海,闵行",
+		"101030101": "天津,天津,和平",
+		"101030102": "天津,天津,河平",
+		"101030201": "天津,通州,通州",
+		"101030301": "天津,同州,同州",
+	} {
+		if region := codes[code]; nil == region || want != region.FullName_ {
+			t.Errorf("station %s = %+v, want %s", code, region, want)
+		}
+	}
+	tianjin := tree.Regions["tianjin"]
+	if "101030100" != tianjin.Code_ {
+		t.Errorf("天津 code %q", tianjin.Code_)
+	}
+	if city := tianjin.Regions["tianjin"]; "和平" != city.Regions["heping"].Name_ || "河平" != city.Regions["heping2"].Name_ {
+		t.Errorf("天津 counties %v", city.Regions)
+	}
+	if "tianjin,tianjin,heping2" != tianjin.Regions["tianjin"].Regions["heping2"].Spell_ {
+		t.Errorf("河平 spell %q", tianjin.Regions["tianjin"].Regions["heping2"].Spell_)
+	}
+	if "同州" != tianjin.Regions["tongzhou2"].Name_ {
+		t.Errorf("天津 cities %v", tianjin.Regions)
+	}
+
+	/*名称查询不受拼音相同的影响*/
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	c.treeRegion = tree
+	c.indexRegions()
+	for path, code := range map[[2]string]string{
+		{"天津", "河平"}: "101030102",
+		{"天津", "和平"}: "101030101",
+		{"同州", ""}:   "101030301",
+		{"通州", ""}:   "101030201",
+	} {
+		if region, err := c.ResolveRegion(path[:]...); err != nil || code != region.Code_ {
+			t.Errorf("ResolveRegion(%q) = %s, %v; want %s", path, region.Code_, err, code)
+		}
+	}
+}
+
+func TestCrawlIncomplete(t *testing.T) {
+	pages := regionPages(
+		stubProvince{Spell: "shanghai", Name: "上海", Cities: []stubCity{
+			{Name: "上海", Counties: [][2]string{{"101020100", "上海"}}},
+		}},
+		stubProvince{Spell: "beijing", Name: "北京", Cities: []stubCity{
+			{Name: "北京", Counties: [][2]string{{"101010100", "北京"}}},
+		}},
+		stubProvince{Spell: "tianjin", Name: "天津", Cities: []stubCity{{Name: "天津"}}},
+	)
+	delete(pages, WEATHER_SITE+"/textFC/beijing.shtml")
+
+	tree, err := newStubCrawler(pages).Crawl()
+	var incomplete *IncompleteCrawlError
+	if !errors.As(err, &incomplete) || !errors.Is(err, ErrIncompleteCrawl) || 2 != len(incomplete.Failed) {
+		t.Fatalf("Crawl() = %v, want 2 failed pages", err)
+	}
+	if _, ok := incomplete.Failed[WEATHER_SITE+"/textFC/beijing.shtml"]; !ok {
+		t.Errorf("failed pages %v", incomplete.Failed)
+	}
+	var parse *ParseError
+	if !errors.As(incomplete.Failed[WEATHER_SITE+"/textFC/tianjin.shtml"], &parse) {
+		t.Errorf("天津 without county: %v", incomplete.Failed)
+	}
+	if nil == tree || nil == regionCodes(tree)["101020100"] {
+		t.Errorf("the partial tree is not returned")
+	}
+
+	delete(pages, REGION_SITE)
+	if _, err := newStubCrawler(pages).Crawl(); nil == err || errors.Is(err, ErrIncompleteCrawl) {
+		t.Errorf("Crawl() without the region index = %v", err)
+	}
+}
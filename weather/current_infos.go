@@ -1,15 +1,12 @@
 package weather
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 )
 
 const (
@@ -19,27 +16,31 @@ const (
 	HOUR_INFO_END    = "var hour3week="
 )
 
-func GetCurrentWeatherInfo(code, rawURL string, r *WeatherInfo) {
-	req, err := http.NewRequest("GET", rawURL, nil)
+func GetCurrentWeatherInfo(code, rawURL string, r *WeatherInfo) error {
+	return GetCurrentWeatherInfoContext(context.Background(), code, rawURL, r)
+}
+
+// GetCurrentWeatherInfoContext is GetCurrentWeatherInfo, the requests give up once ctx is done
+func GetCurrentWeatherInfoContext(ctx context.Context, code, rawURL string, r *WeatherInfo) error {
+	if err := defaultWeatherCom.getCurrentInfo(ctx, rawURL, r); err != nil {
+		return err
+	}
+	return defaultWeatherCom.getHourInfos(ctx, fmt.Sprintf(HOUR_INFOS_URL, code), r)
+}
+
+func (p *WeatherCom) getCurrentInfo(ctx context.Context, rawURL string, r *WeatherInfo) error {
+	buf, err := p.upstream().Get(ctx, ENDPOINT_CURRENT, rawURL)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	//cheat
-	req.Header.Add("Host", "d1.weather.com.cn")
-	req.Header.Add("Referer", "http://www.weather.com.cn/")
-	req.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0")
-	handle := &http.Client{Timeout: 10 * time.Second}
-	resp, err := handle.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		log.Println(req.URL, err)
-		return
+	if len(buf) <= 11 {
+		return &ParseError{Page: rawURL, Section: "current", Reason: "response too short"}
 	}
-	defer resp.Body.Close()
-	buf, _ := ioutil.ReadAll(resp.Body)
 	data := buf[11:]
 	var curinfo CurrentWeatherInfo
-	json.Unmarshal(data, &curinfo)
+	if err = json.Unmarshal(data, &curinfo); err != nil {
+		return &ParseError{Page: rawURL, Section: "current", Reason: err.Error()}
+	}
 	r.CurrentInfo.Date = curinfo.Date
 	r.CurrentInfo.Time = curinfo.Time
 	r.CurrentInfo.AirQuality = curinfo.AirQuality
@@ -53,40 +54,45 @@ func GetCurrentWeatherInfo(code, rawURL string, r *WeatherInfo) {
 	r.CurrentInfo.Temperature = curinfo.Temperature
 	r.CurrentInfo.TemperatureF = curinfo.TemperatureF
 	r.CurrentInfo.Weather = curinfo.Weather
-	getHourInfos(fmt.Sprintf(HOUR_INFOS_URL, code), r)
+	return nil
 }
 
-func getHourInfos(rawURL string, r *WeatherInfo) {
-	req, err := http.NewRequest("GET", rawURL, nil)
+func (p *WeatherCom) getHourInfos(ctx context.Context, rawURL string, r *WeatherInfo) error {
+	body, err := p.upstream().Get(ctx, ENDPOINT_HOURS, rawURL)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	//cheat
-	req.Header.Add("Host", "d1.weather.com.cn")
-	req.Header.Add("Referer", "http://www.weather.com.cn/")
-	req.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0")
-	handle := &http.Client{Timeout: 10 * time.Second}
-	resp, err := handle.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		log.Println(req.URL, err)
-		return
-	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
 	hour_start_re := regexp.MustCompile(HOUR_INFO_START)
 	hour_end_re := regexp.MustCompile(HOUR_INFO_END)
 
 	hour_start_index := hour_start_re.FindStringIndex(string(body))
+	if nil == hour_start_index {
+		return &ParseError{Page: rawURL, Section: "hours", Reason: "no hourly data"}
+	}
 	hour_end_index := hour_end_re.FindStringIndex(string(body[hour_start_index[0]:]))
+	if nil == hour_end_index {
+		return &ParseError{Page: rawURL, Section: "hours", Reason: "no end of hourly data"}
+	}
 	tempInfos := body[hour_start_index[1] : hour_start_index[0]+hour_end_index[1]]
-	hourInfos := strings.Split(string(strings.Split(string(tempInfos), "=")[1]), ";")[0]
+	assign := strings.SplitN(string(tempInfos), "=", 2)
+	if len(assign) < 2 {
+		return &ParseError{Page: rawURL, Section: "hours", Reason: "no hourly data assignment"}
+	}
+	hourInfos := strings.Split(assign[1], ";")[0]
 	var hours = make([][]wwwHourInfos, 0)
 	var rhours = make([][]HourInfos, 0)
-	json.Unmarshal([]byte(hourInfos), &hours)
+	if err := json.Unmarshal([]byte(hourInfos), &hours); err != nil {
+		return &ParseError{Page: rawURL, Section: "hours", Reason: err.Error()}
+	}
+	if len(hours) < 3 {
+		return &ParseError{Page: rawURL, Section: "hours", Reason: fmt.Sprintf("%d days of hourly data, want 3", len(hours))}
+	}
 	for i := 0; i < 3; i++ {
 		var days = make([]HourInfos, 0)
 		for _, v := range hours[i] {
+			if len(v.Jf) < 10 {
+				return &ParseError{Page: rawURL, Section: "hours", Reason: fmt.Sprintf("bad hour %q", v.Jf)}
+			}
 			temp, _ := strconv.Atoi(v.Jb)
 			dl, _ := strconv.Atoi(v.Jc)
 			di, _ := strconv.Atoi(v.Jd)
@@ -103,4 +109,5 @@ func getHourInfos(rawURL string, r *WeatherInfo) {
 		rhours = append(rhours, days)
 	}
 	r.HoursPredict_ = rhours
+	return nil
 }
@@ -1,9 +1,5 @@
 package weather
 
-import (
-	"time"
-)
-
 const (
 	LIVE_INDEX_INFO_COUNT = 6
 	WEATHER_DAYS          = 7
@@ -48,11 +44,13 @@ func GetWindLevelByIndex(i int) string {
 }
 
 type RegionInfo struct {
-	Url_      string `json:"-"`
-	Code_     string `json:"-"`
-	Name_     string `json:"name"`
-	FullName_ string `json:"fullname"`
-	Spell_    string `json:"spell"`
+	Url_       string  `json:"-"`
+	Code_      string  `json:"code,omitempty"`
+	Name_      string  `json:"name"`
+	FullName_  string  `json:"fullname"`
+	Spell_     string  `json:"spell"`
+	Latitude_  float64 `json:"lat,omitempty"` /*站点纬度，来自告警列表或站点坐标文件*/
+	Longitude_ float64 `json:"lon,omitempty"` /*站点经度*/
 }
 
 /*
@@ -62,6 +60,8 @@ type RegionInfo struct {
 type TreeRegionInfo struct {
 	RegionInfo
 	Regions map[string]*TreeRegionInfo
+	aliases map[string]string /*其他读音 -> Regions中的key，不保存*/
+	names   map[string]string /*名称 -> Regions中的key，不保存*/
 }
 type Turn struct {
 	Sunrise string `json:"sunrise"`
@@ -89,16 +89,14 @@ type LiveIndexInfo struct {
 }
 
 type WeatherInfo struct {
-	Code_         string `json:"-"`
-	Url_          string `json:"-"`
-	Name_         string `json:"name"`
-	Spell_        string `json:"spell"`
-	UpdateTime_   string `json:"updatetime"`
-	Alarm_        bool   `json:"alarm"`
-	ServerTime_   string `json:"servertime"`
-	Lunar_        string `json:"lunar"`
-	getime_       time.Time
-	curGetTime_   time.Time
+	Code_         string                                `json:"code"`
+	Url_          string                                `json:"-"`
+	Name_         string                                `json:"name"`
+	Spell_        string                                `json:"spell"`
+	UpdateTime_   string                                `json:"updatetime"`
+	Alarm_        bool                                  `json:"alarm"`
+	ServerTime_   string                                `json:"servertime"`
+	Lunar_        string                                `json:"lunar"`
 	FullName_     string                                `json:"fullname"`
 	CurrentInfo   BriefCurrentWeatherInfo               `json:"nowinfo"`
 	HoursPredict_ [][]HourInfos                         `json:"hours"`
@@ -108,12 +106,45 @@ type WeatherInfo struct {
 }
 
 type CacheStats struct {
-	Bytes       int64 `json:"bytes"`
-	Items       int64 `json:"items"`
-	Gets        int64 `json:"gets"`
-	Hits        int64 `json:"hits"`
-	Evictions   int64 `json:"evictions"`
-	RefreshRate int64 `json:"refreshrate"`
+	Bytes       int64                 `json:"bytes"`
+	Items       int64                 `json:"items"`
+	Gets        int64                 `json:"gets"`
+	Hits        int64                 `json:"hits"`
+	Evictions   int64                 `json:"evictions"`
+	Expired     int64                 `json:"expired"`
+	Coalesced   int64                 `json:"coalesced"` /*与其他请求合并、共用一次上游抓取的请求数*/
+	RefreshRate int64                 `json:"refreshrate"`
+	Breakers    map[string]string     `json:"breakers,omitempty"` /*各上游接口的断路器状态*/
+	Caches      map[string]CacheUsage `json:"caches"`             /*weather、forty、current各缓存的占用*/
+}
+
+// CacheUsage is the usage of one cache, Bytes is the estimated memory of its entries
+type CacheUsage struct {
+	Bytes     int64 `json:"bytes"`
+	MaxBytes  int64 `json:"maxbytes"` /*0表示不限制*/
+	Items     int64 `json:"items"`
+	Gets      int64 `json:"gets"`
+	Hits      int64 `json:"hits"`
+	Evictions int64 `json:"evictions"`
+	Expired   int64 `json:"expired"`
+}
+
+// BatchItem is the result of one city of a batch query, ECode is ECODE_OK or the error of this city
+type BatchItem struct {
+	Query      string       `json:"query"`
+	ECode      string       `json:"ecode"`
+	RMsg       string       `json:"rmsg,omitempty"`
+	Weather    *WeatherInfo `json:"weather"`              /*数据过期(stale_data)时仍返回*/
+	Candidates []RegionInfo `json:"candidates,omitempty"` /*名称不唯一时的候选地区*/
+}
+
+// WeatherEvent is pushed to the watchers of a city, Type is one of the EVENT_* constants
+type WeatherEvent struct {
+	Type    string                   `json:"type"`
+	Code    string                   `json:"code"`
+	Weather *WeatherInfo             `json:"weather,omitempty"` /*EVENT_WEATHER*/
+	Current *BriefCurrentWeatherInfo `json:"nowinfo,omitempty"` /*EVENT_CURRENT*/
+	Alarms  []AlarmDetails           `json:"alarms,omitempty"`  /*EVENT_ALARM_ADDED、EVENT_ALARM_REMOVED*/
 }
 
 //----------------------------
@@ -301,5 +332,4 @@ type FortyDaysInfo struct {
 	HMin         string `json:"hmin"`         //历史最低温
 	HRate        string `json:"hrate"`        //历史降雨概率
 	HRain        string `json:"hrain"`        //历史降雨量
-	updateTime_  time.Time
 }
@@ -0,0 +1,108 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by the lookups and the upstream fetches, test them with errors.Is
+var (
+	ErrBadParameter        = errors.New("bad parameter")
+	ErrCityNotFound        = errors.New("not found this city")
+	ErrAmbiguousRegion     = errors.New("ambiguous region")
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	ErrUpstreamParse       = errors.New("upstream data parse failed")
+	ErrStaleData           = errors.New("stale data")
+	ErrRefreshRunning      = errors.New("region refresh is running")
+	ErrCorruptRegionData   = errors.New("corrupt region data")
+	ErrIncompleteCrawl     = errors.New("incomplete region crawl")
+	ErrCircuitOpen         = errors.New("circuit breaker is open")
+)
+
+// Stable machine-readable codes of the errors above, see ErrorCode
+const (
+	ECODE_OK                   = "ok"
+	ECODE_BAD_PARAMETER        = "bad_parameter"
+	ECODE_CITY_NOT_FOUND       = "city_not_found"
+	ECODE_AMBIGUOUS_REGION     = "ambiguous_region"
+	ECODE_UPSTREAM_UNAVAILABLE = "upstream_unavailable"
+	ECODE_UPSTREAM_PARSE       = "upstream_parse"
+	ECODE_STALE_DATA           = "stale_data"
+	ECODE_REFRESH_RUNNING      = "refresh_running"
+	ECODE_CORRUPT_REGION_DATA  = "corrupt_region_data"
+	ECODE_INCOMPLETE_CRAWL     = "incomplete_crawl"
+	ECODE_INTERNAL             = "internal"
+)
+
+// ErrorCode returns the machine-readable code of err
+func ErrorCode(err error) string {
+	switch {
+	case nil == err:
+		return ECODE_OK
+	case errors.Is(err, ErrStaleData):
+		return ECODE_STALE_DATA
+	case errors.Is(err, ErrBadParameter):
+		return ECODE_BAD_PARAMETER
+	case errors.Is(err, ErrCityNotFound):
+		return ECODE_CITY_NOT_FOUND
+	case errors.Is(err, ErrAmbiguousRegion):
+		return ECODE_AMBIGUOUS_REGION
+	case errors.Is(err, ErrUpstreamParse):
+		return ECODE_UPSTREAM_PARSE
+	case errors.Is(err, ErrUpstreamUnavailable):
+		return ECODE_UPSTREAM_UNAVAILABLE
+	case errors.Is(err, ErrRefreshRunning):
+		return ECODE_REFRESH_RUNNING
+	case errors.Is(err, ErrCorruptRegionData):
+		return ECODE_CORRUPT_REGION_DATA
+	case errors.Is(err, ErrIncompleteCrawl):
+		return ECODE_INCOMPLETE_CRAWL
+	}
+	return ECODE_INTERNAL
+}
+
+// AmbiguousRegionError is returned when a name matches more than one region
+type AmbiguousRegionError struct {
+	Name       string       /*查询的名称*/
+	Candidates []RegionInfo /*所有匹配的地区*/
+}
+
+func (e *AmbiguousRegionError) Error() string {
+	names := make([]string, 0, len(e.Candidates))
+	for _, v := range e.Candidates {
+		names = append(names, v.FullName_)
+	}
+	return fmt.Sprintf("%s: %s may be %s", ErrAmbiguousRegion, e.Name, strings.Join(names, " | "))
+}
+
+func (e *AmbiguousRegionError) Is(target error) bool {
+	return target == ErrAmbiguousRegion
+}
+
+// UpstreamError is returned when the upstream can not be reached or does not answer 200
+type UpstreamError struct {
+	URL        string
+	StatusCode int   /*0 means no response*/
+	Err        error /*transport error, if any*/
+}
+
+func (e *UpstreamError) Error() string {
+	if nil != e.Err {
+		return fmt.Sprintf("%s: %s: %v", ErrUpstreamUnavailable, e.URL, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: status %d", ErrUpstreamUnavailable, e.URL, e.StatusCode)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+func (e *UpstreamError) Is(target error) bool {
+	return target == ErrUpstreamUnavailable
+}
+
+// Is makes a ParseError match ErrUpstreamParse
+func (e *ParseError) Is(target error) bool {
+	return target == ErrUpstreamParse
+}
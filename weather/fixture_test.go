@@ -0,0 +1,76 @@
+package weather
+
+import (
+	"WeatherInfos/weather/weathertest"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+func newFixtureWeatherCom() *WeatherCom {
+	return NewWeatherComWithTransport(weathertest.Transport{})
+}
+
+// pagesTransport answers the requests with the pages by their url, the other urls get 404
+type pagesTransport map[string]string
+
+func (p pagesTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, ok := p[req.URL.String()]
+	if !ok {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Status:     "404 Not Found",
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			Request:    req,
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+/*模拟的地区页面中的省份*/
+type stubProvince struct {
+	Spell, Name string
+	Cities      []stubCity
+}
+
+type stubCity struct {
+	Name     string
+	Counties [][2]string /*code, 名称*/
+}
+
+// regionPages renders the region index and the province pages the crawler reads
+func regionPages(provinces ...stubProvince) pagesTransport {
+	pages := make(pagesTransport)
+	var index strings.Builder
+	for _, p := range provinces {
+		fmt.Fprintf(&index, "<li><a href=\"/textFC/%s.shtml\" target=\"_blank\">%s</a></li>\n", p.Spell, p.Name)
+		var page strings.Builder
+		for _, city := range p.Cities {
+			fmt.Fprintf(&page, "<div class=\"conMidtab3\"><table><tr><td>%s</td>", city.Name)
+			for _, county := range city.Counties {
+				fmt.Fprintf(&page, "<td><a href=\"/weather/%s.shtml\" target=\"_blank\">%s</a></td>", county[0], county[1])
+				fmt.Fprintf(&page, "<td><a href=\"/weather/%s.shtml\" target=\"_blank\">%s</a></td>", county[0], DISCARD_INFO_FIELD)
+			}
+			page.WriteString("</tr></table></div>\n")
+		}
+		pages[WEATHER_SITE+"/textFC/"+p.Spell+".shtml"] = page.String()
+	}
+	pages[REGION_SITE] = index.String()
+	return pages
+}
+
+// newStubCrawler crawls the pages without waiting or retrying
+func newStubCrawler(pages pagesTransport) *Crawler {
+	cr := NewCrawler()
+	cr.Upstream = NewUpstream(pages)
+	cr.Retries = 0
+	cr.Interval = 0
+	return cr
+}
@@ -1,13 +1,12 @@
 package weather
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,124 +19,90 @@ func getNextMonth(t time.Time) (int, int) {
 	return nextMonth.Year(), int(nextMonth.Month())
 }
 
-func (c *Weather) GetFortyDaysInfoWeatherCom(province, district, city string) (r []FortyDaysInfo, err error) {
-	if "" == province {
-		return nil, errors.New("bad parameter")
-	}
-	if "" == district {
-		district = province
-		city = province
-	}
-	if "" == city {
-		city = district
-	}
-	c.regionMu.RLock()
-	var cityinfo RegionInfo
-	if province, h1 := c.treeRegion.Regions[province]; h1 { /*TODO: make it recursion*/
-		if dist, h2 := province.Regions[district]; h2 {
-			if city, h3 := dist.Regions[city]; h3 {
-				cityinfo = city.RegionInfo
-			}
-		}
-	}
-	c.regionMu.RUnlock()
-	if "" == cityinfo.Code_ || "" == cityinfo.Url_ {
-		return nil, errors.New("not found this city")
+// GetFortyDaysInfoWeatherCom returns the forty days predict of the region named by path, see ResolveRegion
+func (c *Weather) GetFortyDaysInfoWeatherCom(path ...string) (r []FortyDaysInfo, err error) {
+	return c.GetFortyDaysInfoWeatherComContext(context.Background(), path...)
+}
+
+// GetFortyDaysInfoWeatherComContext is GetFortyDaysInfoWeatherCom, the upstream requests give up once ctx is done
+func (c *Weather) GetFortyDaysInfoWeatherComContext(ctx context.Context, path ...string) (r []FortyDaysInfo, err error) {
+	cityinfo, err := c.ResolveRegion(path...)
+	if err != nil {
+		return nil, err
 	}
+	return c.getFortyDaysInfo(ctx, cityinfo)
+}
+
+// getFortyDaysInfo returns the cached forty days predict of cityinfo, refreshes it when it is out of date
+func (c *Weather) getFortyDaysInfo(ctx context.Context, cityinfo RegionInfo) ([]FortyDaysInfo, error) {
 
 	// 从缓存读取数据
-	c.fortyMu.RLock()
-	v, ok := c.fortydayslru.Get(cityinfo.Code_)
-	c.fortyMu.RUnlock()
-	
-	if ok {
-		cachedData := v.([]FortyDaysInfo)
-		if len(cachedData) > 0 && !timeCheckNew(cachedData[0].updateTime_, float64(UPDATE_WEATHERINFO_GAP_MINUTES)) {
-			return cachedData, nil
-		}
+	if cachedData, ok := c.fortydayslru.Get(cityinfo.Code_); ok && len(cachedData) > 0 {
+		return cachedData, nil
+	}
+	if cachedData, _, ok := c.fortydayslru.Stale(cityinfo.Code_); ok {
 		// 如果缓存数据过期但不为空，先返回缓存数据，同时异步更新
+		// 每个城市同时只有一个异步更新，且不随请求取消
 		if len(cachedData) > 0 {
-			go func() {
-				c.updateFortyDaysData(cityinfo)
-			}()
+			if !c.fortyFlight.Go(cityinfo.Code_, func(ctx context.Context) (interface{}, error) {
+				return c.updateFortyDaysData(ctx, cityinfo)
+			}) {
+				atomic.AddInt64(&c.ncoalesced, 1)
+			}
 			return cachedData, nil
 		}
 	}
 
-	// 获取新数据
-	return c.updateFortyDaysData(cityinfo)
+	// 获取新数据，同一城市同时只获取一次
+	v, err := c.coalesce(ctx, &c.fortyFlight, cityinfo.Code_, func(ctx context.Context) (interface{}, error) {
+		return c.updateFortyDaysData(ctx, cityinfo)
+	})
+	r, _ := v.([]FortyDaysInfo)
+	return r, err
 }
 
-func (c *Weather) updateFortyDaysData(cityinfo RegionInfo) ([]FortyDaysInfo, error) {
+func (c *Weather) updateFortyDaysData(ctx context.Context, cityinfo RegionInfo) ([]FortyDaysInfo, error) {
 	tNow := time.Now()
 	var rfortyInfos = make([]FortyDaysInfo, 0)
 	
 	//40天一般跨月了，所以请求两次
-	getFortyDaysInfoImpl(tNow.Year(), int(tNow.Month()), cityinfo.Code_, &rfortyInfos)
+	err := c.provider.FortyDays(ctx, tNow.Year(), int(tNow.Month()), cityinfo.Code_, &rfortyInfos)
 	y, m := getNextMonth(tNow)
-	getFortyDaysInfoImpl(y, m, cityinfo.Code_, &rfortyInfos)
+	if nextErr := c.provider.FortyDays(ctx, y, m, cityinfo.Code_, &rfortyInfos); nil == err {
+		err = nextErr
+	}
 
 	if len(rfortyInfos) == 0 {
-		return nil, errors.New("failed to fetch weather data")
+		if nil == err {
+			err = fmt.Errorf("%w: no forty days data of %s", ErrUpstreamUnavailable, cityinfo.Code_)
+		}
+		return nil, err
 	}
 
 	//write to cache
-	c.fortyMu.Lock()
 	c.fortydayslru.Add(cityinfo.Code_, rfortyInfos)
-	c.fortyMu.Unlock()
 	
 	return rfortyInfos, nil
 }
 
-func getFortyDaysInfoImpl(year, month int, code string, r *[]FortyDaysInfo) {
-	maxRetries := 3
-	var err error
-	var resp *http.Response
-	
-	for i := 0; i < maxRetries; i++ {
-		req, err := http.NewRequest("GET", fmt.Sprintf(FORTY_DAYS_PREDICT_URL, year, code, year, month), nil)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-		//cheat
-		req.Header.Add("Host", "d1.weather.com.cn")
-		req.Header.Add("Referer", "http://www.weather.com.cn/")
-		req.Header.Add("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0")
-		handle := &http.Client{Timeout: 10 * time.Second}
-		resp, err = handle.Do(req)
-		if err == nil && resp.StatusCode == http.StatusOK {
-			break
-		}
-		log.Printf("Attempt %d failed: %v\n", i+1, err)
-		if resp != nil {
-			resp.Body.Close()
-		}
-		time.Sleep(time.Second * time.Duration(i+1))
-	}
-	
-	if err != nil || resp == nil {
-		log.Printf("Failed to fetch data after %d attempts for code %s: %v\n", maxRetries, code, err)
-		return
-	}
-	defer resp.Body.Close()
-	
-	buf, err := ioutil.ReadAll(resp.Body)
+func (p *WeatherCom) getFortyDaysInfoImpl(ctx context.Context, year, month int, code string, r *[]FortyDaysInfo) error {
+	rawURL := fmt.Sprintf(FORTY_DAYS_PREDICT_URL, year, code, year, month)
+	buf, err := p.upstream().Get(ctx, ENDPOINT_FORTY_DAYS, rawURL)
 	if err != nil {
-		log.Printf("Failed to read response body: %v\n", err)
-		return
+		log.Printf("Failed to fetch data for code %s: %v\n", code, err)
+		return err
 	}
 	
 	if len(buf) <= 11 {
 		log.Printf("Response too short for code %s\n", code)
-		return
+		return &ParseError{Page: rawURL, Section: "forty days", Reason: "response too short"}
 	}
 	
 	var fortyInfos = make([]CalendarInfo, 36)
 	err = json.Unmarshal(buf[11:], &fortyInfos)
 	if err != nil {
 		log.Printf("Failed to unmarshal data for code %s: %v\n", code, err)
-		return
+		return &ParseError{Page: rawURL, Section: "forty days", Reason: err.Error()}
 	}
 	
 	validDataCount := 0
@@ -159,7 +124,6 @@ func getFortyDaysInfoImpl(year, month int, code string, r *[]FortyDaysInfo) {
 			Weather: v.W1, Wind: windLevel,
 			HTemp: v.Max, MTemp: v.Min, HMax: v.Hmax, HMin: v.Hmin, HRate: v.Hgl, HRain: v.Rainobs,
 			Ripe: v.Als, Avoid: v.Alins,
-			updateTime_: time.Now(),
 		}
 		if "" == tmp.Weather && "" != tmp.WCodeOne {
 			tmp.Weather = GetWeatherByCode(tmp.WCodeOne)
@@ -173,4 +137,5 @@ func getFortyDaysInfoImpl(year, month int, code string, r *[]FortyDaysInfo) {
 	} else {
 		log.Printf("Successfully processed %d records for code %s\n", validDataCount, code)
 	}
+	return nil
 }
@@ -0,0 +1,133 @@
+package weather
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	STATION_COORDS_FILE = "./station_coords.csv" /*可选，每行: code,经度,纬度*/
+	EARTH_RADIUS_KM     = 6371.0
+	COORDS_MISMATCH_KM  = 50.0
+	NEAREST_MAX_KM      = 50.0 /*超过该距离的站点不作为最近的站点*/
+)
+
+// FindNearest returns the county-level station closest to lat/lon and its distance in kilometers.
+// Only the stations whose coordinates are known take part, see SeedCoordinates and STATION_COORDS_FILE;
+// ErrCityNotFound is returned when none of them is within NEAREST_MAX_KM.
+func (c *Weather) FindNearest(lat, lon float64) (RegionInfo, float64, error) {
+	if math.IsNaN(lat) || math.IsNaN(lon) || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return RegionInfo{}, 0, ErrBadParameter
+	}
+	c.regionMu.RLock()
+	defer c.regionMu.RUnlock()
+	var nearest *TreeRegionInfo
+	var minDist = math.MaxFloat64
+	for _, region := range c.codeIndex {
+		if !region.hasCoordinates() {
+			continue
+		}
+		if dist := haversine(lat, lon, region.Latitude_, region.Longitude_); dist < minDist {
+			nearest, minDist = region, dist
+		}
+	}
+	if nil == nearest || minDist > NEAREST_MAX_KM {
+		return RegionInfo{}, 0, fmt.Errorf("%w: no station within %.0fkm", ErrCityNotFound, NEAREST_MAX_KM)
+	}
+	return nearest.RegionInfo, minDist, nil
+}
+
+// SeedCoordinates fills the station coordinates from the alarm list,
+// the issuer of an alarm is located at the station of the alarm file.
+// It returns how many stations got new coordinates, the region file is saved when any did
+// and the region tree is complete.
+func (c *Weather) SeedCoordinates(infos map[string][]Location) int {
+	seeded := c.seedCoordinates(infos)
+	if seeded > 0 {
+		if err := c.persistRegionData(REGION_CACHE_FILE); nil != err {
+			log.Println(err)
+		}
+	}
+	return seeded
+}
+
+func (c *Weather) seedCoordinates(infos map[string][]Location) int {
+	c.regionMu.Lock()
+	defer c.regionMu.Unlock()
+	var seeded int
+	for code, locations := range infos {
+		region, ok := c.codeIndex[code]
+		if !ok {
+			continue
+		}
+		for _, v := range locations {
+			lon, err1 := strconv.ParseFloat(v.Longitude, 64)
+			lat, err2 := strconv.ParseFloat(v.Latitude, 64)
+			if nil != err1 || nil != err2 {
+				continue
+			}
+			if region.hasCoordinates() {
+				if dist := haversine(lat, lon, region.Latitude_, region.Longitude_); dist > COORDS_MISMATCH_KM {
+					log.Printf("coordinates of %s(%s) differ from the alarm list by %.1fkm", region.FullName_, code, dist)
+				}
+				break
+			}
+			region.Latitude_, region.Longitude_ = lat, lon
+			seeded++
+			break
+		}
+	}
+	return seeded
+}
+
+// loadStationCoordinates reads the coordinates of the stations in index from a csv file,
+// regionMu must be held when index is codeIndex
+func loadStationCoordinates(path string, index map[string]*TreeRegionInfo) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var loaded int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, STR_SEP)
+		if len(fields) < 3 {
+			continue
+		}
+		region, ok := index[strings.TrimSpace(fields[0])]
+		if !ok {
+			continue
+		}
+		lon, err1 := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		lat, err2 := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+		if nil != err1 || nil != err2 {
+			continue
+		}
+		region.Latitude_, region.Longitude_ = lat, lon
+		loaded++
+	}
+	return loaded, scanner.Err()
+}
+
+func (r *RegionInfo) hasCoordinates() bool {
+	return 0 != r.Latitude_ || 0 != r.Longitude_
+}
+
+// haversine returns the great-circle distance of two points in kilometers
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EARTH_RADIUS_KM * math.Asin(math.Sqrt(a))
+}
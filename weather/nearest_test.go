@@ -0,0 +1,125 @@
+package weather
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*在临时目录中执行，REGION_CACHE_FILE等相对路径写在那里*/
+func inTempDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestSeedCoordinates(t *testing.T) {
+	inTempDir(t)
+	infos := map[string][]Location{
+		"101020200": {{Name: "闵行", Longitude: "121.38", Latitude: "31.11"}},
+		"101021300": {{Name: "浦东", Longitude: "bad", Latitude: "31.22"}},
+		"101999999": {{Name: "unknown", Longitude: "120", Latitude: "30"}},
+	}
+
+	for _, complete := range []bool{false, true} {
+		c := New(0, newFixtureWeatherCom())
+		defer c.Close()
+		c.treeRegion = testRegionTree()
+		c.regionComplete = complete
+		c.indexRegions()
+		if n := c.SeedCoordinates(infos); 1 != n {
+			t.Errorf("SeedCoordinates() = %d, want 1", n)
+		}
+		if region := c.codeIndex["101020200"]; 31.11 != region.Latitude_ || 121.38 != region.Longitude_ {
+			t.Errorf("闵行 at %v,%v", region.Latitude_, region.Longitude_)
+		}
+
+		/*不完整的列表不保存*/
+		tree, _, err := readRegionFile(REGION_CACHE_FILE)
+		if !complete {
+			if !os.IsNotExist(err) {
+				t.Errorf("an incomplete tree is saved: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if county := tree.Regions["shanghai"].Regions["shanghai"].Regions["minxing"]; 31.11 != county.Latitude_ {
+			t.Errorf("saved 闵行 at %v,%v", county.Latitude_, county.Longitude_)
+		}
+		if n := c.SeedCoordinates(infos); 0 != n {
+			t.Errorf("SeedCoordinates() seeded %d known stations again", n)
+		}
+	}
+}
+
+func TestFindNearest(t *testing.T) {
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	c.treeRegion = testRegionTree()
+	c.indexRegions()
+	if _, _, err := c.FindNearest(31.2, 121.4); !errors.Is(err, ErrCityNotFound) {
+		t.Errorf("FindNearest() without coordinates = %v, want ErrCityNotFound", err)
+	}
+	c.codeIndex["101020100"].Latitude_, c.codeIndex["101020100"].Longitude_ = 31.23, 121.47
+	c.codeIndex["101020200"].Latitude_, c.codeIndex["101020200"].Longitude_ = 31.11, 121.38
+
+	for _, tc := range []struct {
+		lat, lon float64
+		code     string /*空表示找不到*/
+		err      error
+	}{
+		{31.12, 121.37, "101020200", nil},
+		{31.24, 121.49, "101020100", nil},
+		{31.5, 121.7, "101020100", nil},      /*约37公里*/
+		{39.90, 116.41, "", ErrCityNotFound}, /*北京附近没有已知坐标的站点*/
+		{91, 121, "", ErrBadParameter},
+		{31, 181, "", ErrBadParameter},
+		{math.NaN(), 121, "", ErrBadParameter},
+	} {
+		region, dist, err := c.FindNearest(tc.lat, tc.lon)
+		if !errors.Is(err, tc.err) || tc.code != region.Code_ {
+			t.Errorf("FindNearest(%v, %v) = %s, %v; want %s, %v", tc.lat, tc.lon, region.Code_, err, tc.code, tc.err)
+		}
+		if nil == err && (dist < 0 || dist > NEAREST_MAX_KM) {
+			t.Errorf("FindNearest(%v, %v) is %.1fkm away", tc.lat, tc.lon, dist)
+		}
+	}
+}
+
+func TestStationCoordsFile(t *testing.T) {
+	/*仓库中的坐标文件：每行都能解析，且坐标在中国境内*/
+	path := filepath.Join("..", STATION_COORDS_FILE)
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := make(map[string]*TreeRegionInfo)
+	for _, line := range strings.Split(string(buf), "\n") {
+		if line = strings.TrimSpace(line); "" != line && !strings.HasPrefix(line, "#") {
+			code := strings.Split(line, STR_SEP)[0]
+			index[code] = &TreeRegionInfo{RegionInfo: RegionInfo{Code_: code}}
+		}
+	}
+	n, err := loadStationCoordinates(path, index)
+	if err != nil || n != len(index) || 0 == n {
+		t.Fatalf("loaded %d of %d stations, %v", n, len(index), err)
+	}
+	for code, region := range index {
+		if !cityCodeRe.MatchString(code) || region.Latitude_ < 18 || region.Latitude_ > 54 || region.Longitude_ < 73 || region.Longitude_ > 135 {
+			t.Errorf("%s at %v,%v is not a station in China", code, region.Latitude_, region.Longitude_)
+		}
+	}
+	if region := index["101020100"]; haversine(31.23, 121.47, region.Latitude_, region.Longitude_) > 10 {
+		t.Errorf("上海 at %v,%v", region.Latitude_, region.Longitude_)
+	}
+}
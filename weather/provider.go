@@ -0,0 +1,69 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Provider is an upstream source of weather data.
+// Weather only talks to its provider, so a second source or a local fake can be
+// plugged in through New without touching the cache and region logic.
+// Every call should give up once ctx is done.
+type Provider interface {
+	// SevenDays fetches the seven days predict and the live index of a city
+	SevenDays(ctx context.Context, cityinfo RegionInfo) (*WeatherInfo, error)
+	// Current fills r.CurrentInfo with the current weather of the city code
+	Current(ctx context.Context, code string, r *WeatherInfo) error
+	// Hours fills r.HoursPredict_ with the hourly predict of the city code
+	Hours(ctx context.Context, code string, r *WeatherInfo) error
+	// FortyDays appends the daily predict of the given month to r
+	FortyDays(ctx context.Context, year, month int, code string, r *[]FortyDaysInfo) error
+	// Alarm appends the details of an alarm file to r.AlarmInfo_
+	Alarm(ctx context.Context, fileName string, r *WeatherInfo) error
+}
+
+// WeatherCom is the default provider, it scrapes www.weather.com.cn
+type WeatherCom struct {
+	Upstream *Upstream /*用于访问weather.com.cn的client，可替换Transport进行离线测试*/
+}
+
+var defaultWeatherCom = NewWeatherCom()
+
+// NewWeatherCom creates a weather.com.cn provider on the shared upstream client
+func NewWeatherCom() *WeatherCom {
+	return &WeatherCom{Upstream: defaultUpstream}
+}
+
+// NewWeatherComWithTransport creates a weather.com.cn provider whose requests go through rt
+func NewWeatherComWithTransport(rt http.RoundTripper) *WeatherCom {
+	return &WeatherCom{Upstream: NewUpstream(rt)}
+}
+
+func (p *WeatherCom) upstream() *Upstream {
+	if nil == p.Upstream {
+		return defaultUpstream
+	}
+	return p.Upstream
+}
+
+func (p *WeatherCom) SevenDays(ctx context.Context, cityinfo RegionInfo) (*WeatherInfo, error) {
+	return p.get7DaysWeatherInfoByCityNew(ctx, cityinfo)
+}
+
+func (p *WeatherCom) Current(ctx context.Context, code string, r *WeatherInfo) error {
+	return p.getCurrentInfo(ctx, fmt.Sprintf(CURRENT_INFO_API, code, time.Now().Nanosecond()), r)
+}
+
+func (p *WeatherCom) Hours(ctx context.Context, code string, r *WeatherInfo) error {
+	return p.getHourInfos(ctx, fmt.Sprintf(HOUR_INFOS_URL, code), r)
+}
+
+func (p *WeatherCom) FortyDays(ctx context.Context, year, month int, code string, r *[]FortyDaysInfo) error {
+	return p.getFortyDaysInfoImpl(ctx, year, month, code, r)
+}
+
+func (p *WeatherCom) Alarm(ctx context.Context, fileName string, r *WeatherInfo) error {
+	return p.getAlarmDetails(ctx, ALARM_DETAILS+fileName, r)
+}
@@ -0,0 +1,82 @@
+package weather
+
+import (
+	"context"
+	"regexp"
+)
+
+var cityCodeRe = regexp.MustCompile("^" + REGEXP_GET_CITY_CODE + "$")
+
+// indexRegionCodes rebuilds the station code index of the region tree, regionMu must be held
+func (c *Weather) indexRegionCodes() {
+	c.codeIndex = regionCodes(c.treeRegion)
+}
+
+// regionCodes returns the county-level regions of a tree by their station code
+func regionCodes(tree *TreeRegionInfo) map[string]*TreeRegionInfo {
+	index := make(map[string]*TreeRegionInfo)
+	var walk func(region *TreeRegionInfo)
+	walk = func(region *TreeRegionInfo) {
+		if 0 == len(region.Regions) && "" != region.Code_ { /*省份的code与其省会相同，只索引区县*/
+			index[region.Code_] = region
+		}
+		for _, sub := range region.Regions {
+			walk(sub)
+		}
+	}
+	walk(tree)
+	return index
+}
+
+// RegionByCode returns the region of a weather.com.cn station code, such as 101020100
+func (c *Weather) RegionByCode(code string) (RegionInfo, error) {
+	if !cityCodeRe.MatchString(code) {
+		return RegionInfo{}, ErrBadParameter
+	}
+	c.regionMu.RLock()
+	defer c.regionMu.RUnlock()
+	region, ok := c.codeIndex[code]
+	if !ok {
+		return RegionInfo{}, ErrCityNotFound
+	}
+	return region.RegionInfo, nil
+}
+
+// ShowCityWeatherByCode is ShowCityWeather by the station code
+func (c *Weather) ShowCityWeatherByCode(code string) (*WeatherInfo, error) {
+	return c.ShowCityWeatherByCodeContext(context.Background(), code)
+}
+
+// ShowCityWeatherByCodeContext is ShowCityWeatherContext by the station code
+func (c *Weather) ShowCityWeatherByCodeContext(ctx context.Context, code string) (*WeatherInfo, error) {
+	cityinfo, err := c.RegionByCode(code)
+	if err != nil {
+		return nil, err
+	}
+	return c.showRegionWeather(ctx, cityinfo)
+}
+
+// GetFortyDaysInfoByCode is GetFortyDaysInfoWeatherCom by the station code
+func (c *Weather) GetFortyDaysInfoByCode(code string) ([]FortyDaysInfo, error) {
+	return c.GetFortyDaysInfoByCodeContext(context.Background(), code)
+}
+
+// GetFortyDaysInfoByCodeContext is GetFortyDaysInfoWeatherComContext by the station code
+func (c *Weather) GetFortyDaysInfoByCodeContext(ctx context.Context, code string) ([]FortyDaysInfo, error) {
+	cityinfo, err := c.RegionByCode(code)
+	if err != nil {
+		return nil, err
+	}
+	return c.getFortyDaysInfo(ctx, cityinfo)
+}
+
+// ShowCityListByCode returns the region of the station code in the same shape as ShowCityList
+func (c *Weather) ShowCityListByCode(code string) (map[string]interface{}, error) {
+	cityinfo, err := c.RegionByCode(code)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		cityinfo.Spell_: []interface{}{map[string]string{"Name": cityinfo.FullName_, "Spell": cityinfo.Spell_, "Code": cityinfo.Code_}},
+	}, nil
+}
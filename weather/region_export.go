@@ -0,0 +1,266 @@
+package weather
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EXPORT_FORMAT_CSV     = "csv"
+	EXPORT_FORMAT_JSON    = "json"
+	EXPORT_FORMAT_GEOJSON = "geojson"
+)
+
+var regionCSVHeader = []string{"province", "city", "county", "spell", "code", "url", "latitude", "longitude"}
+
+// RegionRecord is a county-level station in the flat export of the region tree
+type RegionRecord struct {
+	Province  string  `json:"province"`
+	City      string  `json:"city"`
+	County    string  `json:"county"`
+	Spell     string  `json:"spell"`
+	Code      string  `json:"code"`
+	Url       string  `json:"url"`
+	Latitude  float64 `json:"lat,omitempty"`
+	Longitude float64 `json:"lon,omitempty"`
+}
+
+// RegionRecords returns the stations below the region named by path, all of them when path is empty, ordered by code
+func (c *Weather) RegionRecords(path ...string) ([]RegionRecord, error) {
+	c.regionMu.RLock()
+	defer c.regionMu.RUnlock()
+	var node = c.treeRegion
+	var names []string
+	if len(strings.Join(path, "")) > 0 {
+		var err error
+		if node, err = c.resolveNode(path); err != nil {
+			return nil, err
+		}
+		names = strings.Split(node.FullName_, STR_SEP)
+		if "" == node.FullName_ { /*省级没有全称*/
+			names = []string{node.Name_}
+		}
+		names = names[:len(names)-1]
+	}
+
+	var records []RegionRecord
+	var walk func(region *TreeRegionInfo, names []string)
+	walk = func(region *TreeRegionInfo, names []string) {
+		if region != c.treeRegion {
+			names = append(names, region.Name_)
+		}
+		if 0 == len(region.Regions) && "" != region.Code_ {
+			record := RegionRecord{
+				Spell: region.Spell_, Code: region.Code_, Url: region.Url_,
+				Latitude: region.Latitude_, Longitude: region.Longitude_,
+			}
+			for i, v := range []*string{&record.Province, &record.City, &record.County} {
+				if i < len(names) {
+					*v = names[i]
+				}
+			}
+			records = append(records, record)
+		}
+		for _, sub := range region.Regions {
+			walk(sub, append([]string{}, names...))
+		}
+	}
+	walk(node, names)
+	sort.Slice(records, func(i, j int) bool { return records[i].Code < records[j].Code })
+	return records, nil
+}
+
+// WriteRegionRecords writes the records in one of the export formats
+func WriteRegionRecords(w io.Writer, format string, records []RegionRecord) error {
+	switch format {
+	case EXPORT_FORMAT_CSV:
+		return writeRegionCSV(w, records)
+	case EXPORT_FORMAT_JSON:
+		if nil == records {
+			records = []RegionRecord{}
+		}
+		return json.NewEncoder(w).Encode(records)
+	case EXPORT_FORMAT_GEOJSON:
+		return writeRegionGeoJSON(w, records)
+	}
+	return fmt.Errorf("%w: unknown format %s", ErrBadParameter, format)
+}
+
+func writeRegionCSV(w io.Writer, records []RegionRecord) error {
+	writer := csv.NewWriter(w)
+	writer.Write(regionCSVHeader)
+	for _, v := range records {
+		var lat, lon string
+		if 0 != v.Latitude || 0 != v.Longitude {
+			lat = strconv.FormatFloat(v.Latitude, 'f', -1, 64)
+			lon = strconv.FormatFloat(v.Longitude, 'f', -1, 64)
+		}
+		writer.Write([]string{v.Province, v.City, v.County, v.Spell, v.Code, v.Url, lat, lon})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type geoJSONFeature struct {
+	Type       string        `json:"type"`
+	Geometry   *geoJSONPoint `json:"geometry"` /*没有坐标时为null*/
+	Properties RegionRecord  `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` /*经度,纬度*/
+}
+
+func writeRegionGeoJSON(w io.Writer, records []RegionRecord) error {
+	features := make([]geoJSONFeature, 0, len(records))
+	for _, v := range records {
+		feature := geoJSONFeature{Type: "Feature", Properties: v}
+		if 0 != v.Latitude || 0 != v.Longitude {
+			feature.Geometry = &geoJSONPoint{Type: "Point", Coordinates: [2]float64{v.Longitude, v.Latitude}}
+		}
+		features = append(features, feature)
+	}
+	return json.NewEncoder(w).Encode(map[string]interface{}{"type": "FeatureCollection", "features": features})
+}
+
+// ReadRegionCSV reads the records of a csv in the export layout, the header line is required
+func ReadRegionCSV(r io.Reader) ([]RegionRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: read csv header: %v", ErrBadParameter, err)
+	}
+	columns := make(map[string]int)
+	for i, v := range header {
+		columns[strings.ToLower(strings.TrimSpace(v))] = i
+	}
+	for _, v := range []string{"province", "city", "county", "code"} {
+		if _, ok := columns[v]; !ok {
+			return nil, fmt.Errorf("%w: csv column %s is missing", ErrBadParameter, v)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var records []RegionRecord
+	codes := make(map[string]int)
+	names := make(map[string]int) /*省,市,县 -> 行号*/
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrBadParameter, line, err)
+		}
+		record := RegionRecord{
+			Province: field(row, "province"), City: field(row, "city"), County: field(row, "county"),
+			Spell: field(row, "spell"), Code: field(row, "code"), Url: field(row, "url"),
+		}
+		if "" == record.Province || "" == record.City || "" == record.County {
+			return nil, fmt.Errorf("%w: line %d: province, city and county are required", ErrBadParameter, line)
+		}
+		if !cityCodeRe.MatchString(record.Code) {
+			return nil, fmt.Errorf("%w: line %d: bad code %q", ErrBadParameter, line, record.Code)
+		}
+		if first, had := codes[record.Code]; had {
+			return nil, fmt.Errorf("%w: line %d: code %s is already used on line %d", ErrBadParameter, line, record.Code, first)
+		}
+		codes[record.Code] = line
+		fullname := strings.Join([]string{record.Province, record.City, record.County}, STR_SEP)
+		if first, had := names[fullname]; had {
+			return nil, fmt.Errorf("%w: line %d: %s is already on line %d", ErrBadParameter, line, fullname, first)
+		}
+		names[fullname] = line
+		if lat, lon := field(row, "latitude"), field(row, "longitude"); "" != lat || "" != lon {
+			var err1, err2 error
+			record.Latitude, err1 = strconv.ParseFloat(lat, 64)
+			record.Longitude, err2 = strconv.ParseFloat(lon, 64)
+			if nil != err1 || nil != err2 {
+				return nil, fmt.Errorf("%w: line %d: bad coordinates", ErrBadParameter, line)
+			}
+		}
+		records = append(records, record)
+	}
+	if 0 == len(records) {
+		return nil, fmt.Errorf("%w: no region in csv", ErrBadParameter)
+	}
+	return records, nil
+}
+
+// BuildRegionTree builds a region tree of the same shape as the crawled one from the records,
+// the siblings sharing a spell are kept apart by siblingKey as the crawler does
+func BuildRegionTree(records []RegionRecord) *TreeRegionInfo {
+	tree := &TreeRegionInfo{Regions: make(map[string]*TreeRegionInfo)}
+	for _, v := range records {
+		spells := strings.Split(v.Spell, STR_SEP)
+		if 3 != len(spells) {
+			spells = []string{Spell(v.Province), Spell(v.City), Spell(v.County)}
+		}
+		spells[0] = siblingKey(tree.Regions, spells[0], v.Province)
+		province, ok := tree.Regions[spells[0]]
+		if !ok {
+			province = &TreeRegionInfo{
+				RegionInfo: RegionInfo{Name_: v.Province, Spell_: spells[0]},
+				Regions:    make(map[string]*TreeRegionInfo),
+			}
+			tree.Regions[spells[0]] = province
+		}
+		spells[1] = siblingKey(province.Regions, spells[1], v.City)
+		city, ok := province.Regions[spells[1]]
+		if !ok {
+			city = &TreeRegionInfo{
+				RegionInfo: RegionInfo{Name_: v.City, FullName_: v.Province + STR_SEP + v.City, Spell_: spells[1]},
+				Regions:    make(map[string]*TreeRegionInfo),
+			}
+			province.Regions[spells[1]] = city
+		}
+		url := v.Url
+		if "" == url {
+			url = fmt.Sprintf("/weather/%s.shtml", v.Code)
+		}
+		spells[2] = siblingKey(city.Regions, spells[2], v.County)
+		city.Regions[spells[2]] = &TreeRegionInfo{
+			RegionInfo: RegionInfo{
+				Url_: url, Code_: v.Code, Name_: v.County,
+				FullName_: strings.Join([]string{v.Province, v.City, v.County}, STR_SEP),
+				Spell_:    strings.Join(spells, STR_SEP),
+				Latitude_: v.Latitude, Longitude_: v.Longitude,
+			},
+		}
+	}
+	for _, province := range tree.Regions { /*与抓取时一致，省份的code取省会*/
+		if city, ok := province.Regions[province.Spell_]; ok {
+			if county, ok := city.Regions[province.Spell_]; ok {
+				province.Code_ = county.Code_
+			}
+		}
+	}
+	return tree
+}
+
+// ImportRegionRecords replaces the region tree by the records and saves it to path
+func (c *Weather) ImportRegionRecords(records []RegionRecord, path string) error {
+	tree := BuildRegionTree(records)
+	c.regionFileMu.Lock()
+	defer c.regionFileMu.Unlock()
+	c.regionMu.Lock()
+	defer c.regionMu.Unlock()
+	c.treeRegion = tree
+	c.regionBuilt = time.Now()
+	c.regionComplete = true
+	c.indexRegions()
+	return c.saveRegionData(path)
+}
@@ -0,0 +1,240 @@
+package weather
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildRegionTree(t *testing.T) {
+	/*与TestCrawl相同：同一城市中拼音相同的区县，同一省份中拼音相同的城市，没有spell列*/
+	records := []RegionRecord{
+		{Province: "天津", City: "天津", County: "天津", Code: "101030100"},
+		{Province: "天津", City: "天津", County: "和平", Code: "101030101"},
+		{Province: "天津", City: "天津", County: "河平", Code: "101030102"},
+		{Province: "天津", City: "通州", County: "通州", Code: "101030201"},
+		{Province: "天津", City: "同州", County: "同州", Code: "101030301"},
+	}
+	tree := BuildRegionTree(records)
+	codes := regionCodes(tree)
+	if len(records) != len(codes) {
+		t.Fatalf("built %d stations, want %d", len(codes), len(records))
+	}
+	for _, v := range records {
+		if region := codes[v.Code]; nil == region || v.County != region.Name_ {
+			t.Errorf("station %s = %+v, want %s", v.Code, region, v.County)
+		}
+	}
+	tianjin := tree.Regions["tianjin"]
+	if "101030100" != tianjin.Code_ {
+		t.Errorf("天津 code %q", tianjin.Code_)
+	}
+	if county := tianjin.Regions["tianjin"].Regions["heping2"]; nil == county || "tianjin,tianjin,heping2" != county.Spell_ {
+		t.Errorf("河平 %+v", county)
+	}
+	if city := tianjin.Regions["tongzhou2"]; nil == city || "同州" != city.Name_ || "tongzhou2" != city.Spell_ {
+		t.Errorf("同州 %+v", city)
+	}
+
+	/*导出的spell列再导入时得到同样的树*/
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	c.treeRegion = tree
+	c.indexRegions()
+	exported, err := c.RegionRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := regionCodes(BuildRegionTree(exported))
+	for code, region := range codes {
+		if v := again[code]; nil == v || region.Spell_ != v.Spell_ || region.FullName_ != v.FullName_ {
+			t.Errorf("station %s = %+v after the round trip, want %+v", code, v, region)
+		}
+	}
+	for path, code := range map[[2]string]string{
+		{"天津", "河平"}: "101030102",
+		{"天津", "和平"}: "101030101",
+		{"同州", ""}:   "101030301",
+		{"通州", ""}:   "101030201",
+	} {
+		if region, err := c.ResolveRegion(path[:]...); err != nil || code != region.Code_ {
+			t.Errorf("ResolveRegion(%q) = %s, %v; want %s", path, region.Code_, err, code)
+		}
+	}
+}
+
+func TestRegionRecords(t *testing.T) {
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	c.treeRegion = testResolveTree()
+	c.indexRegions()
+	c.codeIndex["101270101"].Latitude_, c.codeIndex["101270101"].Longitude_ = 30.57, 104.07
+
+	for _, tc := range []struct {
+		path  []string
+		codes []string
+		err   error
+	}{
+		{nil, []string{"101010100", "101010300", "101071201", "101071204", "101270101", "101270111", "101270401", "101270402"}, nil},
+		{[]string{"四川", "成都"}, []string{"101270101", "101270111"}, nil},
+		{[]string{"绵阳"}, []string{"101270401", "101270402"}, nil},
+		{[]string{"辽宁"}, []string{"101071201", "101071204"}, nil},
+		{[]string{"都江堰"}, []string{"101270111"}, nil},
+		{[]string{"拉萨"}, nil, ErrCityNotFound},
+	} {
+		records, err := c.RegionRecords(tc.path...)
+		var codes []string
+		for _, v := range records {
+			codes = append(codes, v.Code)
+		}
+		if !errors.Is(err, tc.err) || strings.Join(tc.codes, STR_SEP) != strings.Join(codes, STR_SEP) {
+			t.Errorf("RegionRecords(%q) = %v, %v; want %v, %v", tc.path, codes, err, tc.codes, tc.err)
+		}
+	}
+	records, _ := c.RegionRecords("都江堰")
+	if want := (RegionRecord{Province: "四川", City: "成都", County: "都江堰", Code: "101270111", Url: "/weather1d/101270111.shtml"}); want != records[0] {
+		t.Errorf("都江堰 %+v, want %+v", records[0], want)
+	}
+}
+
+func TestWriteRegionRecords(t *testing.T) {
+	records := []RegionRecord{
+		{Province: "四川", City: "成都", County: "成都", Spell: "sichuan,chengdu,chengdu", Code: "101270101", Url: "/weather/101270101.shtml", Latitude: 30.57, Longitude: 104.07},
+		{Province: "四川", City: "成都", County: "都江堰", Spell: "sichuan,chengdu,dujiangyan", Code: "101270111", Url: "/weather/101270111.shtml"},
+	}
+	var buf bytes.Buffer
+	if err := WriteRegionRecords(&buf, EXPORT_FORMAT_CSV, records); err != nil {
+		t.Fatal(err)
+	}
+	want := "province,city,county,spell,code,url,latitude,longitude\n" +
+		"四川,成都,成都,\"sichuan,chengdu,chengdu\",101270101,/weather/101270101.shtml,30.57,104.07\n" +
+		"四川,成都,都江堰,\"sichuan,chengdu,dujiangyan\",101270111,/weather/101270111.shtml,,\n"
+	if want != buf.String() {
+		t.Errorf("csv\n%s\nwant\n%s", buf.String(), want)
+	}
+	if read, err := ReadRegionCSV(&buf); err != nil || !reflect.DeepEqual(records, read) {
+		t.Errorf("ReadRegionCSV() = %+v, %v", read, err)
+	}
+
+	buf.Reset()
+	var decoded []RegionRecord
+	if err := WriteRegionRecords(&buf, EXPORT_FORMAT_JSON, records); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || !reflect.DeepEqual(records, decoded) {
+		t.Errorf("json %s, %v", buf.String(), err)
+	}
+	buf.Reset()
+	if WriteRegionRecords(&buf, EXPORT_FORMAT_JSON, nil); "[]\n" != buf.String() {
+		t.Errorf("json of no record %q", buf.String())
+	}
+
+	buf.Reset()
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			Geometry *struct {
+				Type        string     `json:"type"`
+				Coordinates [2]float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties RegionRecord `json:"properties"`
+		} `json:"features"`
+	}
+	if err := WriteRegionRecords(&buf, EXPORT_FORMAT_GEOJSON, records); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &collection); err != nil || "FeatureCollection" != collection.Type || 2 != len(collection.Features) {
+		t.Fatalf("geojson %s, %v", buf.String(), err)
+	}
+	if point := collection.Features[0].Geometry; nil == point || "Point" != point.Type || [2]float64{104.07, 30.57} != point.Coordinates {
+		t.Errorf("geojson point %+v, want longitude first", point)
+	}
+	if nil != collection.Features[1].Geometry || records[1] != collection.Features[1].Properties {
+		t.Errorf("geojson feature without coordinates %+v", collection.Features[1])
+	}
+
+	buf.Reset()
+	if err := WriteRegionRecords(&buf, "xml", records); !errors.Is(err, ErrBadParameter) || buf.Len() > 0 {
+		t.Errorf("WriteRegionRecords(xml) = %v, wrote %q", err, buf.String())
+	}
+}
+
+func TestReadRegionCSV(t *testing.T) {
+	const header = "province,city,county,code\n"
+	for _, tc := range []struct {
+		csv  string
+		err  string /*空表示成功*/
+		want int
+	}{
+		{header + "四川,成都,成都,101270101\n四川,成都,都江堰,101270111\n", "", 2},
+		{"County,Province,CITY,Code,Extra\n成都,四川,成都,101270101,x\n", "", 1}, /*列的顺序及大小写不限*/
+		{"", "read csv header", 0},
+		{"province,city,code\n", "column county is missing", 0},
+		{header, "no region in csv", 0},
+		{header + "四川,成都,成都,101270101\n四川,,都江堰,101270111\n", "line 3: province, city and county are required", 0},
+		{header + "四川,成都,成都,10127\n", "line 2: bad code \"10127\"", 0},
+		{header + "四川,成都,成都,101270101\n四川,成都,都江堰,101270101\n", "line 3: code 101270101 is already used on line 2", 0},
+		{header + "四川,成都,成都,101270101\n\n四川,成都,成都,101270111\n", "line 3: 四川,成都,成都 is already on line 2", 0},
+		{"province,city,county,code,latitude,longitude\n四川,成都,成都,101270101,30.57,\n", "line 2: bad coordinates", 0},
+		{header + "四川,成都,\"成都,101270101\n", "line 2:", 0},
+	} {
+		records, err := ReadRegionCSV(strings.NewReader(tc.csv))
+		if "" == tc.err {
+			if err != nil || tc.want != len(records) {
+				t.Errorf("ReadRegionCSV(%q) = %d records, %v; want %d", tc.csv, len(records), err, tc.want)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrBadParameter) || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("ReadRegionCSV(%q) = %v, want %q", tc.csv, err, tc.err)
+		}
+	}
+}
+
+func TestImportRegionRecords(t *testing.T) {
+	inTempDir(t)
+	src := New(0, newFixtureWeatherCom())
+	defer src.Close()
+	src.treeRegion = testResolveTree()
+	src.indexRegions()
+	src.codeIndex["101270101"].Latitude_, src.codeIndex["101270101"].Longitude_ = 30.57, 104.07
+	exported, err := src.RegionRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteRegionRecords(&buf, EXPORT_FORMAT_CSV, exported); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadRegionCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	if err := c.ImportRegionRecords(records, REGION_CACHE_FILE); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := c.RegionRecords()
+	for i := range imported {
+		if strings.Count(imported[i].Spell, STR_SEP) != 2 {
+			t.Errorf("imported spell %q", imported[i].Spell)
+		}
+		imported[i].Spell = "" /*测试树的区县没有spell，导入时补上*/
+	}
+	if err != nil || !reflect.DeepEqual(exported, imported) {
+		t.Errorf("imported %+v, %v; want %+v", imported, err, exported)
+	}
+	if region, err := c.ResolveRegion("四川"); err != nil || "101270101" != region.Code_ || 30.57 != region.Latitude_ {
+		t.Errorf("ResolveRegion(四川) = %+v, %v", region, err)
+	}
+	tree, header, err := readRegionFile(REGION_CACHE_FILE)
+	if err != nil || len(exported) != header.Stations || len(exported) != len(regionCodes(tree)) {
+		t.Errorf("saved %+v, %v", header, err)
+	}
+}
@@ -0,0 +1,259 @@
+package weather
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+*  Region file layout:
+*  REGION_FILE_MAGIC | gob(RegionFileHeader) | gob([]byte payload)
+*  the payload is the gob of the TreeRegionInfo in the schema of Header.Version,
+*  a file without the magic is the raw gob written before the header existed (version 0)
+ */
+const (
+	REGION_FILE_MAGIC   = "WIREGION"
+	REGION_FILE_VERSION = 1 /*1: 增加了站点坐标*/
+	REGION_FILE_PREV    = ".prev"
+	REGION_FILE_CORRUPT = ".corrupt"
+)
+
+// RegionFileHeader describes the region tree stored in a region file
+type RegionFileHeader struct {
+	Version   int
+	BuildTime time.Time /*地区列表抓取的时间*/
+	Source    string
+	Checksum  string /*payload的sha256*/
+	Stations  int
+}
+
+// saveRegionData writes the region tree atomically, the replaced file is kept as the previous generation.
+// regionFileMu and regionMu must be held.
+func (c *Weather) saveRegionData(path string) error {
+	data, err := encodeRegionFile(c.treeRegion, c.regionBuilt)
+	if err != nil {
+		return err
+	}
+	return writeRegionFile(path, data)
+}
+
+// persistRegionData saves the region tree when it is complete, the file is written after regionMu is released.
+// regionMu must not be held.
+func (c *Weather) persistRegionData(path string) error {
+	c.regionFileMu.Lock()
+	defer c.regionFileMu.Unlock()
+	c.regionMu.RLock()
+	if !c.regionComplete {
+		c.regionMu.RUnlock()
+		log.Println("the region tree is incomplete, not saved to", path)
+		return nil
+	}
+	data, err := encodeRegionFile(c.treeRegion, c.regionBuilt)
+	c.regionMu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return writeRegionFile(path, data)
+}
+
+// encodeRegionFile encodes tree as the content of a region file, built is the time of its crawl
+func encodeRegionFile(tree *TreeRegionInfo, built time.Time) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(tree); err != nil {
+		log.Println("Cannot encode the region tree", err)
+		return nil, err
+	}
+	if built.IsZero() {
+		built = time.Now()
+	}
+	sum := sha256.Sum256(payload.Bytes())
+	header := RegionFileHeader{
+		Version:   REGION_FILE_VERSION,
+		BuildTime: built,
+		Source:    REGION_SITE,
+		Checksum:  hex.EncodeToString(sum[:]),
+		Stations:  len(stations(tree)),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(REGION_FILE_MAGIC)
+	encoder := gob.NewEncoder(&buf)
+	if err := encoder.Encode(header); err != nil {
+		return nil, err
+	}
+	if err := encoder.Encode(payload.Bytes()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeRegionFile writes data to path atomically, the replaced file is kept as the previous generation
+func writeRegionFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		log.Println("Cannot create", path, err)
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Chmod(0644) /*CreateTemp创建的文件是0600*/
+	_, err = tmp.Write(data)
+	if nil == err {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); nil == err {
+		err = closeErr
+	}
+	if err != nil {
+		log.Println("Cannot save to", path, err)
+		return err
+	}
+
+	if err := keepPrevious(path); err != nil {
+		log.Println("Cannot keep the previous generation of", path, err)
+	}
+	/*一次rename替换，任何时刻path都是完整的文件*/
+	return os.Rename(tmp.Name(), path)
+}
+
+// keepPrevious hard-links path as its previous generation, or copies it where links are not supported
+func keepPrevious(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	prev := path + REGION_FILE_PREV
+	if err := os.Remove(prev); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if nil == os.Link(path, prev) {
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(prev), filepath.Base(prev)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); nil == err {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), prev)
+}
+
+// loadRegionData loads the region tree from path, or from its previous generation when path is corrupt.
+// A corrupt file is moved aside, a file of an older schema is migrated and saved again.
+// regionFileMu and regionMu must be held.
+func (c *Weather) loadRegionData(path string) error {
+	tree, header, err := readRegionFile(path)
+	if err != nil {
+		log.Println("Load region data from", path, "failed", err)
+		if !os.IsNotExist(err) {
+			if err := os.Rename(path, path+REGION_FILE_CORRUPT); err != nil {
+				log.Println(err)
+			}
+		}
+		var prevErr error
+		if tree, header, prevErr = readRegionFile(path + REGION_FILE_PREV); nil == prevErr {
+			log.Println("Load region data from the previous generation", path+REGION_FILE_PREV)
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	c.treeRegion = tree
+	c.regionBuilt = header.BuildTime
+	c.regionComplete = true
+	if header.Version != REGION_FILE_VERSION {
+		log.Printf("migrate region data from version %d to %d", header.Version, REGION_FILE_VERSION)
+	}
+	if _, statErr := os.Stat(path); header.Version != REGION_FILE_VERSION || nil != statErr {
+		if err := c.saveRegionData(path); err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// readRegionFile reads and verifies a region file of any known version
+func readRegionFile(path string) (*TreeRegionInfo, RegionFileHeader, error) {
+	var header RegionFileHeader
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, header, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	magic, err := reader.Peek(len(REGION_FILE_MAGIC))
+	if err != nil || REGION_FILE_MAGIC != string(magic) {
+		/*version 0: 没有文件头的gob*/
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, header, err
+		}
+		tree, err := migrateRegionData(0, file)
+		if err != nil {
+			return nil, header, err
+		}
+		header.Version = 0
+		header.Source = REGION_SITE
+		if info, err := file.Stat(); nil == err {
+			header.BuildTime = info.ModTime()
+		}
+		return tree, header, nil
+	}
+	reader.Discard(len(REGION_FILE_MAGIC))
+
+	var payload []byte
+	decoder := gob.NewDecoder(reader)
+	if err := decoder.Decode(&header); err != nil {
+		return nil, header, fmt.Errorf("%w: bad header: %v", ErrCorruptRegionData, err)
+	}
+	if header.Version > REGION_FILE_VERSION {
+		return nil, header, fmt.Errorf("%w: unknown version %d", ErrCorruptRegionData, header.Version)
+	}
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, header, fmt.Errorf("%w: bad payload: %v", ErrCorruptRegionData, err)
+	}
+	if sum := sha256.Sum256(payload); hex.EncodeToString(sum[:]) != header.Checksum {
+		return nil, header, fmt.Errorf("%w: checksum mismatch", ErrCorruptRegionData)
+	}
+	tree, err := migrateRegionData(header.Version, bytes.NewReader(payload))
+	return tree, header, err
+}
+
+// migrateRegionData decodes the payload of a schema version into the current TreeRegionInfo
+func migrateRegionData(version int, payload io.Reader) (*TreeRegionInfo, error) {
+	tree := &TreeRegionInfo{Regions: make(map[string]*TreeRegionInfo)}
+	switch version {
+	case 0, 1:
+		/*version 1只增加了字段，gob可以直接解码*/
+		if err := gob.NewDecoder(payload).Decode(tree); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptRegionData, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown version %d", ErrCorruptRegionData, version)
+	}
+	if 0 == len(tree.Regions) {
+		return nil, fmt.Errorf("%w: no province", ErrCorruptRegionData)
+	}
+	return tree, nil
+}
@@ -0,0 +1,135 @@
+package weather
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*上海,上海,(上海|浦东|闵行)*/
+func testRegionTree() *TreeRegionInfo {
+	county := func(code, name, spell string) *TreeRegionInfo {
+		return &TreeRegionInfo{RegionInfo: RegionInfo{
+			Url_:      "/weather1d/" + code + ".shtml",
+			Code_:     code,
+			Name_:     name,
+			FullName_: "上海,上海," + name,
+			Spell_:    "shanghai,shanghai," + spell,
+		}}
+	}
+	city := &TreeRegionInfo{
+		RegionInfo: RegionInfo{Name_: "上海", FullName_: "上海,上海", Spell_: "shanghai"},
+		Regions: map[string]*TreeRegionInfo{
+			"shanghai": county("101020100", "上海", "shanghai"),
+			"pudong":   county("101021300", "浦东", "pudong"),
+			"minxing":  county("101020200", "闵行", "minxing"),
+		},
+	}
+	province := &TreeRegionInfo{
+		RegionInfo: RegionInfo{Code_: "101020100", Name_: "上海", Url_: "/textFC/shanghai.shtml", Spell_: "shanghai"},
+		Regions:    map[string]*TreeRegionInfo{"shanghai": city},
+	}
+	return &TreeRegionInfo{Regions: map[string]*TreeRegionInfo{"shanghai": province}}
+}
+
+func TestRegionFile(t *testing.T) {
+	/*文件头之前的格式：整个树的gob*/
+	var v0 bytes.Buffer
+	if err := gob.NewEncoder(&v0).Encode(testRegionTree()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		mutate  func(t *testing.T, path string) /*修改保存好的文件*/
+		prev    bool                            /*是否保留上一代文件*/
+		err     error                           /*期望的错误，nil表示加载成功*/
+		corrupt bool                            /*文件是否被移到.corrupt*/
+	}{
+		{name: "intact"},
+		{name: "flipped byte", mutate: flipLastByte, err: ErrCorruptRegionData, corrupt: true},
+		{name: "flipped byte with prev", mutate: flipLastByte, prev: true, corrupt: true},
+		{name: "truncated", mutate: truncate, err: ErrCorruptRegionData, corrupt: true},
+		{name: "truncated with prev", mutate: truncate, prev: true, corrupt: true},
+		{name: "version 0", mutate: func(t *testing.T, path string) {
+			if err := ioutil.WriteFile(path, v0.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "missing", mutate: remove, err: os.ErrNotExist},
+		{name: "missing with prev", mutate: remove, prev: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), REGION_CACHE_FILE)
+			c := New(0, newFixtureWeatherCom())
+			defer c.Close()
+			c.treeRegion = testRegionTree()
+			if err := c.saveRegionData(path); err != nil {
+				t.Fatal(err)
+			}
+			if tc.prev {
+				if err := c.saveRegionData(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := os.Stat(path + REGION_FILE_PREV); tc.prev != (nil == err) {
+				t.Fatalf("previous generation exists: %v, want %v", nil == err, tc.prev)
+			}
+			if nil != tc.mutate {
+				tc.mutate(t, path)
+			}
+
+			r := New(0, newFixtureWeatherCom())
+			defer r.Close()
+			err := r.loadRegionData(path)
+			if nil == tc.err && nil != err || nil != tc.err && !errors.Is(err, tc.err) {
+				t.Fatalf("loadRegionData() = %v, want %v", err, tc.err)
+			}
+			if _, statErr := os.Stat(path + REGION_FILE_CORRUPT); tc.corrupt != (nil == statErr) {
+				t.Errorf("corrupt file kept aside: %v, want %v", nil == statErr, tc.corrupt)
+			}
+			if nil != err {
+				return
+			}
+			if county := r.treeRegion.Regions["shanghai"].Regions["shanghai"].Regions["minxing"]; nil == county || "101020200" != county.Code_ {
+				t.Errorf("loaded tree %+v", r.treeRegion)
+			}
+			/*迁移或从上一代恢复后，path重新保存为当前版本*/
+			tree, header, err := readRegionFile(path)
+			if err != nil || REGION_FILE_VERSION != header.Version || 3 != header.Stations || nil == tree {
+				t.Errorf("%s after loading: %+v, %v", path, header, err)
+			}
+		})
+	}
+}
+
+func flipLastByte(t *testing.T, path string) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[len(buf)-1] ^= 0xff
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func truncate(t *testing.T, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func remove(t *testing.T, path string) {
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+}
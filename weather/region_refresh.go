@@ -0,0 +1,239 @@
+package weather
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RegionChange is a region whose name or code changed between two crawls
+type RegionChange struct {
+	Old RegionInfo `json:"old"`
+	New RegionInfo `json:"new"`
+}
+
+// RegionDiff lists the differences of the county-level stations between two region trees
+type RegionDiff struct {
+	Added       []RegionInfo   `json:"added"`
+	Removed     []RegionInfo   `json:"removed"`
+	Renamed     []RegionChange `json:"renamed"`     /*code不变，名称变化*/
+	CodeChanged []RegionChange `json:"codechanged"` /*名称不变，code变化*/
+}
+
+func (d *RegionDiff) Empty() bool {
+	return 0 == len(d.Added)+len(d.Removed)+len(d.Renamed)+len(d.CodeChanged)
+}
+
+// RegionRefreshReport is the state of the last refresh of the region tree
+type RegionRefreshReport struct {
+	Running  bool        `json:"running"`
+	Started  time.Time   `json:"started"`
+	Finished time.Time   `json:"finished"`
+	Error    string      `json:"error,omitempty"`
+	ECode    string      `json:"ecode,omitempty"` /*失败时错误的ecode*/
+	Diff     *RegionDiff `json:"diff,omitempty"`
+}
+
+type regionRefresher struct {
+	mu     sync.Mutex
+	report RegionRefreshReport
+}
+
+// RefreshRegionTree crawls the region hierarchy again and swaps it in when the crawl succeeds.
+// Lookups keep using the loaded tree during the crawl, only the swap takes regionMu.
+// ErrRefreshRunning is returned when another refresh has not finished.
+func (c *Weather) RefreshRegionTree() (*RegionDiff, error) {
+	return c.RefreshRegionTreeContext(context.Background())
+}
+
+// RefreshRegionTreeContext is RefreshRegionTree, the crawl stops once ctx is done and the tree is kept
+func (c *Weather) RefreshRegionTreeContext(ctx context.Context) (*RegionDiff, error) {
+	if !c.beginRefresh() {
+		return nil, ErrRefreshRunning
+	}
+	return c.finishRefresh(c.refreshRegionTree(ctx))
+}
+
+// RefreshRegionTreeAsync starts a refresh in the background and returns at once,
+// ErrRefreshRunning is returned when another refresh has not finished. The result is in RegionRefreshReport.
+func (c *Weather) RefreshRegionTreeAsync() error {
+	if !c.beginRefresh() {
+		return ErrRefreshRunning
+	}
+	go func() {
+		if _, err := c.finishRefresh(c.refreshRegionTree(context.Background())); err != nil {
+			log.Println("region refresh failed", err)
+		}
+	}()
+	return nil
+}
+
+/*占用刷新，已有刷新在进行时返回false*/
+func (c *Weather) beginRefresh() bool {
+	c.refresher.mu.Lock()
+	defer c.refresher.mu.Unlock()
+	if c.refresher.report.Running {
+		return false
+	}
+	c.refresher.report = RegionRefreshReport{Running: true, Started: time.Now()}
+	return true
+}
+
+/*记录刷新的结果并释放刷新*/
+func (c *Weather) finishRefresh(diff *RegionDiff, err error) (*RegionDiff, error) {
+	c.refresher.mu.Lock()
+	c.refresher.report.Running = false
+	c.refresher.report.Finished = time.Now()
+	c.refresher.report.Diff = diff
+	if err != nil {
+		c.refresher.report.Error = err.Error()
+		c.refresher.report.ECode = ErrorCode(err)
+	}
+	c.refresher.mu.Unlock()
+	return diff, err
+}
+
+func (c *Weather) refreshRegionTree(ctx context.Context) (*RegionDiff, error) {
+	c.regionMu.RLock()
+	crawler := c.crawler
+	c.regionMu.RUnlock()
+	tree, err := crawler.CrawlContext(ctx)
+	if err != nil {
+		log.Println("refresh region tree failed", err)
+		return nil, err
+	}
+
+	if n, err := loadStationCoordinates(STATION_COORDS_FILE, regionCodes(tree)); nil == err {
+		log.Println("load coordinates of", n, "stations from", STATION_COORDS_FILE)
+	}
+
+	c.regionFileMu.Lock()
+	defer c.regionFileMu.Unlock()
+	/*旧树的坐标由SeedCoordinates在写锁下修改，比较和复制时持有读锁*/
+	c.regionMu.RLock()
+	diff := diffRegionTree(c.treeRegion, tree)
+	copyCoordinates(c.treeRegion, tree)
+	c.regionMu.RUnlock()
+
+	/*新树还未替换，写文件时不阻塞查询*/
+	built := time.Now()
+	data, err := encodeRegionFile(tree, built)
+	if nil == err {
+		err = writeRegionFile(REGION_CACHE_FILE, data)
+	}
+	if nil != err {
+		log.Println(err)
+	}
+
+	c.regionMu.Lock()
+	c.treeRegion = tree
+	c.regionBuilt = built
+	c.regionComplete = true
+	c.indexRegions()
+	c.regionMu.Unlock()
+
+	log.Printf("region tree refreshed: %d added, %d removed, %d renamed, %d code changed",
+		len(diff.Added), len(diff.Removed), len(diff.Renamed), len(diff.CodeChanged))
+	return diff, nil
+}
+
+// RegionRefreshReport returns the state of the last refresh
+func (c *Weather) RegionRefreshReport() RegionRefreshReport {
+	c.refresher.mu.Lock()
+	defer c.refresher.mu.Unlock()
+	return c.refresher.report
+}
+
+// StartRegionRefresh refreshes the region tree every interval in the background
+func (c *Weather) StartRegionRefresh(interval time.Duration) {
+	c.StartRegionRefreshContext(context.Background(), interval)
+}
+
+// StartRegionRefreshContext is StartRegionRefresh, the refreshes stop once ctx is done
+func (c *Weather) StartRegionRefreshContext(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := c.RefreshRegionTreeContext(ctx); err != nil {
+				log.Println("scheduled region refresh failed", err)
+			}
+		}
+	}()
+}
+
+// diffRegionTree compares the stations of two trees, a station is the same one when its code or its full name is kept
+func diffRegionTree(old, cur *TreeRegionInfo) *RegionDiff {
+	oldStations, newStations := stations(old), stations(cur)
+	diff := &RegionDiff{}
+	oldByName := make(map[string]RegionInfo)
+	for code, o := range oldStations {
+		if n, ok := newStations[code]; ok {
+			if o.FullName_ != n.FullName_ {
+				diff.Renamed = append(diff.Renamed, RegionChange{Old: o, New: n})
+			}
+			continue
+		}
+		oldByName[o.FullName_] = o
+	}
+	for code, n := range newStations {
+		if _, ok := oldStations[code]; ok {
+			continue
+		}
+		if o, ok := oldByName[n.FullName_]; ok {
+			diff.CodeChanged = append(diff.CodeChanged, RegionChange{Old: o, New: n})
+			delete(oldByName, n.FullName_)
+			continue
+		}
+		diff.Added = append(diff.Added, n)
+	}
+	for _, o := range oldByName {
+		diff.Removed = append(diff.Removed, o)
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Code_ < diff.Added[j].Code_ })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Code_ < diff.Removed[j].Code_ })
+	sort.Slice(diff.Renamed, func(i, j int) bool { return diff.Renamed[i].New.Code_ < diff.Renamed[j].New.Code_ })
+	sort.Slice(diff.CodeChanged, func(i, j int) bool { return diff.CodeChanged[i].New.Code_ < diff.CodeChanged[j].New.Code_ })
+	return diff
+}
+
+// stations returns the county-level regions of a tree by their code
+func stations(tree *TreeRegionInfo) map[string]RegionInfo {
+	result := make(map[string]RegionInfo)
+	var walk func(region *TreeRegionInfo)
+	walk = func(region *TreeRegionInfo) {
+		if 0 == len(region.Regions) && "" != region.Code_ {
+			result[region.Code_] = region.RegionInfo
+		}
+		for _, sub := range region.Regions {
+			walk(sub)
+		}
+	}
+	if nil != tree {
+		walk(tree)
+	}
+	return result
+}
+
+// copyCoordinates keeps the known coordinates of the stations in a freshly crawled tree
+func copyCoordinates(old, cur *TreeRegionInfo) {
+	oldStations := stations(old)
+	var walk func(region *TreeRegionInfo)
+	walk = func(region *TreeRegionInfo) {
+		if o, ok := oldStations[region.Code_]; ok && 0 == len(region.Regions) && !region.hasCoordinates() {
+			region.Latitude_, region.Longitude_ = o.Latitude_, o.Longitude_
+		}
+		for _, sub := range region.Regions {
+			walk(sub)
+		}
+	}
+	walk(cur)
+}
@@ -0,0 +1,70 @@
+package weather
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRefreshRegionTree(t *testing.T) {
+	inTempDir(t)
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	c.treeRegion = testRegionTree()
+	c.regionComplete = true
+	c.indexRegions()
+	c.codeIndex["101020200"].Latitude_, c.codeIndex["101020200"].Longitude_ = 31.11, 121.38
+	c.SetCrawler(newStubCrawler(regionPages(stubProvince{Spell: "shanghai", Name: "上海", Cities: []stubCity{
+		{Name: "上海", Counties: [][2]string{{"101020100", "上海"}, {"101020200", "闵行"}, {"101021300", "浦东新区"}, {"101020300", "宝山"}}},
+	}})))
+
+	/*-race: 刷新时查询及写入坐标*/
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			c.RegionByCode("101020200")
+			c.FindNearest(31.2, 121.4)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			c.SeedCoordinates(map[string][]Location{"101020300": {{Longitude: "121.49", Latitude: "31.41"}}})
+		}
+	}()
+	diff, err := c.RefreshRegionTree()
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if 1 != len(diff.Added) || "101020300" != diff.Added[0].Code_ || 1 != len(diff.Renamed) || "浦东新区" != diff.Renamed[0].New.Name_ {
+		t.Errorf("diff %+v", diff)
+	}
+	if region, err := c.RegionByCode("101020200"); err != nil || 31.11 != region.Latitude_ {
+		t.Errorf("闵行 %+v, %v; want its coordinates kept", region, err)
+	}
+	if report := c.RegionRefreshReport(); report.Running || "" != report.Error {
+		t.Errorf("report %+v", report)
+	}
+	tree, header, err := readRegionFile(REGION_CACHE_FILE)
+	if err != nil || 4 != header.Stations {
+		t.Fatalf("saved %+v, %v", header, err)
+	}
+	if county := regionCodes(tree)["101020200"]; nil == county || 31.11 != county.Latitude_ {
+		t.Errorf("saved 闵行 %+v", county)
+	}
+}
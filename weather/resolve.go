@@ -0,0 +1,123 @@
+package weather
+
+import (
+	"sort"
+	"strings"
+)
+
+// ResolveRegion walks the region tree by the names in path, such as ("四川", "成都", "都江堰"),
+// a level may be omitted: "上海,松江" or "松江" alone resolve to the same county.
+// When the path ends above the county level the seat is picked, that is the provincial capital or the city seat.
+// An *AmbiguousRegionError listing the candidates is returned when a name matches more than one region.
+func (c *Weather) ResolveRegion(path ...string) (RegionInfo, error) {
+	c.regionMu.RLock()
+	defer c.regionMu.RUnlock()
+	node, err := c.resolveNode(path)
+	if err != nil {
+		return RegionInfo{}, err
+	}
+	for len(node.Regions) > 0 {
+		seat := node.seat()
+		if nil == seat {
+			return RegionInfo{}, ErrCityNotFound
+		}
+		node = seat
+	}
+	if "" == node.Code_ || "" == node.Url_ {
+		return RegionInfo{}, ErrCityNotFound
+	}
+	return node.RegionInfo, nil
+}
+
+// resolveNode returns the region named by path without picking a seat, regionMu must be held
+func (c *Weather) resolveNode(path []string) (*TreeRegionInfo, error) {
+	var names []string
+	for _, name := range path {
+		if name = strings.TrimSpace(name); "" != name {
+			names = append(names, name)
+		}
+	}
+	if 0 == len(names) {
+		return nil, ErrBadParameter
+	}
+	node := c.treeRegion
+	for _, name := range names {
+		if sub, ok := node.child(name); ok {
+			node = sub
+			continue
+		}
+		matches := node.descendants(name)
+		switch len(matches) {
+		case 0:
+			return nil, ErrCityNotFound
+		case 1:
+			node = matches[0]
+		default:
+			return nil, newAmbiguousRegionError(name, matches)
+		}
+	}
+	return node, nil
+}
+
+// descendants returns the regions below r named name, a chinese name is compared as is before its readings.
+// A match hides its own sub regions so a city does not compete with its namesake county.
+// The name is spelled once, every node is looked up in the indexes built with the tree.
+func (r *TreeRegionInfo) descendants(name string) []*TreeRegionInfo {
+	if hasHan(name) {
+		if matches := r.collect(func(parent, sub *TreeRegionInfo) bool { return sub.Name_ == name }); len(matches) > 0 {
+			return matches
+		}
+	}
+	spell := Spell(name)
+	return r.collect(func(parent, sub *TreeRegionInfo) bool {
+		found, _ := parent.childBySpell(name, spell)
+		return found == sub
+	})
+}
+
+func (r *TreeRegionInfo) collect(match func(parent, sub *TreeRegionInfo) bool) []*TreeRegionInfo {
+	var matches []*TreeRegionInfo
+	for _, sub := range r.Regions {
+		if match(r, sub) {
+			matches = append(matches, sub)
+			continue
+		}
+		matches = append(matches, sub.collect(match)...)
+	}
+	return matches
+}
+
+// seat returns the sub region standing for r: the namesake one or the one holding the smallest station code
+func (r *TreeRegionInfo) seat() *TreeRegionInfo {
+	if sub, ok := r.Regions[r.Spell_]; ok {
+		return sub
+	}
+	spells := strings.Split(r.Spell_, STR_SEP)
+	if sub, ok := r.child(spells[len(spells)-1]); ok {
+		return sub
+	}
+	var seat *TreeRegionInfo
+	var seatCode string
+	for _, sub := range r.Regions {
+		if code := defaultCode(sub); "" != code && ("" == seatCode || code < seatCode) {
+			seat, seatCode = sub, code
+		}
+	}
+	return seat
+}
+
+func newAmbiguousRegionError(name string, matches []*TreeRegionInfo) *AmbiguousRegionError {
+	candidates := make([]RegionInfo, 0, len(matches))
+	for _, v := range matches {
+		info := v.RegionInfo
+		info.Code_ = defaultCode(v)
+		if "" == info.FullName_ {
+			info.FullName_ = info.Name_
+		}
+		candidates = append(candidates, info)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].FullName_ < candidates[j].FullName_
+	})
+	return &AmbiguousRegionError{Name: name, Candidates: candidates}
+}
@@ -0,0 +1,91 @@
+package weather
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+/*四川、北京、辽宁的部分地区，北京和辽宁都有朝阳*/
+func testResolveTree() *TreeRegionInfo {
+	name := func(fullname string) string {
+		names := strings.Split(fullname, STR_SEP)
+		return names[len(names)-1]
+	}
+	county := func(code, fullname string) *TreeRegionInfo {
+		return &TreeRegionInfo{RegionInfo: RegionInfo{
+			Url_: "/weather1d/" + code + ".shtml", Code_: code, Name_: name(fullname), FullName_: fullname,
+		}}
+	}
+	region := func(fullname, spell string, subs map[string]*TreeRegionInfo) *TreeRegionInfo {
+		return &TreeRegionInfo{RegionInfo: RegionInfo{Name_: name(fullname), FullName_: fullname, Spell_: spell}, Regions: subs}
+	}
+	return &TreeRegionInfo{Regions: map[string]*TreeRegionInfo{
+		"sichuan": region("四川", "sichuan", map[string]*TreeRegionInfo{
+			"chengdu": region("四川,成都", "chengdu", map[string]*TreeRegionInfo{
+				"chengdu":    county("101270101", "四川,成都,成都"),
+				"dujiangyan": county("101270111", "四川,成都,都江堰"),
+			}),
+			"mianyang": region("四川,绵阳", "mianyang", map[string]*TreeRegionInfo{
+				"mianyang": county("101270401", "四川,绵阳,绵阳"),
+				"jiangyou": county("101270402", "四川,绵阳,江油"),
+			}),
+		}),
+		"beijing": region("北京", "beijing", map[string]*TreeRegionInfo{
+			"beijing": region("北京,北京", "beijing", map[string]*TreeRegionInfo{
+				"beijing":  county("101010100", "北京,北京,北京"),
+				"chaoyang": county("101010300", "北京,北京,朝阳"),
+			}),
+		}),
+		"liaoning": region("辽宁", "liaoning", map[string]*TreeRegionInfo{
+			"chaoyang": region("辽宁,朝阳", "chaoyang", map[string]*TreeRegionInfo{
+				"chaoyang": county("101071201", "辽宁,朝阳,朝阳"),
+				"lingyuan": county("101071204", "辽宁,朝阳,凌源"),
+			}),
+		}),
+	}}
+}
+
+func TestResolveRegion(t *testing.T) {
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	c.treeRegion = testResolveTree()
+	c.indexRegions()
+
+	for _, tc := range []struct {
+		path []string
+		code string
+		err  error
+	}{
+		{[]string{"四川", "成都", "都江堰"}, "101270111", nil},
+		{[]string{"四川", "都江堰"}, "101270111", nil}, /*省略城市*/
+		{[]string{"都江堰"}, "101270111", nil},
+		{[]string{"dujiangyan"}, "101270111", nil},
+		{[]string{" Si Chuan ", "Jiang You"}, "101270402", nil},
+		{[]string{"四川"}, "101270101", nil}, /*省会*/
+		{[]string{"绵阳"}, "101270401", nil}, /*城市所在的区县*/
+		{[]string{"北京", "朝阳"}, "101010300", nil},
+		{[]string{"辽宁", "朝阳"}, "101071201", nil},
+		{[]string{"凌源"}, "101071204", nil},
+		{[]string{"拉萨"}, "", ErrCityNotFound},
+		{[]string{"四川", "凌源"}, "", ErrCityNotFound},
+		{[]string{"", " "}, "", ErrBadParameter},
+	} {
+		region, err := c.ResolveRegion(tc.path...)
+		if !errors.Is(err, tc.err) || tc.code != region.Code_ {
+			t.Errorf("ResolveRegion(%q) = %s, %v; want %s, %v", tc.path, region.Code_, err, tc.code, tc.err)
+		}
+	}
+
+	for _, name := range []string{"朝阳", "chaoyang"} {
+		_, err := c.ResolveRegion(name)
+		var ambiguous *AmbiguousRegionError
+		if !errors.As(err, &ambiguous) || 2 != len(ambiguous.Candidates) {
+			t.Fatalf("ResolveRegion(%s) = %v, want two candidates", name, err)
+		}
+		/*按全名排序，城市用其所在区县的代码*/
+		if got := ambiguous.Candidates; "101010300" != got[0].Code_ || "101071201" != got[1].Code_ || "辽宁,朝阳" != got[1].FullName_ {
+			t.Errorf("ResolveRegion(%s) candidates %+v", name, got)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package weather
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	SEARCH_DEFAULT_LIMIT = 10
+	SEARCH_MAX_LIMIT     = 50
+)
+
+// Scores of the ways a query can match a region, the higher the better
+const (
+	SCORE_NAME_EXACT     = 100
+	SCORE_SPELL_EXACT    = 90
+	SCORE_NAME_PREFIX    = 80
+	SCORE_INITIAL_EXACT  = 75
+	SCORE_SPELL_PREFIX   = 70
+	SCORE_NAME_CONTAINS  = 60
+	SCORE_INITIAL_PREFIX = 55
+	SCORE_FUZZY          = 50 /*每差一个字符减10*/
+)
+
+// RegionMatch is a candidate returned by SearchRegions
+type RegionMatch struct {
+	RegionInfo
+	Score int `json:"score"`
+}
+
+type searchEntry struct {
+	region   *TreeRegionInfo
+	code     string   /*市级没有code，取同名或最小的区县code*/
+	spells   []string /*不带上级的拼音，包括多音字的其他读音*/
+	initials []string
+}
+
+// indexRegionSearch rebuilds the search index of every region below the root, regionMu must be held
+func (c *Weather) indexRegionSearch() {
+	c.searchIndex = c.searchIndex[:0]
+	var walk func(region *TreeRegionInfo)
+	walk = func(region *TreeRegionInfo) {
+		for _, sub := range region.Regions {
+			c.searchIndex = append(c.searchIndex, newSearchEntry(sub))
+			walk(sub)
+		}
+	}
+	walk(c.treeRegion)
+}
+
+func newSearchEntry(region *TreeRegionInfo) searchEntry {
+	keys := strings.Split(region.Spell_, STR_SEP)
+	spells := Spells(region.Name_)
+	if key := keys[len(keys)-1]; !containsString(spells, key) {
+		spells = append(spells, key)
+	}
+	var initials []string
+	for _, syllables := range readings(region.Name_) {
+		var initial string
+		for _, v := range syllables {
+			initial += v[:1]
+		}
+		if "" != initial && !containsString(initials, initial) {
+			initials = append(initials, initial)
+		}
+	}
+	return searchEntry{region: region, code: defaultCode(region), spells: spells, initials: initials}
+}
+
+// defaultCode returns the station code standing for a region:
+// its own code, the code of the namesake child or the smallest code below it
+func defaultCode(region *TreeRegionInfo) string {
+	if "" != region.Code_ {
+		return region.Code_
+	}
+	if child, ok := region.Regions[region.Spell_]; ok && "" != child.Code_ {
+		return child.Code_
+	}
+	var code string
+	for _, child := range region.Regions {
+		if sub := defaultCode(child); "" != sub && ("" == code || sub < code) {
+			code = sub
+		}
+	}
+	return code
+}
+
+// SearchRegions returns the regions matching query ranked by score,
+// the query may be a chinese name, a full pinyin, pinyin initials or a misspelled pinyin
+func (c *Weather) SearchRegions(query string, limit int) ([]RegionMatch, error) {
+	query = strings.ToLower(strings.Join(strings.Fields(query), ""))
+	if "" == query {
+		return nil, ErrBadParameter
+	}
+	if limit <= 0 {
+		limit = SEARCH_DEFAULT_LIMIT
+	}
+	if limit > SEARCH_MAX_LIMIT {
+		limit = SEARCH_MAX_LIMIT
+	}
+
+	c.regionMu.RLock()
+	var matches []RegionMatch
+	for _, entry := range c.searchIndex {
+		if score := entry.score(query); score > 0 {
+			info := entry.region.RegionInfo
+			info.Code_ = entry.code
+			if "" == info.FullName_ { /*省级没有全称*/
+				info.FullName_ = info.Name_
+			}
+			matches = append(matches, RegionMatch{RegionInfo: info, Score: score})
+		}
+	}
+	c.regionMu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		if li, lj := strings.Count(matches[i].FullName_, STR_SEP), strings.Count(matches[j].FullName_, STR_SEP); li != lj {
+			return li < lj /*同分时省、市优先*/
+		}
+		return matches[i].FullName_ < matches[j].FullName_
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+func (e *searchEntry) score(query string) int {
+	if hasHan(query) {
+		name := e.region.Name_
+		switch {
+		case name == query:
+			return SCORE_NAME_EXACT
+		case strings.HasPrefix(name, query):
+			return SCORE_NAME_PREFIX
+		case strings.Contains(name, query):
+			return SCORE_NAME_CONTAINS
+		}
+		return 0
+	}
+	query = normalizeSpell(query)
+	var best int
+	for _, spell := range e.spells {
+		switch {
+		case spell == query:
+			return SCORE_SPELL_EXACT
+		case strings.HasPrefix(spell, query) && len(query) >= 2:
+			best = maxScore(best, SCORE_SPELL_PREFIX)
+		}
+	}
+	for _, initial := range e.initials {
+		switch {
+		case initial == query:
+			best = maxScore(best, SCORE_INITIAL_EXACT)
+		case strings.HasPrefix(initial, query) && len(query) >= 2:
+			best = maxScore(best, SCORE_INITIAL_PREFIX)
+		}
+	}
+	if best > 0 || len(query) < 3 {
+		return best
+	}
+	maxDist := 1
+	if len(query) >= 6 {
+		maxDist = 2
+	}
+	for _, spell := range e.spells {
+		if dist := levenshtein(spell, query); dist <= maxDist {
+			best = maxScore(best, SCORE_FUZZY-10*dist)
+		}
+	}
+	return best
+}
+
+func maxScore(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func hasHan(s string) bool {
+	for _, r := range s {
+		if unicode.Is(unicode.Han, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// levenshtein returns the edit distance of a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,84 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSearchRegions(t *testing.T) {
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	c.treeRegion = testResolveTree()
+	/*第四级：台湾->台北->桃园->中坜*/
+	c.treeRegion.Regions["taiwan"] = &TreeRegionInfo{RegionInfo: RegionInfo{Name_: "台湾", Spell_: "taiwan"}, Regions: map[string]*TreeRegionInfo{
+		"taibei": {RegionInfo: RegionInfo{Name_: "台北", FullName_: "台湾,台北", Spell_: "taibei"}, Regions: map[string]*TreeRegionInfo{
+			"taoyuan": {RegionInfo: RegionInfo{Name_: "桃园", FullName_: "台湾,台北,桃园", Spell_: "taoyuan"}, Regions: map[string]*TreeRegionInfo{
+				"zhongli": {RegionInfo: RegionInfo{Code_: "101340102", Url_: "/weather1d/101340102.shtml", Name_: "中坜", FullName_: "台湾,台北,桃园,中坜", Spell_: "zhongli"}},
+			}},
+		}},
+	}}
+	c.indexRegions()
+
+	for _, tc := range []struct {
+		query string
+		want  []string /*全称，按名次*/
+		score int      /*第一名的分数*/
+	}{
+		{"成都", []string{"四川,成都", "四川,成都,成都"}, SCORE_NAME_EXACT}, /*同分时市优先*/
+		{"Cheng Du", []string{"四川,成都", "四川,成都,成都"}, SCORE_SPELL_EXACT},
+		{"cd", []string{"四川,成都", "四川,成都,成都"}, SCORE_INITIAL_EXACT},
+		{"dj", []string{"四川,成都,都江堰"}, SCORE_INITIAL_PREFIX}, /*都江堰 djy*/
+		{"mianyng", []string{"四川,绵阳", "四川,绵阳,绵阳"}, SCORE_FUZZY - 10},
+		{"dujaingyan", []string{"四川,成都,都江堰"}, SCORE_FUZZY - 20},
+		{"江", []string{"四川,绵阳,江油", "四川,成都,都江堰"}, SCORE_NAME_PREFIX}, /*前缀优先于包含*/
+		{"lingy", []string{"辽宁,朝阳,凌源"}, SCORE_SPELL_PREFIX},
+		{"zhongli", []string{"台湾,台北,桃园,中坜"}, SCORE_SPELL_EXACT},
+		{"中坜", []string{"台湾,台北,桃园,中坜"}, SCORE_NAME_EXACT},
+		{"xyz", nil, 0},
+	} {
+		matches, err := c.SearchRegions(tc.query, 0)
+		if err != nil {
+			t.Errorf("SearchRegions(%q) = %v", tc.query, err)
+			continue
+		}
+		var got []string
+		for _, v := range matches {
+			got = append(got, v.FullName_)
+		}
+		if fmt.Sprint(tc.want) != fmt.Sprint(got) || (len(matches) > 0 && tc.score != matches[0].Score) {
+			t.Errorf("SearchRegions(%q) = %v %+v; want %v scored %d", tc.query, got, matches, tc.want, tc.score)
+		}
+	}
+	if matches, _ := c.SearchRegions("成都", 0); "101270101" != matches[0].Code_ {
+		t.Errorf("成都 city code %q, want the code of its seat", matches[0].Code_)
+	}
+	if matches, _ := c.SearchRegions("sichuan", 0); 1 != len(matches) || "四川" != matches[0].FullName_ || "101270101" != matches[0].Code_ {
+		t.Errorf("SearchRegions(sichuan) = %+v", matches)
+	}
+	if _, err := c.SearchRegions("  ", 0); !errors.Is(err, ErrBadParameter) {
+		t.Errorf("SearchRegions() of a blank query = %v", err)
+	}
+}
+
+func TestSearchRegionsLimit(t *testing.T) {
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	counties := make(map[string]*TreeRegionInfo)
+	for i := 0; i < SEARCH_MAX_LIMIT+10; i++ {
+		code := fmt.Sprintf("1012701%02d", i)
+		counties[code] = &TreeRegionInfo{RegionInfo: RegionInfo{Code_: code, Name_: fmt.Sprintf("新都%d", i), FullName_: fmt.Sprintf("四川,成都,新都%d", i)}}
+	}
+	c.treeRegion = &TreeRegionInfo{Regions: map[string]*TreeRegionInfo{
+		"sichuan": {RegionInfo: RegionInfo{Name_: "四川", Spell_: "sichuan"}, Regions: map[string]*TreeRegionInfo{
+			"chengdu": {RegionInfo: RegionInfo{Name_: "成都", FullName_: "四川,成都", Spell_: "chengdu"}, Regions: counties},
+		}},
+	}}
+	c.indexRegions()
+
+	for limit, want := range map[int]int{0: SEARCH_DEFAULT_LIMIT, -1: SEARCH_DEFAULT_LIMIT, 3: 3, 100: SEARCH_MAX_LIMIT} {
+		if matches, err := c.SearchRegions("新都", limit); err != nil || want != len(matches) {
+			t.Errorf("SearchRegions(新都, %d) = %d matches, %v; want %d", limit, len(matches), err, want)
+		}
+	}
+}
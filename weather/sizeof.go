@@ -0,0 +1,57 @@
+package weather
+
+import (
+	"reflect"
+)
+
+// sizeOf estimates the memory held by v: the value itself and everything it references.
+// Shared backing arrays are counted once per reference, which is good enough for a cache budget.
+func sizeOf(v interface{}) int64 {
+	if nil == v {
+		return 0
+	}
+	rv := reflect.ValueOf(v)
+	return int64(rv.Type().Size()) + referencedSize(rv)
+}
+
+// referencedSize is the memory referenced by v but not stored inline
+func referencedSize(v reflect.Value) int64 {
+	var n int64
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return 0
+		}
+		return int64(v.Type().Elem().Size()) + referencedSize(v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return int64(v.Elem().Type().Size()) + referencedSize(v.Elem())
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() {
+			return 0
+		}
+		n = int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			n += referencedSize(v.Index(i))
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			n += referencedSize(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			n += referencedSize(v.Field(i))
+		}
+	case reflect.Map:
+		entry := int64(v.Type().Key().Size() + v.Type().Elem().Size())
+		iter := v.MapRange()
+		for iter.Next() {
+			n += entry + referencedSize(iter.Key()) + referencedSize(iter.Value())
+		}
+	}
+	return n
+}
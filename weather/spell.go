@@ -0,0 +1,203 @@
+package weather
+
+import (
+	"bufio"
+	"github.com/mozillazg/go-pinyin"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	SPELL_OVERRIDE_FILE = "./spell_overrides.txt" /*可选，每行: 地名=拼音*/
+	SPELL_MAX_READINGS  = 16                      /*多音字组合的上限*/
+)
+
+var (
+	spellMu sync.RWMutex
+	/*地名中的多音字，go-pinyin默认读音不对的*/
+	spellOverrides = map[string]string{
+		"陕西": "shaanxi", "重庆": "chongqing", "成都": "chengdu", "都江堰": "dujiangyan", "郫都": "pidu", "丰都": "fengdu",
+		"长沙": "changsha", "长春": "changchun", "长治": "changzhi", "长汀": "changting", "长葛": "changge",
+		"长海": "changhai", "长乐": "changle", "长兴": "changxing", "长寿": "changshou", "长垣": "changyuan",
+		"长白": "changbai", "长岭": "changling", "长丰": "changfeng", "长武": "changwu", "长阳": "changyang",
+		"长泰": "changtai", "长宁": "changning", "长清": "changqing", "长安": "changan", "长顺": "changshun",
+		"厦门": "xiamen", "蚌埠": "bengbu", "六安": "luan", "六合": "luhe", "乐清": "yueqing", "乐亭": "laoting",
+		"单县": "shanxian", "番禺": "panyu", "西藏": "xizang", "涡阳": "guoyang", "铅山": "yanshan",
+		"洪洞": "hongtong", "尉犁": "yuli", "蔚县": "yuxian", "牟平": "muping", "黄陂": "huangpi",
+		"枞阳": "zongyang", "筠连": "junlian", "大埔": "dabu", "东阿": "donge", "浚县": "xunxian",
+		"睢县": "suixian", "莎车": "shache", "柏乡": "baixiang", "什邡": "shifang", "犍为": "qianwei",
+		"荥经": "yingjing", "綦江": "qijiang", "涪陵": "fuling", "阆中": "langzhong", "邛崃": "qionglai",
+		"调兵山": "diaobingshan", "大足": "dazu", "会同": "huitong", "歙县": "shexian", "覃塘": "tantang",
+		"闵行": "minhang", "曾都": "zengdu", "都匀": "duyun", "都安": "duan", "都昌": "duchang", "都兰": "dulan",
+		"宁都": "ningdu", "于都": "yudu", "江都": "jiangdu", "花都": "huadu", "新都": "xindu", "武都": "wudu",
+	}
+	heteronymArgs = func() pinyin.Args {
+		a := pinyin.NewArgs()
+		a.Heteronym = true
+		return a
+	}()
+)
+
+// RegisterSpell adds or replaces the reading of a place name, such as RegisterSpell("重庆", "chongqing")
+func RegisterSpell(name, spell string) {
+	spellMu.Lock()
+	defer spellMu.Unlock()
+	spellOverrides[strings.TrimSpace(name)] = normalizeSpell(spell)
+}
+
+// LoadSpellOverrides registers the readings of a "name=spell" per line file
+func LoadSpellOverrides(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var loaded int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) < 2 || "" == strings.TrimSpace(fields[0]) || "" == strings.TrimSpace(fields[1]) {
+			continue
+		}
+		RegisterSpell(fields[0], fields[1])
+		loaded++
+	}
+	return loaded, scanner.Err()
+}
+
+// Spell returns the normalized pinyin of a place name,
+// a name without chinese characters is only lower-cased
+func Spell(name string) string {
+	name = strings.TrimSpace(name)
+	if !hasHan(name) {
+		return normalizeSpell(name)
+	}
+	spellMu.RLock()
+	spell, ok := spellOverrides[name]
+	spellMu.RUnlock()
+	if ok {
+		return spell
+	}
+	for _, v := range pinyin.LazyConvert(name, nil) {
+		spell += v
+	}
+	if "" == spell {
+		return name
+	}
+	return spell
+}
+
+// Spells returns every plausible reading of a place name, Spell(name) comes first
+func Spells(name string) []string {
+	spells := []string{Spell(name)}
+	for _, syllables := range readings(name) {
+		if spell := strings.Join(syllables, ""); !containsString(spells, spell) {
+			spells = append(spells, spell)
+		}
+	}
+	return spells
+}
+
+// readings returns the syllables of at most SPELL_MAX_READINGS combinations of the heteronyms
+func readings(name string) [][]string {
+	var result = [][]string{{}}
+	for _, char := range pinyin.Pinyin(strings.TrimSpace(name), heteronymArgs) {
+		var uniq []string
+		for _, v := range char {
+			if !containsString(uniq, v) {
+				uniq = append(uniq, v)
+			}
+		}
+		var next [][]string
+		for _, prefix := range result {
+			for _, v := range uniq {
+				if len(next) >= SPELL_MAX_READINGS {
+					break
+				}
+				next = append(next, append(append([]string{}, prefix...), v))
+			}
+		}
+		if len(next) > 0 {
+			result = next
+		}
+	}
+	return result
+}
+
+// normalizeSpell lower-cases a pinyin and drops the separators, "Chong Qing" and "chong'qing" become "chongqing"
+func normalizeSpell(spell string) string {
+	spell = strings.ToLower(strings.TrimSpace(spell))
+	return strings.NewReplacer(" ", "", "'", "", "-", "", "ü", "v").Replace(spell)
+}
+
+// child returns the sub region named name, or the one whose readings include the spell of name.
+// Only the reading of a chinese name is used, its heteronyms would match other places such as 陕西 and 山西.
+func (r *TreeRegionInfo) child(name string) (*TreeRegionInfo, bool) {
+	name = strings.TrimSpace(name)
+	return r.childBySpell(name, Spell(name))
+}
+
+// childBySpell is child with the spell of name already computed, it only reads the indexes built by indexRegionAliases
+func (r *TreeRegionInfo) childBySpell(name, spell string) (*TreeRegionInfo, bool) {
+	if key, ok := r.names[name]; ok {
+		return r.Regions[key], true
+	}
+	if sub, ok := r.Regions[spell]; ok {
+		return sub, true
+	}
+	if key, ok := r.aliases[spell]; ok {
+		return r.Regions[key], true
+	}
+	return nil, false
+}
+
+// indexRegionAliases indexes every sub region under all its readings, regionMu must be held.
+// The normalized readings win over the heteronyms when two regions share a reading.
+func (c *Weather) indexRegionAliases() {
+	var walk func(region *TreeRegionInfo)
+	walk = func(region *TreeRegionInfo) {
+		region.aliases = make(map[string]string)
+		region.names = make(map[string]string)
+		keys := make([]string, 0, len(region.Regions))
+		for key := range region.Regions {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if name := region.Regions[key].Name_; "" != name {
+				region.names[name] = key
+			}
+		}
+		for _, key := range keys {
+			if spell := Spell(region.Regions[key].Name_); spell != key {
+				if _, had := region.aliases[spell]; !had {
+					region.aliases[spell] = key
+				}
+			}
+		}
+		for _, key := range keys {
+			for _, spell := range Spells(region.Regions[key].Name_) {
+				if _, had := region.aliases[spell]; !had && spell != key {
+					region.aliases[spell] = key
+				}
+			}
+			walk(region.Regions[key])
+		}
+	}
+	walk(c.treeRegion)
+}
+
+func containsString(array []string, s string) bool {
+	for _, v := range array {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
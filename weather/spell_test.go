@@ -0,0 +1,76 @@
+package weather
+
+import "testing"
+
+func TestSpell(t *testing.T) {
+	for name, want := range map[string]string{
+		"上海":          "shanghai",
+		"陕西":          "shaanxi",
+		"山西":          "shanxi",
+		"闵行":          "minhang",
+		"重庆":          "chongqing",
+		" Chong Qing": "chongqing",
+		"xi'an":       "xian",
+	} {
+		if got := Spell(name); want != got {
+			t.Errorf("Spell(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if spells := Spells("重慶"); !containsString(spells, "chongqing") || "zhongqing" != spells[0] {
+		t.Errorf("Spells(重慶) = %v", spells)
+	}
+
+	RegisterSpell("测试地", "Ce Shi Di")
+	defer func() {
+		spellMu.Lock()
+		delete(spellOverrides, "测试地")
+		spellMu.Unlock()
+	}()
+	if got := Spell("测试地"); "ceshidi" != got {
+		t.Errorf("Spell() after RegisterSpell = %q", got)
+	}
+}
+
+func TestChild(t *testing.T) {
+	county := func(name string) *TreeRegionInfo {
+		return &TreeRegionInfo{RegionInfo: RegionInfo{Name_: name}}
+	}
+	/*旧版抓取的key：陕西也是shanxi时被当作山西的读音*/
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	c.treeRegion = &TreeRegionInfo{Regions: map[string]*TreeRegionInfo{
+		"shanxi":    county("山西"),
+		"shaanxi":   county("陕西"),
+		"chongqing": county("重庆"),
+		"minxing":   county("闵行"),
+	}}
+	c.indexRegions()
+
+	for query, want := range map[string]string{
+		"山西":        "山西",
+		"shanxi":    "山西",
+		"陕西":        "陕西",
+		"shaanxi":   "陕西",
+		"闵行":        "闵行",
+		"minhang":   "闵行",
+		"minxing":   "闵行",
+		"重慶":        "重庆",
+		"Chongqing": "重庆",
+	} {
+		if region, ok := c.treeRegion.child(query); !ok || want != region.Name_ {
+			t.Errorf("child(%q) = %+v, want %s", query, region, want)
+		}
+	}
+	if region, ok := c.treeRegion.child("北京"); ok {
+		t.Errorf("child(北京) = %+v", region)
+	}
+
+	/*名称优先于拼音：陕西的key是shanxi时仍按名称找到*/
+	c.treeRegion.Regions = map[string]*TreeRegionInfo{"shanxi": county("陕西"), "shanxi2": county("山西")}
+	c.indexRegions()
+	for _, name := range []string{"陕西", "山西"} {
+		if region, ok := c.treeRegion.child(name); !ok || name != region.Name_ {
+			t.Errorf("child(%s) = %+v", name, region)
+		}
+	}
+}
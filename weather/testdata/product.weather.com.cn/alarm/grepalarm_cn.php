@@ -0,0 +1 @@
+var alarminfo={"count":"3","data":[["上海市中心气象台","101020100-20240712103000-0702.html","121.47","31.23","31","0"],["松江区气象台","10102090-20240712110000-0701.html","121.22","31.03","31","0"],["成都市气象台","101270101-20240712080000-0203.html","104.06","30.67","51","0"]]};
\ No newline at end of file
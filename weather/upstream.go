@@ -0,0 +1,239 @@
+package weather
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	UPSTREAM_REFERER    = "http://www.weather.com.cn/"
+	UPSTREAM_USER_AGENT = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0"
+
+	UPSTREAM_DEFAULT_TIMEOUT     = 10 * time.Second
+	UPSTREAM_DEFAULT_RETRIES     = 2
+	UPSTREAM_DEFAULT_BACKOFF     = 500 * time.Millisecond
+	UPSTREAM_DEFAULT_MAX_BACKOFF = 5 * time.Second
+	BREAKER_DEFAULT_THRESHOLD    = 5 /*连续失败多少次后断开*/
+	BREAKER_DEFAULT_COOLDOWN     = 30 * time.Second
+)
+
+// Endpoints of weather.com.cn, each one has its own circuit breaker
+const (
+	ENDPOINT_SEVEN_DAYS    = "sevendays"
+	ENDPOINT_CURRENT       = "current"
+	ENDPOINT_HOURS         = "hours"
+	ENDPOINT_FORTY_DAYS    = "fortydays"
+	ENDPOINT_ALARM_LIST    = "alarmlist"
+	ENDPOINT_ALARM_DETAILS = "alarmdetails"
+	ENDPOINT_ALARM_FORM    = "alarmform"
+	ENDPOINT_REGION        = "region"
+)
+
+const (
+	BREAKER_CLOSED    = "closed"
+	BREAKER_OPEN      = "open"
+	BREAKER_HALF_OPEN = "half-open"
+)
+
+// Upstream is the http client shared by every scraper of weather.com.cn.
+// It reuses the connections, retries with exponential backoff and stops calling
+// an endpoint for a while once it keeps failing.
+type Upstream struct {
+	Client           *http.Client
+	Timeout          time.Duration /*每次请求的超时*/
+	Retries          int
+	Backoff          time.Duration /*第一次重试前的等待，之后每次翻倍*/
+	MaxBackoff       time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool /*half-open时只放行一个请求*/
+}
+
+var defaultUpstream = NewUpstream(nil)
+
+// NewUpstream creates an upstream client whose requests go through rt, a pooled transport when rt is nil
+func NewUpstream(rt http.RoundTripper) *Upstream {
+	if nil == rt {
+		rt = &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     90 * time.Second,
+		}
+	}
+	return &Upstream{
+		Client:           &http.Client{Transport: rt},
+		Timeout:          UPSTREAM_DEFAULT_TIMEOUT,
+		Retries:          UPSTREAM_DEFAULT_RETRIES,
+		Backoff:          UPSTREAM_DEFAULT_BACKOFF,
+		MaxBackoff:       UPSTREAM_DEFAULT_MAX_BACKOFF,
+		BreakerThreshold: BREAKER_DEFAULT_THRESHOLD,
+		BreakerCooldown:  BREAKER_DEFAULT_COOLDOWN,
+	}
+}
+
+// Get fetches rawURL with the weather.com.cn headers and returns the body of a 200 answer.
+// Errors match ErrUpstreamUnavailable, an open breaker fails at once with ErrCircuitOpen inside.
+func (u *Upstream) Get(ctx context.Context, endpoint, rawURL string) ([]byte, error) {
+	return u.getRetries(ctx, endpoint, rawURL, u.Retries)
+}
+
+// getRetries is Get with its own number of retries, such as the crawler's
+func (u *Upstream) getRetries(ctx context.Context, endpoint, rawURL string, retries int) ([]byte, error) {
+	var lastErr error
+	backoff := u.Backoff
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			log.Printf("retry %s in %v: %v", rawURL, backoff, lastErr)
+			select {
+			case <-ctx.Done():
+				return nil, &UpstreamError{URL: rawURL, Err: ctx.Err()}
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; u.MaxBackoff > 0 && backoff > u.MaxBackoff {
+				backoff = u.MaxBackoff
+			}
+		}
+		if !u.allow(endpoint) {
+			return nil, &UpstreamError{URL: rawURL, Err: ErrCircuitOpen}
+		}
+		body, err, retry := u.get(ctx, rawURL)
+		if nil != err && nil != ctx.Err() {
+			/*调用者取消或超时，不能说明上游是否正常*/
+			u.release(endpoint)
+		} else {
+			u.done(endpoint, nil == err || !retry)
+		}
+		if nil == err {
+			return body, nil
+		}
+		lastErr = err
+		if !retry || nil != ctx.Err() {
+			break
+		}
+	}
+	log.Println(lastErr)
+	return nil, lastErr
+}
+
+// get does one request, retry tells whether the failure is worth another attempt
+func (u *Upstream) get(ctx context.Context, rawURL string) (body []byte, err error, retry bool) {
+	if u.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err, false
+	}
+	//cheat
+	req.Header.Set("Referer", UPSTREAM_REFERER)
+	req.Header.Set("User-Agent", UPSTREAM_USER_AGENT)
+	resp, err := u.client().Do(req)
+	if err != nil {
+		return nil, &UpstreamError{URL: rawURL, Err: err}, true
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		retry = resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return nil, &UpstreamError{URL: rawURL, StatusCode: resp.StatusCode}, retry
+	}
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &UpstreamError{URL: rawURL, StatusCode: resp.StatusCode, Err: err}, true
+	}
+	return body, nil, false
+}
+
+// allow tells whether the breaker of endpoint lets a request through
+func (u *Upstream) allow(endpoint string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	b := u.breaker(endpoint)
+	if b.failures < u.BreakerThreshold || u.BreakerThreshold <= 0 {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// done records the result of a request, a 4xx answer is not a failure of the endpoint
+func (u *Upstream) done(endpoint string, ok bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	b := u.breaker(endpoint)
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if u.BreakerThreshold > 0 && b.failures >= u.BreakerThreshold {
+		if b.failures == u.BreakerThreshold {
+			log.Printf("circuit breaker of %s is open for %v", endpoint, u.BreakerCooldown)
+		}
+		b.openUntil = time.Now().Add(u.BreakerCooldown)
+	}
+}
+
+// release lets the next request probe a half-open breaker without recording a result
+func (u *Upstream) release(endpoint string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.breaker(endpoint).probing = false
+}
+
+func (u *Upstream) breaker(endpoint string) *breaker {
+	if nil == u.breakers {
+		u.breakers = make(map[string]*breaker)
+	}
+	b, ok := u.breakers[endpoint]
+	if !ok {
+		b = &breaker{}
+		u.breakers[endpoint] = b
+	}
+	return b
+}
+
+// Breakers returns the state of the circuit breaker of every endpoint called so far
+func (u *Upstream) Breakers() map[string]string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	states := make(map[string]string, len(u.breakers))
+	for endpoint, b := range u.breakers {
+		switch {
+		case u.BreakerThreshold <= 0 || b.failures < u.BreakerThreshold:
+			states[endpoint] = BREAKER_CLOSED
+		case time.Now().Before(b.openUntil):
+			states[endpoint] = BREAKER_OPEN
+		default:
+			states[endpoint] = BREAKER_HALF_OPEN
+		}
+	}
+	return states
+}
+
+func (u *Upstream) client() *http.Client {
+	if nil == u.Client {
+		return http.DefaultClient
+	}
+	return u.Client
+}
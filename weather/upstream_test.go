@@ -0,0 +1,69 @@
+package weather
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// stubTransport answers every request with status, or the error of a done request context
+type stubTransport struct {
+	status int
+}
+
+func (s stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: s.status,
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}, nil
+}
+
+func TestBreakerIgnoresCancel(t *testing.T) {
+	u := NewUpstream(stubTransport{status: http.StatusServiceUnavailable})
+	u.Retries = 0
+	u.BreakerThreshold = 2
+	u.BreakerCooldown = 10 * time.Millisecond
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	u.Get(context.Background(), "x", "http://stub/")
+	u.Get(cancelled, "x", "http://stub/")
+	if 1 != u.breakers["x"].failures {
+		t.Fatalf("a cancelled request changed the failures to %d", u.breakers["x"].failures)
+	}
+	u.Get(context.Background(), "x", "http://stub/")
+	if state := u.Breakers()["x"]; BREAKER_OPEN != state {
+		t.Fatalf("breaker is %s, want open", state)
+	}
+
+	/*half-open时被取消的探测请求不关闭断路器，下一个请求仍可以探测*/
+	time.Sleep(u.BreakerCooldown)
+	u.Get(cancelled, "x", "http://stub/")
+	if state := u.Breakers()["x"]; BREAKER_HALF_OPEN != state {
+		t.Errorf("breaker is %s after a cancelled probe, want half-open", state)
+	}
+	if !u.allow("x") {
+		t.Error("the cancelled probe still blocks the next one")
+	}
+}
+
+func TestCrawlerSharesUpstream(t *testing.T) {
+	wc := newFixtureWeatherCom()
+	c := New(0, wc)
+	defer c.Close()
+	if c.crawler.Upstream != wc.Upstream {
+		t.Error("the crawler does not share the upstream of the provider")
+	}
+	cr := NewCrawler()
+	c.SetCrawler(cr)
+	if cr.Upstream != wc.Upstream {
+		t.Error("SetCrawler() did not share the upstream of the provider")
+	}
+}
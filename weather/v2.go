@@ -0,0 +1,421 @@
+package weather
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+*  The v2 schema of the api, converted from the data of v1:
+*  numbers are numbers with the unit in the field name, times are ISO-8601 in China time,
+*  arrays are never null and have no null elements.
+ */
+
+const (
+	V2_DATE_LAYOUT = "2006-01-02" /*只有日期的字段*/
+	V2_STAR        = "☆"
+)
+
+var (
+	CHINA_TIME_ZONE = time.FixedZone("CST", 8*60*60) /*上游的时间都是北京时间*/
+
+	leadingNumberRe = regexp.MustCompile(`^\s*(-?\d+(?:\.\d+)?)`)
+	windScaleRe     = regexp.MustCompile(`^(小于)?(\d+)(?:-(\d+))?级$`)
+	monthDayRe      = regexp.MustCompile(`(\d{1,2})月(\d{1,2})日`)
+	dayOfMonthRe    = regexp.MustCompile(`(\d{1,2})日`)
+)
+
+// RegionV2 is RegionInfo in the v2 schema
+type RegionV2 struct {
+	Code      string   `json:"code"`
+	Name      string   `json:"name"`
+	FullName  string   `json:"fullName"`
+	Spell     string   `json:"spell"`
+	Latitude  *float64 `json:"latitude"` /*没有坐标时为null*/
+	Longitude *float64 `json:"longitude"`
+}
+
+// WindV2 is the wind of v2, the scales are Beaufort, "小于3级" is 0 to 3
+type WindV2 struct {
+	Directions []string `json:"directions"` /*白天、夜间的风向，当前和逐时天气只有一个*/
+	ScaleMin   *int     `json:"scaleMin"`
+	ScaleMax   *int     `json:"scaleMax"`
+}
+
+type CurrentV2 struct {
+	ObservedAt      *time.Time `json:"observedAt"`
+	Weather         string     `json:"weather"`
+	TemperatureC    *float64   `json:"temperatureC"`
+	TemperatureF    *float64   `json:"temperatureF"`
+	HumidityPercent *float64   `json:"humidityPercent"`
+	PressureHPa     *float64   `json:"pressureHPa"`
+	VisibilityKm    *float64   `json:"visibilityKm"`
+	WindSpeedKmh    *float64   `json:"windSpeedKmh"`
+	Wind            WindV2     `json:"wind"`
+	AQI             *int       `json:"aqi"`
+}
+
+type HourV2 struct {
+	Time         time.Time `json:"time"`
+	Weather      string    `json:"weather"`
+	TemperatureC float64   `json:"temperatureC"`
+	Wind         WindV2    `json:"wind"`
+}
+
+type DayV2 struct {
+	Date            string     `json:"date"`
+	Weather         string     `json:"weather"`
+	TemperatureMinC float64    `json:"temperatureMinC"`
+	TemperatureMaxC float64    `json:"temperatureMaxC"`
+	Wind            WindV2     `json:"wind"`
+	Sunrise         *time.Time `json:"sunrise"`
+	Sunset          *time.Time `json:"sunset"`
+}
+
+type LiveIndexV2 struct {
+	Name  string `json:"name"`
+	Level string `json:"level"`
+	Stars int    `json:"stars"`
+	Tips  string `json:"tips"`
+}
+
+type AlarmV2 struct {
+	Title       string     `json:"title"`
+	Details     string     `json:"details"`
+	Standard    string     `json:"standard"`
+	Manual      string     `json:"manual"`
+	TypeCode    string     `json:"typeCode"`
+	LevelCode   string     `json:"levelCode"`
+	SignalType  string     `json:"signalType"`
+	SignalLevel string     `json:"signalLevel"`
+	IssuedAt    *time.Time `json:"issuedAt"`
+}
+
+// WeatherV2 is WeatherInfo in the v2 schema
+type WeatherV2 struct {
+	Code       string        `json:"code"`
+	Name       string        `json:"name"`
+	FullName   string        `json:"fullName"`
+	Spell      string        `json:"spell"`
+	UpdatedAt  *time.Time    `json:"updatedAt"`
+	ServerTime *time.Time    `json:"serverTime"`
+	Lunar      string        `json:"lunar"`
+	HasAlarm   bool          `json:"hasAlarm"`
+	Current    CurrentV2     `json:"current"`
+	Hourly     []HourV2      `json:"hourly"`
+	Daily      []DayV2       `json:"daily"`
+	LiveIndex  []LiveIndexV2 `json:"liveIndex"`
+	Alarms     []AlarmV2     `json:"alarms"`
+}
+
+// DayForecastV2 is FortyDaysInfo in the v2 schema
+type DayForecastV2 struct {
+	Date                     string   `json:"date"`
+	Week                     string   `json:"week"`
+	Lunar                    string   `json:"lunar"`
+	SolarTerm                string   `json:"solarTerm"`
+	SubSolarTerm             string   `json:"subSolarTerm"`
+	Festivals                []string `json:"festivals"`
+	Suitable                 []string `json:"suitable"` /*宜*/
+	Avoid                    []string `json:"avoid"`    /*忌*/
+	Weather                  string   `json:"weather"`
+	WeatherCodes             []string `json:"weatherCodes"`
+	Wind                     WindV2   `json:"wind"`
+	TemperatureMaxC          *float64 `json:"temperatureMaxC"`
+	TemperatureMinC          *float64 `json:"temperatureMinC"`
+	HistoryTemperatureMaxC   *float64 `json:"historyTemperatureMaxC"`
+	HistoryTemperatureMinC   *float64 `json:"historyTemperatureMinC"`
+	HistoryRainChancePercent *float64 `json:"historyRainChancePercent"`
+	HistoryPrecipitationMm   *float64 `json:"historyPrecipitationMm"`
+}
+
+// BatchItemV2 is BatchItem in the v2 schema
+type BatchItemV2 struct {
+	Query      string     `json:"query"`
+	ECode      string     `json:"ecode"`
+	RMsg       string     `json:"rmsg,omitempty"`
+	Weather    *WeatherV2 `json:"weather"`
+	Candidates []RegionV2 `json:"candidates"`
+}
+
+// V2 converts the region to the v2 schema
+func (r RegionInfo) V2() RegionV2 {
+	region := RegionV2{Code: r.Code_, Name: r.Name_, FullName: r.FullName_, Spell: r.Spell_}
+	if 0 != r.Latitude_ || 0 != r.Longitude_ {
+		lat, lon := r.Latitude_, r.Longitude_
+		region.Latitude, region.Longitude = &lat, &lon
+	}
+	return region
+}
+
+// RegionsV2 converts the regions to the v2 schema
+func RegionsV2(regions []RegionInfo) []RegionV2 {
+	r := make([]RegionV2, 0, len(regions))
+	for _, v := range regions {
+		r = append(r, v.V2())
+	}
+	return r
+}
+
+// V2 converts the weather to the v2 schema. The upstream only gives the day of month of the daily forecast
+// and the hour of the update, their dates are taken around the first hour forecast or the server time of w.
+func (w *WeatherInfo) V2() *WeatherV2 {
+	now := time.Now().In(CHINA_TIME_ZONE)
+	r := &WeatherV2{
+		Code:      w.Code_,
+		Name:      w.Name_,
+		FullName:  w.FullName_,
+		Spell:     w.Spell_,
+		Lunar:     w.Lunar_,
+		HasAlarm:  w.Alarm_,
+		Hourly:    []HourV2{},
+		Daily:     []DayV2{},
+		LiveIndex: []LiveIndexV2{},
+		Alarms:    []AlarmV2{},
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", w.ServerTime_, time.Local); nil == err {
+		now = t.In(CHINA_TIME_ZONE)
+		r.ServerTime = &now
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, CHINA_TIME_ZONE)
+	/*逐时预报带有完整的日期，有的话以它为预报的当天*/
+	if 0 != len(w.HoursPredict_) && 0 != len(w.HoursPredict_[0]) {
+		h := w.HoursPredict_[0][0]
+		today = time.Date(h.Year, time.Month(h.Month), h.Day, 0, 0, 0, 0, CHINA_TIME_ZONE)
+	}
+
+	r.Current = w.CurrentInfo.v2(today)
+	for _, hours := range w.HoursPredict_ {
+		for _, h := range hours {
+			r.Hourly = append(r.Hourly, HourV2{
+				Time:         time.Date(h.Year, time.Month(h.Month), h.Day, h.Hour, 0, 0, 0, CHINA_TIME_ZONE),
+				Weather:      h.Weather,
+				TemperatureC: float64(h.Temp),
+				Wind:         windV2(h.WindLevel, h.WindDirection),
+			})
+		}
+	}
+	for i, d := range w.Weather_ {
+		if nil == d {
+			continue
+		}
+		date := today.AddDate(0, 0, i)
+		if m := dayOfMonthRe.FindStringSubmatch(d.Date_); nil != m {
+			day, _ := strconv.Atoi(m[1])
+			date = dayNear(date, day)
+		}
+		r.Daily = append(r.Daily, DayV2{
+			Date:            date.Format(V2_DATE_LAYOUT),
+			Weather:         d.Sun_,
+			TemperatureMinC: float64(d.Temperature_[0]),
+			TemperatureMaxC: float64(d.Temperature_[1]),
+			Wind:            windV2(d.Wind_.Level_, d.Wind_.From_, d.Wind_.To_),
+			Sunrise:         clockOn(date, d.Turn_.Sunrise),
+			Sunset:          clockOn(date, d.Turn_.Sunset),
+		})
+	}
+	/*更新时间只有时分，是第一天预报的当天*/
+	updated := today
+	if 0 != len(r.Daily) {
+		updated, _ = time.ParseInLocation(V2_DATE_LAYOUT, r.Daily[0].Date, CHINA_TIME_ZONE)
+	}
+	r.UpdatedAt = clockOn(updated, w.UpdateTime_)
+
+	for _, v := range w.LiveIndex_ {
+		if nil == v {
+			continue
+		}
+		r.LiveIndex = append(r.LiveIndex, LiveIndexV2{
+			Name:  v.Name_,
+			Level: v.Level_,
+			Stars: strings.Count(v.Stars_, V2_STAR),
+			Tips:  v.Tips,
+		})
+	}
+	for _, v := range w.AlarmInfo_ {
+		r.Alarms = append(r.Alarms, v.V2())
+	}
+	return r
+}
+
+// v2 converts the current weather observed around the day ref
+func (c BriefCurrentWeatherInfo) v2(ref time.Time) CurrentV2 {
+	r := CurrentV2{
+		Weather:         c.Weather,
+		TemperatureC:    leadingNumber(c.Temperature),
+		TemperatureF:    leadingNumber(c.TemperatureF),
+		HumidityPercent: leadingNumber(c.Humidity),
+		PressureHPa:     leadingNumber(c.Pressure),
+		VisibilityKm:    leadingNumber(c.Visibility),
+		WindSpeedKmh:    leadingNumber(c.WindSpeed),
+		Wind:            windV2(c.WindLevel, c.WindDirection),
+	}
+	if aqi := leadingNumber(c.AirQuality); nil != aqi {
+		n := int(*aqi)
+		r.AQI = &n
+	}
+	if m := monthDayRe.FindStringSubmatch(c.Date); nil != m {
+		month, _ := strconv.Atoi(m[1])
+		day, _ := strconv.Atoi(m[2])
+		r.ObservedAt = clockOn(dateNear(ref, time.Month(month), day), c.Time)
+	}
+	return r
+}
+
+// V2 converts the alarm to the v2 schema
+func (a AlarmDetails) V2() AlarmV2 {
+	r := AlarmV2{
+		Title:       a.Title,
+		Details:     a.Details,
+		Standard:    a.Standard,
+		Manual:      a.Manual,
+		TypeCode:    a.TypeCode,
+		LevelCode:   a.LevelCode,
+		SignalType:  a.SignalType,
+		SignalLevel: a.SignalLevel,
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", a.IssueTime, CHINA_TIME_ZONE); nil == err {
+		r.IssuedAt = &t
+	}
+	return r
+}
+
+// V2 converts the day of the forty days forecast to the v2 schema
+func (f FortyDaysInfo) V2() DayForecastV2 {
+	r := DayForecastV2{
+		Date:                     f.Date,
+		Week:                     f.Week,
+		Lunar:                    f.Lunar,
+		SolarTerm:                f.SolarTerm,
+		SubSolarTerm:             f.SubSolarTerm,
+		Festivals:                nonEmpty(f.Festival, f.RFestival),
+		Suitable:                 nonEmpty(strings.Split(f.Ripe, ".")...),
+		Avoid:                    nonEmpty(strings.Split(f.Avoid, ".")...),
+		Weather:                  f.Weather,
+		WeatherCodes:             nonEmpty(f.WCodeOne, f.WCodeTwo),
+		Wind:                     windV2(f.Wind),
+		TemperatureMaxC:          leadingNumber(f.HTemp),
+		TemperatureMinC:          leadingNumber(f.MTemp),
+		HistoryTemperatureMaxC:   leadingNumber(f.HMax),
+		HistoryTemperatureMinC:   leadingNumber(f.HMin),
+		HistoryRainChancePercent: leadingNumber(f.HRate),
+		HistoryPrecipitationMm:   leadingNumber(f.HRain),
+	}
+	if t, err := time.ParseInLocation("20060102", f.Date, CHINA_TIME_ZONE); nil == err {
+		r.Date = t.Format(V2_DATE_LAYOUT)
+	}
+	return r
+}
+
+// FortyDaysV2 converts the forty days forecast to the v2 schema
+func FortyDaysV2(days []FortyDaysInfo) []DayForecastV2 {
+	r := make([]DayForecastV2, 0, len(days))
+	for _, v := range days {
+		r = append(r, v.V2())
+	}
+	return r
+}
+
+// V2 converts the batch item to the v2 schema
+func (item BatchItem) V2() BatchItemV2 {
+	r := BatchItemV2{Query: item.Query, ECode: item.ECode, RMsg: item.RMsg, Candidates: RegionsV2(item.Candidates)}
+	if nil != item.Weather {
+		r.Weather = item.Weather.V2()
+	}
+	return r
+}
+
+// BatchV2 converts the result of a batch query to the v2 schema
+func BatchV2(items []BatchItem) []BatchItemV2 {
+	r := make([]BatchItemV2, 0, len(items))
+	for _, v := range items {
+		r = append(r, v.V2())
+	}
+	return r
+}
+
+// windV2 parses a wind level such as "小于3级", "3-4级" or "2级", the scales are null when it is not a number
+func windV2(level string, directions ...string) WindV2 {
+	r := WindV2{Directions: nonEmpty(directions...)}
+	m := windScaleRe.FindStringSubmatch(strings.TrimSpace(level))
+	if nil == m {
+		return r
+	}
+	low, _ := strconv.Atoi(m[2])
+	high := low
+	if "" != m[1] {
+		low = 0
+	} else if "" != m[3] {
+		high, _ = strconv.Atoi(m[3])
+	}
+	r.ScaleMin, r.ScaleMax = &low, &high
+	return r
+}
+
+// leadingNumber parses the number at the start of s such as "9km/h" or "67%", null when there is none
+func leadingNumber(s string) *float64 {
+	m := leadingNumberRe.FindStringSubmatch(s)
+	if nil == m {
+		return nil
+	}
+	f, err := strconv.ParseFloat(m[1], 64)
+	if nil != err {
+		return nil
+	}
+	return &f
+}
+
+// clockOn returns the time "15:04" on date, null when it is not a time
+func clockOn(date time.Time, clock string) *time.Time {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if nil != err {
+		return nil
+	}
+	r := time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, CHINA_TIME_ZONE)
+	return &r
+}
+
+// dayNear returns the date closest to ref whose day of month is day
+func dayNear(ref time.Time, day int) time.Time {
+	for i := 0; i <= 15; i++ {
+		if d := ref.AddDate(0, 0, i); d.Day() == day {
+			return d
+		}
+		if d := ref.AddDate(0, 0, -i); d.Day() == day {
+			return d
+		}
+	}
+	return ref
+}
+
+// dateNear returns the date of month/day in the year closest to ref
+func dateNear(ref time.Time, month time.Month, day int) time.Time {
+	best := time.Date(ref.Year(), month, day, 0, 0, 0, 0, CHINA_TIME_ZONE)
+	for _, year := range []int{ref.Year() - 1, ref.Year() + 1} {
+		d := time.Date(year, month, day, 0, 0, 0, 0, CHINA_TIME_ZONE)
+		if absDuration(d.Sub(ref)) < absDuration(best.Sub(ref)) {
+			best = d
+		}
+	}
+	return best
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// nonEmpty returns the non-empty strings of s, never nil
+func nonEmpty(s ...string) []string {
+	r := make([]string, 0, len(s))
+	for _, v := range s {
+		if v = strings.TrimSpace(v); "" != v {
+			r = append(r, v)
+		}
+	}
+	return r
+}
@@ -0,0 +1,122 @@
+package weather
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWeatherV2(t *testing.T) {
+	p := newFixtureWeatherCom()
+	ctx := context.Background()
+	info, err := p.SevenDays(ctx, shanghai)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Current(ctx, shanghai.Code_, info); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Hours(ctx, shanghai.Code_, info); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Alarm(ctx, "101020100-20240712103000-0702.html", info); err != nil {
+		t.Fatal(err)
+	}
+	info.LiveIndex_[2] = nil
+
+	got := info.V2()
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, CHINA_TIME_ZONE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	if nil == got.UpdatedAt || !got.UpdatedAt.Equal(at("2024-07-12 11:30")) {
+		t.Errorf("updatedAt = %v", got.UpdatedAt)
+	}
+	if nil == got.Current.ObservedAt || !got.Current.ObservedAt.Equal(at("2024-07-12 14:25")) {
+		t.Errorf("current.observedAt = %v", got.Current.ObservedAt)
+	}
+	if nil == got.Current.WindSpeedKmh || 9 != *got.Current.WindSpeedKmh || nil == got.Current.HumidityPercent || 67 != *got.Current.HumidityPercent {
+		t.Errorf("current = %+v", got.Current)
+	}
+	if 12 != len(got.Hourly) || !got.Hourly[11].Time.Equal(at("2024-07-13 23:00")) {
+		t.Errorf("hourly = %+v", got.Hourly)
+	}
+	if 7 != len(got.Daily) || "2024-07-12" != got.Daily[0].Date || "2024-07-18" != got.Daily[6].Date {
+		t.Errorf("daily = %+v", got.Daily)
+	}
+	if day := got.Daily[0]; 28 != day.TemperatureMinC || 34 != day.TemperatureMaxC || nil == day.Sunset || !day.Sunset.Equal(at("2024-07-12 19:01")) {
+		t.Errorf("daily[0] = %+v", day)
+	}
+	if 5 != len(got.LiveIndex) || 5 != got.LiveIndex[2].Stars {
+		t.Errorf("liveIndex = %+v", got.LiveIndex)
+	}
+	if 1 != len(got.Alarms) || nil == got.Alarms[0].IssuedAt || !got.Alarms[0].IssuedAt.Equal(at("2024-07-12 10:30")) {
+		t.Errorf("alarms = %+v", got.Alarms)
+	}
+
+	if empty := (&WeatherInfo{}).V2(); nil == empty.Hourly || nil == empty.Daily || nil == empty.LiveIndex || nil == empty.Alarms {
+		t.Errorf("empty weather has null arrays: %+v", empty)
+	}
+}
+
+func TestFortyDaysV2(t *testing.T) {
+	var days []FortyDaysInfo
+	if err := newFixtureWeatherCom().FortyDays(context.Background(), 2024, 7, "101020100", &days); err != nil {
+		t.Fatal(err)
+	}
+	got := FortyDaysV2(days)
+	if "2024-07-11" != got[0].Date || !reflect.DeepEqual(got[0].Suitable, []string{"嫁娶", "出行"}) ||
+		!reflect.DeepEqual(got[0].WeatherCodes, []string{"04", "01"}) {
+		t.Errorf("day 0 = %+v", got[0])
+	}
+	if nil == got[0].HistoryPrecipitationMm || 12.3 != *got[0].HistoryPrecipitationMm || nil != got[1].HistoryPrecipitationMm {
+		t.Errorf("history precipitation = %v, %v", got[0].HistoryPrecipitationMm, got[1].HistoryPrecipitationMm)
+	}
+	if nil == got[0].HistoryRainChancePercent || 45 != *got[0].HistoryRainChancePercent {
+		t.Errorf("history rain chance = %v", got[0].HistoryRainChancePercent)
+	}
+}
+
+func TestWindV2(t *testing.T) {
+	for _, tc := range []struct {
+		level    string
+		min, max int
+		ok       bool
+	}{
+		{"小于3级", 0, 3, true},
+		{"3-4级", 3, 4, true},
+		{"2级", 2, 2, true},
+		{"微风", 0, 0, false},
+		{"", 0, 0, false},
+	} {
+		got := windV2(tc.level, "南风", "")
+		if !reflect.DeepEqual(got.Directions, []string{"南风"}) {
+			t.Errorf("windV2(%q).Directions = %q", tc.level, got.Directions)
+		}
+		if !tc.ok {
+			if nil != got.ScaleMin || nil != got.ScaleMax {
+				t.Errorf("windV2(%q) = %v, %v; want null scales", tc.level, *got.ScaleMin, *got.ScaleMax)
+			}
+			continue
+		}
+		if nil == got.ScaleMin || nil == got.ScaleMax || tc.min != *got.ScaleMin || tc.max != *got.ScaleMax {
+			t.Errorf("windV2(%q) = %+v, want %d to %d", tc.level, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestDayNear(t *testing.T) {
+	ref := time.Date(2024, 7, 30, 0, 0, 0, 0, CHINA_TIME_ZONE)
+	for day, want := range map[int]string{30: "2024-07-30", 31: "2024-07-31", 1: "2024-08-01", 28: "2024-07-28"} {
+		if got := dayNear(ref, day).Format(V2_DATE_LAYOUT); got != want {
+			t.Errorf("dayNear(%d) = %s, want %s", day, got, want)
+		}
+	}
+	if got := dateNear(time.Date(2025, 1, 1, 0, 0, 0, 0, CHINA_TIME_ZONE), 12, 31); 2024 != got.Year() {
+		t.Errorf("dateNear(12月31日) = %v, want 2024", got)
+	}
+}
@@ -0,0 +1,169 @@
+package weather
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	EVENT_WEATHER       = "weather"       /*整个天气数据刷新*/
+	EVENT_CURRENT       = "current"       /*当前天气变化*/
+	EVENT_ALARM_ADDED   = "alarm_added"   /*新的告警*/
+	EVENT_ALARM_REMOVED = "alarm_removed" /*告警解除*/
+
+	WATCH_BUFFER = 16 /*每个监听者缓存的事件数，满了之后丢弃新事件*/
+)
+
+/*各城市的监听者*/
+type watchHub struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan WeatherEvent]struct{}
+	alarms   map[string]string /*被监听的城市 -> 上次告警列表中的告警文件，用于发现告警变化*/
+	hookOnce sync.Once
+}
+
+// Watch returns the events of the city code, pushed whenever its cached data is refreshed,
+// stop must be called once the events are no longer read.
+func (c *Weather) Watch(code string) (events <-chan WeatherEvent, stop func()) {
+	c.watch.hookOnce.Do(func() {
+		OnAlarmListUpdate(c.onAlarmListUpdate)
+	})
+	ch := make(chan WeatherEvent, WATCH_BUFFER)
+	c.watch.mu.Lock()
+	if nil == c.watch.watchers {
+		c.watch.watchers = make(map[string]map[chan WeatherEvent]struct{})
+		c.watch.alarms = make(map[string]string)
+	}
+	if nil == c.watch.watchers[code] {
+		c.watch.watchers[code] = make(map[chan WeatherEvent]struct{})
+		c.watch.alarms[code] = alarmFiles(code)
+	}
+	c.watch.watchers[code][ch] = struct{}{}
+	c.watch.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			c.watch.mu.Lock()
+			defer c.watch.mu.Unlock()
+			delete(c.watch.watchers[code], ch)
+			if 0 == len(c.watch.watchers[code]) {
+				delete(c.watch.watchers, code)
+				delete(c.watch.alarms, code)
+			}
+		})
+	}
+}
+
+// Watchers returns the number of watchers of every watched city code
+func (c *Weather) Watchers() map[string]int {
+	c.watch.mu.Lock()
+	defer c.watch.mu.Unlock()
+	n := make(map[string]int, len(c.watch.watchers))
+	for code, watchers := range c.watch.watchers {
+		n[code] = len(watchers)
+	}
+	return n
+}
+
+// publish sends event to the watchers of its code without blocking, a watcher that falls behind misses it
+func (c *Weather) publish(event WeatherEvent) {
+	c.watch.mu.Lock()
+	defer c.watch.mu.Unlock()
+	for ch := range c.watch.watchers[event.Code] {
+		select {
+		case ch <- event:
+		default:
+			log.Println("drop the", event.Type, "event of", event.Code, "for a slow watcher")
+		}
+	}
+}
+
+// watched reports whether the city code has any watcher
+func (c *Weather) watched(code string) bool {
+	c.watch.mu.Lock()
+	defer c.watch.mu.Unlock()
+	return len(c.watch.watchers[code]) > 0
+}
+
+// publishWeather pushes a refreshed weather of code and the alarms added or removed since old
+func (c *Weather) publishWeather(code string, old, cur *WeatherInfo) {
+	if !c.watched(code) {
+		return
+	}
+	c.publish(WeatherEvent{Type: EVENT_WEATHER, Code: code, Weather: withServerTime(cur)})
+	var oldAlarms []AlarmDetails
+	if nil != old {
+		oldAlarms = old.AlarmInfo_
+	}
+	if added := alarmsNotIn(cur.AlarmInfo_, oldAlarms); len(added) > 0 {
+		c.publish(WeatherEvent{Type: EVENT_ALARM_ADDED, Code: code, Alarms: added})
+	}
+	if removed := alarmsNotIn(oldAlarms, cur.AlarmInfo_); len(removed) > 0 {
+		c.publish(WeatherEvent{Type: EVENT_ALARM_REMOVED, Code: code, Alarms: removed})
+	}
+}
+
+// publishCurrent pushes the current weather of code when it differs from old
+func (c *Weather) publishCurrent(code string, old, cur BriefCurrentWeatherInfo) {
+	if old == cur || !c.watched(code) {
+		return
+	}
+	c.publish(WeatherEvent{Type: EVENT_CURRENT, Code: code, Current: &cur})
+}
+
+// onAlarmListUpdate refreshes the watched cities whose alarms changed in the new alarm list,
+// the refresh publishes the alarms added and removed.
+func (c *Weather) onAlarmListUpdate(map[string][]Location) {
+	var changed []string
+	c.watch.mu.Lock()
+	for code, files := range c.watch.alarms {
+		if cur := alarmFiles(code); cur != files {
+			c.watch.alarms[code] = cur
+			changed = append(changed, code)
+		}
+	}
+	c.watch.mu.Unlock()
+
+	for _, code := range changed {
+		cityinfo, err := c.RegionByCode(code)
+		if err != nil {
+			continue
+		}
+		c.weatherFlight.Go(code, func(ctx context.Context) (interface{}, error) {
+			return c.fetchWeatherInfo(ctx, cityinfo)
+		})
+	}
+}
+
+// alarmFiles is the sorted alarm files of the city code in the current alarm list
+func alarmFiles(code string) string {
+	locations, _ := GetLocationInfoByID(code)
+	files := make([]string, 0, len(locations))
+	for _, v := range locations {
+		files = append(files, v.FileName)
+	}
+	sort.Strings(files)
+	return strings.Join(files, STR_SEP)
+}
+
+// alarmsNotIn returns the alarms of a missing from b
+func alarmsNotIn(a, b []AlarmDetails) []AlarmDetails {
+	var r []AlarmDetails
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r = append(r, x)
+		}
+	}
+	return r
+}
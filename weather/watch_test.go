@@ -0,0 +1,119 @@
+package weather
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watchProvider answers the current temperature set by the test and an alarm per alarm file
+type watchProvider struct {
+	mu   sync.Mutex
+	temp string
+}
+
+func (p *watchProvider) SevenDays(ctx context.Context, cityinfo RegionInfo) (*WeatherInfo, error) {
+	return &WeatherInfo{Code_: cityinfo.Code_, Name_: cityinfo.Name_, FullName_: cityinfo.FullName_}, nil
+}
+
+func (p *watchProvider) Current(ctx context.Context, code string, r *WeatherInfo) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r.CurrentInfo.Temperature = p.temp
+	return nil
+}
+
+func (p *watchProvider) Hours(ctx context.Context, code string, r *WeatherInfo) error { return nil }
+func (p *watchProvider) FortyDays(ctx context.Context, year, month int, code string, r *[]FortyDaysInfo) error {
+	return nil
+}
+
+func (p *watchProvider) Alarm(ctx context.Context, fileName string, r *WeatherInfo) error {
+	r.AlarmInfo_ = append(r.AlarmInfo_, AlarmDetails{Title: fileName})
+	return nil
+}
+
+func (p *watchProvider) setTemp(temp string) {
+	p.mu.Lock()
+	p.temp = temp
+	p.mu.Unlock()
+}
+
+func TestWatch(t *testing.T) {
+	p := &watchProvider{temp: "30"}
+	c := New(0, p)
+	defer c.Close()
+	c.treeRegion = testRegionTree()
+	c.indexRegions()
+	t.Cleanup(func() { setAlarmList(make(map[string][]Location)) })
+	ctx := context.Background()
+	events, stop := c.Watch(shanghai.Code_)
+	defer stop()
+	if n := c.Watchers()[shanghai.Code_]; 1 != n {
+		t.Fatalf("%d watchers", n)
+	}
+
+	next := func(want string) WeatherEvent {
+		t.Helper()
+		select {
+		case event := <-events:
+			if want != event.Type || shanghai.Code_ != event.Code {
+				t.Fatalf("got the %s event of %s, want %s", event.Type, event.Code, want)
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", want)
+		}
+		return WeatherEvent{}
+	}
+
+	/*第一次查询推送整个天气*/
+	if _, err := c.showRegionWeather(ctx, shanghai); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(EVENT_WEATHER); nil == event.Weather || "30" != event.Weather.CurrentInfo.Temperature {
+		t.Errorf("weather event %+v", event.Weather)
+	}
+
+	/*当前天气变化时推送current，不变时不推送*/
+	p.setTemp("31")
+	c.currentlru.Remove(shanghai.Code_)
+	c.showRegionWeather(ctx, shanghai)
+	if event := next(EVENT_CURRENT); nil == event.Current || "31" != event.Current.Temperature {
+		t.Errorf("current event %+v", event.Current)
+	}
+	c.currentlru.Remove(shanghai.Code_)
+	c.showRegionWeather(ctx, shanghai)
+
+	/*告警列表变化时重新抓取，推送新增及解除的告警*/
+	setAlarmList(map[string][]Location{"10102": {{FileName: "a.html"}, {FileName: "b.html"}}})
+	next(EVENT_WEATHER)
+	if event := next(EVENT_ALARM_ADDED); 2 != len(event.Alarms) || "a.html" != event.Alarms[0].Title {
+		t.Errorf("alarm_added %+v", event.Alarms)
+	}
+	setAlarmList(map[string][]Location{"10102": {{FileName: "b.html"}, {FileName: "c.html"}}})
+	next(EVENT_WEATHER)
+	if event := next(EVENT_ALARM_ADDED); 1 != len(event.Alarms) || "c.html" != event.Alarms[0].Title {
+		t.Errorf("alarm_added %+v", event.Alarms)
+	}
+	if event := next(EVENT_ALARM_REMOVED); 1 != len(event.Alarms) || "a.html" != event.Alarms[0].Title {
+		t.Errorf("alarm_removed %+v", event.Alarms)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected %s event", event.Type)
+	default:
+	}
+
+	/*停止后不再占用*/
+	stop()
+	stop()
+	if n := c.Watchers(); 0 != len(n) {
+		t.Errorf("watchers %v after stop", n)
+	}
+	c.publishCurrent(shanghai.Code_, BriefCurrentWeatherInfo{}, BriefCurrentWeatherInfo{Temperature: "1"})
+	if 0 != len(events) {
+		t.Errorf("%d events after stop", len(events))
+	}
+}
@@ -2,23 +2,18 @@ package weather
 
 import (
 	"WeatherInfos/lrucache"
-	"bufio"
-	"encoding/gob"
-	"encoding/json"
+	"WeatherInfos/singleflight"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/Lofanmi/chinese-calendar-golang/calendar"
-	"github.com/mozillazg/go-pinyin"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-	"unsafe"
 )
 
 const (
@@ -35,39 +30,53 @@ const (
 	REGEXP_GET_CITY_START      = "<div class=\"conMidtab3\">"
 	REGEXP_GET_CITY_END        = "</div>"
 	DISCARD_INFO_FIELD         = "详情"
-	REGEXP_WEATHER_DAY7_START  = "<ul class=\"t clearfix\">"
-	REGEXP_WEATHER_END         = "</ul>"
-	REGEXP_GET_WEATHER_NUM     = "[0-9-]+"
-	REGEXP_LIVE_INDEX_START    = "<ul class=\"clearfix\">"
-	REGEXT_GET_SPORT_STAR      = "class=\"star\""
-	DAY_INFO_SPLIT_SEP         = "</li>"
 	RESP_DATA_FIELD            = "data"
 	RESP_RCODE_FIELD           = "rcode"
 	RESP_RMSG_FIELD            = "rmsg"
+	RESP_ECODE_FIELD           = "ecode"
 	STR_SEP                    = ","
 	//------
 	REGEXP_GET_ALARM_START = `<div class="sk_alarm">`
+	//------
+	WEATHER_STALE_KEEP     = 24 * time.Hour   /*过期的天气数据保留多久，上游失败时仍返回*/
+	CURRENT_INFO_GAP       = 3 * time.Minute  /*同一城市查询当前天气的最小间隔*/
+	CACHE_JANITOR_INTERVAL = 10 * time.Minute /*清理过期缓存的间隔*/
 )
 
 var (
 	DEFAULT_LIMIT_SIZE             int64
 	UPDATE_WEATHERINFO_GAP_MINUTES int64
+	WEATHER_CACHE_MAX_BYTES        int64 /*天气缓存的字节数上限，环境变量WEATHER_CACHE_MB*/
+	FORTY_CACHE_MAX_BYTES          int64 /*40天预报缓存的字节数上限，环境变量FORTY_CACHE_MB*/
 )
 
 type Weather struct {
-	weatherMu, fortyMu, regionMu, entryCacheMu sync.RWMutex
-	nbytes                                     int64
-	weatherlru                                 *lrucache.Cache
-	fortydayslru                               *lrucache.Cache
-	entryCache                                 *lrucache.Cache
-	nhit, nget                                 int64
-	nevict                                     int64
+	regionMu                                   sync.RWMutex
+	regionFileMu                               sync.Mutex /*写地区文件时锁定，需要时先于regionMu锁定*/
+	snapshotMu                                 sync.Mutex /*同时只保存一次缓存快照*/
+	weatherlru                                 *lrucache.Cache[string, *WeatherInfo]
+	fortydayslru                               *lrucache.Cache[string, []FortyDaysInfo]
+	currentlru                                 *lrucache.Cache[string, struct{}] /*CURRENT_INFO_GAP内查询过当前天气的城市*/
+	entryCache                                 *lrucache.Cache[string, string]
+	ncoalesced                                 int64 /*atomic*/
+	weatherFlight, currentFlight, fortyFlight  singleflight.Group
 	treeRegion                                 *TreeRegionInfo
+	codeIndex                                  map[string]*TreeRegionInfo /*站点code -> 区县*/
+	searchIndex                                []searchEntry
+	refresher                                  regionRefresher
+	watch                                      watchHub
+	crawler                                    *Crawler
+	stopJanitors                               []func()  /*停止各缓存的定期清理*/
+	regionBuilt                                time.Time /*地区列表抓取的时间*/
+	regionComplete                             bool      /*地区列表是否完整，不完整的不保存*/
 	inited                                     bool
+	provider                                   Provider
 }
 
 func init() {
-	DEFAULT_LIMIT_SIZE = 34
+	DEFAULT_LIMIT_SIZE = 0 /*条目数不限制，由字节数限制*/
+	WEATHER_CACHE_MAX_BYTES = cacheBytesFromEnv("WEATHER_CACHE_MB", 32)
+	FORTY_CACHE_MAX_BYTES = cacheBytesFromEnv("FORTY_CACHE_MB", 8)
 	UPDATE_WEATHERINFO_GAP_MINUTES = 60
 	var e = os.Getenv("REFRESH_RATE")
 	eNum, _ := strconv.ParseInt(e, 10, 64)
@@ -80,465 +89,282 @@ func init() {
 	}
 }
 
-func New(maxEntries int) *Weather {
-	return &Weather{
-		weatherlru:   lrucache.New(maxEntries),
-		fortydayslru: lrucache.New(10),
-		entryCache:   lrucache.New(0),
+// cacheBytesFromEnv reads a cache budget in MB from the environment
+func cacheBytesFromEnv(name string, defaultMB int64) int64 {
+	mb, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if nil != err || mb <= 0 {
+		mb = defaultMB
+	}
+	return mb << 20
+}
+
+// New creates a Weather that fetches its data from provider,
+// the weather.com.cn scraper is used when provider is nil.
+// The caches are limited by WEATHER_CACHE_MAX_BYTES and FORTY_CACHE_MAX_BYTES, and by maxEntries when it is not 0.
+func New(maxEntries int, provider Provider) *Weather {
+	if nil == provider {
+		provider = NewWeatherCom()
+	}
+	gap := time.Duration(UPDATE_WEATHERINFO_GAP_MINUTES) * time.Minute
+	c := &Weather{
+		weatherlru:   lrucache.New[string, *WeatherInfo](maxEntries, gap),
+		fortydayslru: lrucache.New[string, []FortyDaysInfo](0, gap),
+		currentlru:   lrucache.New[string, struct{}](0, CURRENT_INFO_GAP),
+		entryCache:   lrucache.New[string, string](0, 0),
 		treeRegion:   &TreeRegionInfo{Regions: make(map[string]*TreeRegionInfo)},
+		provider:     provider,
+	}
+	c.SetCrawler(NewCrawler())
+	c.weatherlru.Grace = WEATHER_STALE_KEEP
+	c.weatherlru.MaxBytes = WEATHER_CACHE_MAX_BYTES
+	c.weatherlru.Size = func(key string, value *WeatherInfo) int64 {
+		return int64(len(key)) + sizeOf(value)
 	}
+	c.fortydayslru.Grace = WEATHER_STALE_KEEP
+	c.fortydayslru.MaxBytes = FORTY_CACHE_MAX_BYTES
+	c.fortydayslru.Size = func(key string, value []FortyDaysInfo) int64 {
+		return int64(len(key)) + sizeOf(value)
+	}
+	c.currentlru.Size = func(key string, value struct{}) int64 {
+		return int64(len(key))
+	}
+	c.stopJanitors = []func(){
+		c.weatherlru.StartJanitor(CACHE_JANITOR_INTERVAL),
+		c.fortydayslru.StartJanitor(CACHE_JANITOR_INTERVAL),
+		c.currentlru.StartJanitor(CACHE_JANITOR_INTERVAL),
+	}
+	return c
+}
+
+// Close stops the background cleaning of the caches, c can still be used but its expired entries are only removed on access
+func (c *Weather) Close() {
+	for _, stop := range c.stopJanitors {
+		stop()
+	}
+}
+
+// SetCrawler replaces the crawler of the region tree, such as one with more workers.
+// A crawler without an Upstream shares the one of the weather.com.cn provider.
+func (c *Weather) SetCrawler(cr *Crawler) {
+	c.regionMu.Lock()
+	defer c.regionMu.Unlock()
+	if wc, ok := c.provider.(*WeatherCom); ok && nil == cr.Upstream {
+		cr.Upstream = wc.upstream()
+	}
+	c.crawler = cr
 }
 
 func (c *Weather) InitRegionTree() (err error) {
+	return c.InitRegionTreeContext(context.Background())
+}
+
+// InitRegionTreeContext is InitRegionTree, a crawl of the region tree stops once ctx is done
+func (c *Weather) InitRegionTreeContext(ctx context.Context) (err error) {
+	c.regionFileMu.Lock()
+	defer c.regionFileMu.Unlock()
 	c.regionMu.Lock()
 	defer c.regionMu.Unlock()
+	if n, err := LoadSpellOverrides(SPELL_OVERRIDE_FILE); nil == err {
+		log.Println("load", n, "spell overrides from", SPELL_OVERRIDE_FILE)
+	}
 	if err = c.loadRegionData(REGION_CACHE_FILE); err != nil {
 		log.Println("load region info from file failed,ready to get")
-		var wg sync.WaitGroup
-		req, err := http.NewRequest("GET", REGION_SITE, nil)
-		if err != nil {
-			log.Println(err)
+		tree, err := c.crawler.CrawlContext(ctx)
+		if errors.Is(err, ErrIncompleteCrawl) {
+			/*不完整的列表只在内存中使用，不保存*/
+			log.Println("use the incomplete region tree without saving it:", err)
+			c.treeRegion = tree
+			c.regionComplete = false
+			c.indexRegions()
 			return err
 		}
-		//req.Header.Add("Content-Type", "application/json")
-		handle := &http.Client{Timeout: 10 * time.Second}
-		resp, err := handle.Do(req)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			log.Println(req.URL, err)
-			return err
-		}
-
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			log.Println("read resp.body error", req.URL, err)
 			return err
 		}
-
-		re := regexp.MustCompile(REGEXP_GET_REGION_URL_INFO) //取出连接
-		regexpResult := re.FindAllString(string(body), 100)
-		urlfind := regexp.MustCompile(REGEXP_GET_REGION_URL)
-		namefind := regexp.MustCompile(REGEXP_GET_WORD)
-
-		chCache := make(chan *TreeRegionInfo, 100)
-		for _, strUrl := range regexpResult {
-			wg.Add(1)
-			go func(url, name string, cache chan<- *TreeRegionInfo) {
-				defer wg.Done()
-				var spell string
-				for _, v := range pinyin.LazyConvert(name, nil) {
-					spell += v
-				}
-				regionInfo := &TreeRegionInfo{
-					RegionInfo: RegionInfo{
-						Name_: name, Url_: url, Spell_: spell,
-					},
-					Regions: make(map[string]*TreeRegionInfo),
-				}
-				c.parseCityOrCountyInfo(regionInfo)
-				cache <- regionInfo
-			}(urlfind.FindString(strUrl), namefind.FindString(strUrl), chCache)
-		}
-		wg.Wait()
-		close(chCache)
-		for {
-			info := <-chCache
-			if nil == info {
-				break
-			}
-			c.treeRegion.Regions[info.Spell_] = info
-		}
+		c.treeRegion = tree
+		c.regionBuilt = time.Now()
+		c.regionComplete = true
 		if err := c.saveRegionData(REGION_CACHE_FILE); nil != err {
 			log.Println(err)
 		}
 	}
-	return
-}
-
-func (c *Weather) parseCityOrCountyInfo(info *TreeRegionInfo) {
-	req, err := http.NewRequest("GET", WEATHER_SITE+info.Url_, nil)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	handle := &http.Client{Timeout: 10 * time.Second}
-	resp, err := handle.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		log.Println(req.URL, err)
-		return
+	c.indexRegions()
+	if n, err := loadStationCoordinates(STATION_COORDS_FILE, c.codeIndex); nil == err {
+		log.Println("load coordinates of", n, "stations from", STATION_COORDS_FILE)
 	}
-	defer resp.Body.Close()
-	buf, _ := ioutil.ReadAll(resp.Body)
-
-	infoExtraction := regexp.MustCompile(REGEXP_GET_CITY_URL_INFO)
-	start_re := regexp.MustCompile(REGEXP_GET_CITY_START)
-	end_re := regexp.MustCompile(REGEXP_GET_CITY_END)
-
-	urlfind := regexp.MustCompile(REGEXP_GET_CITY_URL)
-	namefind := regexp.MustCompile(REGEXP_GET_WORD)
-	codefind := regexp.MustCompile(REGEXP_GET_CITY_CODE)
-	start_index := start_re.FindAllStringIndex(string(buf), -1)
-
-	for _, index := range start_index {
-		end_index := end_re.FindStringIndex(string(buf[index[1]:]))
-		sinfo := string(buf[index[0] : index[1]+end_index[1]])
-		counties := infoExtraction.FindAllString(sinfo, -1)
-		cityName := namefind.FindString(sinfo)
-		var spellCityName string
-		for _, v := range pinyin.LazyConvert(cityName, nil) {
-			spellCityName += v
-		}
-		if _, had := info.Regions[cityName]; had {
-			break /*repeat*/
-		}
-
-		regionInfo := &TreeRegionInfo{
-			RegionInfo: RegionInfo{Name_: cityName, FullName_: fmt.Sprintf("%s,%s", info.Name_, cityName), Spell_: spellCityName},
-			Regions:    make(map[string]*TreeRegionInfo),
-		}
-		for _, cinfo := range counties {
-			countyName := namefind.FindString(cinfo)
-			if DISCARD_INFO_FIELD == countyName { /*skip the description*/
-				continue
-			}
-			var spellCountyName = ""
-			for _, v := range pinyin.LazyConvert(countyName, nil) {
-				spellCountyName += v
-			}
+	return nil
+}
 
-			county := &TreeRegionInfo{
-				RegionInfo: RegionInfo{
-					Url_:      urlfind.FindString(cinfo),
-					Code_:     codefind.FindString(cinfo),
-					Name_:     countyName,
-					Spell_:    fmt.Sprintf("%s,%s,%s", info.Spell_, regionInfo.Spell_, spellCountyName),
-					FullName_: fmt.Sprintf("%s,%s,%s", info.Name_, cityName, countyName),
-				},
-			}
-			regionInfo.Regions[spellCountyName] = county
-		}
-		info.Regions[spellCityName] = regionInfo
-	}
-	if cinfos, ok := info.Regions[info.Spell_]; ok {
-		if county_info, had := cinfos.Regions[info.Spell_]; had {
-			info.Code_ = county_info.Code_
-		}
-	}
+// indexRegions rebuilds all the indexes of the region tree, regionMu must be held
+func (c *Weather) indexRegions() {
+	c.indexRegionAliases()
+	c.indexRegionCodes()
+	c.indexRegionSearch()
 }
 
-func (c *Weather) ShowCityList(provinceName string) (Resp []byte, err error) {
-	var Jmap = make(map[string]interface{})
-	var g_isOk bool = true
+// ShowCityList returns the cities of a province (or of a "province,city"), all the provinces when provinceName is empty
+func (c *Weather) ShowCityList(provinceName string) (Resp map[string]interface{}, err error) {
+	Resp = make(map[string]interface{})
 	if "" == provinceName {
 		c.regionMu.RLock()
-		pMaps := make(map[string]interface{})
 		for provinceName, provinceValue := range c.treeRegion.Regions {
 			var array []interface{}
 			for _, dist := range provinceValue.Regions {
 				array = append(array, map[string]string{"Name": dist.FullName_, "Spell": provinceValue.Spell_ + "," + dist.Spell_})
 			}
-			pMaps[provinceName] = array
+			Resp[provinceName] = array
 		}
-		Jmap[RESP_DATA_FIELD] = pMaps
 		c.regionMu.RUnlock()
-	} else {
-		names := strings.Split(provinceName, STR_SEP)
-		var spellParams []string = make([]string, 0)
+		return Resp, nil
+	}
 
-		for i := 0; i < len(names); i++ {
-			var spellStrCity = ""
-			for _, v := range pinyin.LazyConvert(names[i], nil) {
-				spellStrCity += v
-			}
-			spellParams = append(spellParams, spellStrCity)
-		}
+	names := strings.Split(provinceName, STR_SEP)
 
-		for k, v := range spellParams {
-			if "" == v {
-				spellParams[k] = names[k]
-			}
+	c.regionMu.RLock()
+	defer c.regionMu.RUnlock()
+	province, isOk := c.treeRegion.child(names[0])
+	if !isOk {
+		return nil, ErrCityNotFound
+	}
+	var array []interface{}
+	if len(names) >= 2 {
+		dist, isOk := province.child(names[1])
+		if !isOk {
+			return nil, ErrCityNotFound
 		}
-
-		c.regionMu.RLock()
-		if province, isOk := c.treeRegion.Regions[spellParams[0]]; isOk {
-			Pmap := make(map[string]interface{})
-			var array []interface{}
-			if len(spellParams) >= 2 {
-				if dist, isOk := province.Regions[spellParams[1]]; isOk {
-					for _, dist := range dist.Regions {
-						array = append(array, map[string]string{"Name": dist.FullName_, "Spell": dist.Spell_})
-					}
-				} else {
-					g_isOk = false
-				}
-				Pmap[spellParams[0]+","+spellParams[1]] = array
-			} else {
-				for _, dist := range province.Regions {
-					array = append(array, map[string]string{"Name": dist.FullName_, "Spell": province.Spell_ + "," + dist.Spell_})
-				}
-				Pmap[provinceName] = array
-			}
-			Jmap[RESP_DATA_FIELD] = Pmap
+		for _, dist := range dist.Regions {
+			array = append(array, map[string]string{"Name": dist.FullName_, "Spell": dist.Spell_, "Code": dist.Code_})
 		}
-		c.regionMu.RUnlock()
-		goto RETURN
-	}
-
-RETURN:
-	if !g_isOk {
-		Jmap[RESP_DATA_FIELD] = nil
-		Jmap[RESP_RCODE_FIELD] = http.StatusGone
-		Jmap[RESP_RMSG_FIELD] = http.StatusText(http.StatusGone)
+		Resp[province.Spell_+","+dist.Spell_] = array
 	} else {
-		Jmap[RESP_RCODE_FIELD] = http.StatusOK
-		Jmap[RESP_RMSG_FIELD] = http.StatusText(http.StatusOK)
-	}
-	Resp, _ = json.Marshal(Jmap)
-	return Resp, err
-}
-
-func (c *Weather) ShowCityWeather(province, district, city string) (Resp *WeatherInfo, err error) {
-	if "" == province {
-		return nil, errors.New("bad parameter")
-	}
-	if "" == district {
-		district = province
-		city = province
-	}
-	if "" == city {
-		city = district
-	}
-	c.regionMu.RLock()
-	var cityinfo RegionInfo
-	if province, h1 := c.treeRegion.Regions[province]; h1 { /*TODO: make it recursion*/
-		if dist, h2 := province.Regions[district]; h2 {
-			if city, h3 := dist.Regions[city]; h3 {
-				cityinfo = city.RegionInfo
-			}
+		for _, dist := range province.Regions {
+			array = append(array, map[string]string{"Name": dist.FullName_, "Spell": province.Spell_ + "," + dist.Spell_})
 		}
+		Resp[provinceName] = array
 	}
-	c.regionMu.RUnlock()
-	if "" == cityinfo.Code_ || "" == cityinfo.Url_ {
-		return nil, errors.New("not found this city")
-	}
+	return Resp, nil
+}
 
-	resp, has := c.getWeatherInfoForCache(cityinfo.Code_)
+// ShowCityWeather returns the weather of the region named by path, see ResolveRegion.
+// When the refresh fails the old data is returned together with an error matching ErrStaleData.
+func (c *Weather) ShowCityWeather(path ...string) (Resp *WeatherInfo, err error) {
+	return c.ShowCityWeatherContext(context.Background(), path...)
+}
 
-	if has && !timeCheckNew(resp.getime_, float64(UPDATE_WEATHERINFO_GAP_MINUTES)) {
-		var now = time.Now()
-		resp.ServerTime_ = now.Format("2006-01-02 15:04:05")
-		lunar := calendar.ByTimestamp(now.Unix())
-		resp.Lunar_ = fmt.Sprintf("%s年(%s) %s月 %s日 %s时", lunar.Ganzhi.YearGanzhiAlias(), lunar.Lunar.Animal().Alias(), lunar.Ganzhi.MonthGanzhiAlias(), lunar.Ganzhi.DayGanzhiAlias(), lunar.Ganzhi.HourGanzhiAlias())
+// ShowCityWeatherContext is ShowCityWeather, the upstream requests give up once ctx is done
+func (c *Weather) ShowCityWeatherContext(ctx context.Context, path ...string) (Resp *WeatherInfo, err error) {
+	cityinfo, err := c.ResolveRegion(path...)
+	if err != nil {
+		return nil, err
+	}
+	return c.showRegionWeather(ctx, cityinfo)
+}
 
-		if !timeCheckNew(resp.curGetTime_, 3) { //最小间隔
-			//查询当前信息
-			GetCurrentWeatherInfo(cityinfo.Code_, fmt.Sprintf(CURRENT_INFO_API, cityinfo.Code_, time.Now().Nanosecond()), resp)
-			resp.curGetTime_ = time.Now()
-			c.addWeatherInfoToCache(cityinfo.Code_, resp)
+// showRegionWeather returns the cached weather of cityinfo, refreshes it when it is out of date
+func (c *Weather) showRegionWeather(ctx context.Context, cityinfo RegionInfo) (Resp *WeatherInfo, err error) {
+	if resp, has := c.weatherlru.Get(cityinfo.Code_); has {
+		if _, recent := c.currentlru.Peek(cityinfo.Code_); !recent { //最小间隔
+			//查询当前信息，同一城市同时只查询一次
+			v, err := c.coalesce(ctx, &c.currentFlight, cityinfo.Code_, func(ctx context.Context) (interface{}, error) {
+				return c.refreshCurrentInfo(ctx, cityinfo.Code_, resp), nil
+			})
+			if cur, ok := v.(*WeatherInfo); nil == err && ok {
+				resp = cur
+			}
 		}
-		return resp, nil
+		return withServerTime(resp), nil
 	}
+	resp, _, has := c.weatherlru.Stale(cityinfo.Code_)
 	if has {
-		log.Printf("last update time：%s  %s\n", resp.FullName_, resp.getime_.Format(time.RFC3339))
-	}
-	//if newResp, err := c.get7DaysWeatherInfoByCity(cityinfo, !has); nil == err {
-	if newResp, err := c.get7DaysWeatherInfoByCityNew(cityinfo, !has); nil == err {
-		var now = time.Now()
-		newResp.ServerTime_ = now.Format("2006-01-02 15:04:05")
-		lunar := calendar.ByTimestamp(now.Unix())
-		newResp.Lunar_ = fmt.Sprintf("%s年(%s) %s月 %s日 %s时", lunar.Ganzhi.YearGanzhiAlias(), lunar.Lunar.Animal().Alias(), lunar.Ganzhi.MonthGanzhiAlias(), lunar.Ganzhi.DayGanzhiAlias(), lunar.Ganzhi.HourGanzhiAlias())
-		return newResp, err
+		log.Printf("weather data of %s is out of date\n", resp.FullName_)
+	}
+	//同一城市同时只抓取一次，其余请求等待它的结果，结果由所有等待者共享
+	v, err := c.coalesce(ctx, &c.weatherFlight, cityinfo.Code_, func(ctx context.Context) (interface{}, error) {
+		return c.fetchWeatherInfo(ctx, cityinfo)
+	})
+	if newResp, ok := v.(*WeatherInfo); nil == err && ok {
+		return withServerTime(newResp), nil
 	} else if has {
 		log.Printf("update failed, return the old weather data of [%s]", resp.FullName_)
-		return resp, nil
+		return resp, fmt.Errorf("%w: %w", ErrStaleData, err)
 	} else {
 		return nil, err
 	}
 
 }
 
-func timeCheck(dataTime time.Time) (ok bool) {
-	dur := time.Now().Sub(dataTime)
-	return dur.Minutes() >= float64(UPDATE_WEATHERINFO_GAP_MINUTES)
-}
-
-func timeCheckNew(dataTime time.Time, gasp float64) (ok bool) {
-	dur := time.Now().Sub(dataTime)
-	return dur.Minutes() >= gasp
+// withServerTime returns a copy of the cached r with the server time and the lunar date of now,
+// the cached value is shared by all the requests
+func withServerTime(r *WeatherInfo) *WeatherInfo {
+	resp := *r
+	var now = time.Now()
+	resp.ServerTime_ = now.Format("2006-01-02 15:04:05")
+	lunar := calendar.ByTimestamp(now.Unix())
+	resp.Lunar_ = fmt.Sprintf("%s年(%s) %s月 %s日 %s时", lunar.Ganzhi.YearGanzhiAlias(), lunar.Lunar.Animal().Alias(), lunar.Ganzhi.MonthGanzhiAlias(), lunar.Ganzhi.DayGanzhiAlias(), lunar.Ganzhi.HourGanzhiAlias())
+	return &resp
 }
 
-func (c *Weather) get7DaysWeatherInfoByCity(cityinfo RegionInfo, isFirst bool) (Resp *WeatherInfo, err error) {
-
-	req, err := http.NewRequest("GET", WEATHER_SITE+cityinfo.Url_, nil)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	handle := &http.Client{Timeout: 10 * time.Second}
-	resp, err := handle.Do(req)
-	if nil != err || resp.StatusCode != http.StatusOK {
-		log.Println(req.URL, resp.Status)
-		return
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+// fetchWeatherInfo gets the whole weather of a city from the provider and puts it into the cache
+func (c *Weather) fetchWeatherInfo(ctx context.Context, cityinfo RegionInfo) (*WeatherInfo, error) {
+	SevenDaysWeatherInfo, err := c.provider.SevenDays(ctx, cityinfo)
 	if err != nil {
-		log.Println("read resp.body error", err)
-		return
-	}
-
-	/*save to file*/
-	//ioutil.WriteFile(fmt.Sprintf("weather_file/%s_%s.shtml", cityinfo.FullName_, cityinfo.Code_), body, 0644)
-
-	day7_start_re := regexp.MustCompile(REGEXP_WEATHER_DAY7_START)
-	live_index_re := regexp.MustCompile(REGEXP_LIVE_INDEX_START)
-	sport_star_re := regexp.MustCompile(REGEXT_GET_SPORT_STAR)
-	wordfind_re := regexp.MustCompile(REGEXP_GET_WORD)
-	numfind_re := regexp.MustCompile(REGEXP_GET_WEATHER_NUM)
-	end_re := regexp.MustCompile(REGEXP_WEATHER_END)
-	//airOn_re := regexp.MustCompile("过去24小时AQI最高值: \\d+")
-
-	day7_start_index := day7_start_re.FindStringIndex(string(body))
-	day7_end_index := end_re.FindStringIndex(string(body[day7_start_index[0]:]))
-	weather_str := body[day7_start_index[1] : day7_start_index[0]+day7_end_index[1]]
-	dayInfos := strings.Split(string(weather_str), DAY_INFO_SPLIT_SEP)
-
-	SevenDaysWeatherInfo := &WeatherInfo{
-		Name_:     cityinfo.Name_,
-		FullName_: cityinfo.FullName_,
-		Code_:     cityinfo.Code_,
-		Url_:      cityinfo.Url_,
-		Spell_:    cityinfo.Spell_,
+		return nil, err
 	}
 
 	//查询当前信息
-	GetCurrentWeatherInfo(cityinfo.Code_, fmt.Sprintf(CURRENT_INFO_API, cityinfo.Code_, time.Now().Nanosecond()), SevenDaysWeatherInfo)
-	SevenDaysWeatherInfo.curGetTime_ = time.Now()
-
-	/*parse 7days weather*/
-	uptime := numfind_re.FindAllString(string(body[day7_start_index[0]-30:day7_start_index[0]]), 2)
-	SevenDaysWeatherInfo.UpdateTime_ = fmt.Sprintf("%s:%s", uptime[0], uptime[1])
-	var localWeatherIndex int = 0
-	for _, dayInfo := range dayInfos {
-		var brief_weather_info = &BriefWeatherInfo{}
-		reader := bufio.NewReader(strings.NewReader(dayInfo))
-		for line := 0; ; line++ {
-			value, _, err := reader.ReadLine()
-			if err != nil {
-				break
-			}
-			switch line {
-			case 2: //date
-				brief_weather_info.Date_ = string(value[4 : len(value)-5])
-			case 5: //sun
-				brief_weather_info.Sun_ = wordfind_re.FindString(string(value))
-			case 7: //temperature
-				temp := numfind_re.FindAllString(string(value), 2)
-				if len(temp) >= 2 {
-					brief_weather_info.Temperature_[0], _ = strconv.Atoi(temp[1])
-					brief_weather_info.Temperature_[1], _ = strconv.Atoi(temp[0])
-				} else {
-					brief_weather_info.Temperature_[0], _ = strconv.Atoi(temp[0])
-					brief_weather_info.Temperature_[1], _ = strconv.Atoi(temp[0])
-				}
-			case 11: //windy-1
-				brief_weather_info.Wind_.From_ = wordfind_re.FindString(string(value))
-			case 12: //windy-2
-				brief_weather_info.Wind_.To_ = wordfind_re.FindString(string(value))
-			case 13, 14: //windy-level
-				lven := len(value)
-				if lven < 8 {
-					continue
-				}
-				brief_weather_info.Wind_.Level_ = string(value[3:(lven - 4)])
-				break
-			}
-		}
-
-		SevenDaysWeatherInfo.Weather_[localWeatherIndex] = brief_weather_info
-		localWeatherIndex++
-		if localWeatherIndex >= 7 {
-			break
-		}
-	}
-	/*parse live index*/
-	live_index_start := live_index_re.FindStringIndex(string(body))
-	live_index_end := end_re.FindStringIndex(string(body[live_index_start[0]:]))
-	live_index_str := body[live_index_start[1] : live_index_start[0]+live_index_end[0]]
-	liveIndexInfos := strings.Split(string(live_index_str), DAY_INFO_SPLIT_SEP)
-	//airOnStr := airOn_re.FindString(string(body[live_index_end[1]:]))
-	// fmt.Printf("AQI: ")
-	// if "" != airOnStr {
-	// 	fmt.Printf("%s\n", airOnStr)
-	// } else {
-	// 	fmt.Printf("无\n")
-	// }
-	var localLiveIndex int = 0
-	for _, liveInfo := range liveIndexInfos {
-		lines := strings.SplitAfter(liveInfo, "\n")
-		linesLen := len(lines) - 1
-		if linesLen < 4 {
-			continue
-		}
-		star_count := len(sport_star_re.FindAllIndex([]byte(liveInfo), -1))
-		lines = lines[:linesLen]
-		linesLen -= 1
-		var info = &LiveIndexInfo{}
-		field_index := 0
-		for i := 0; i < linesLen; i++ {
-			strLive := lines[linesLen-i]
-			strLive = strings.TrimSuffix(strLive, "\n")
-			line_len := len(strLive)
-			ephpmeral := wordfind_re.FindString(strLive)
-			if "" == ephpmeral && i != 0 {
-				for j := 0; j < star_count; j++ {
-					if 0 != j {
-						info.Level_ += " "
-					}
-					info.Level_ += "☆"
-				}
-				break
-			} else if "" == ephpmeral && 0 == i {
-				continue
-			}
-			switch field_index {
-			case 0:
-				info.Tips = strLive[3 : line_len-4]
-			case 1:
-				info.Name_ = strLive[4 : line_len-5]
-				if 183 == info.Name_[7] {
-					info.Name_ = info.Name_[8:]
-				}
-			case 2:
-				info.Level_ = ephpmeral
-			}
-			field_index++
-		}
-		SevenDaysWeatherInfo.LiveIndex_[localLiveIndex] = info
-		localLiveIndex++
-		if localLiveIndex >= 6 {
-			break
-		}
-	}
-	timeNow := time.Now()
-	if isFirst {
-		hour, _ := strconv.Atoi(uptime[0])
-		min, _ := strconv.Atoi(uptime[1])
-		SevenDaysWeatherInfo.getime_ = time.Date(timeNow.Year(), timeNow.Month(), timeNow.Day(), hour, min, 0, 0, timeNow.Location())
-	} else {
-		SevenDaysWeatherInfo.getime_ = timeNow
-	}
+	c.updateCurrentInfo(ctx, cityinfo.Code_, SevenDaysWeatherInfo)
 
 	//查询是需要获取告警信息
 	locations, ok := GetLocationInfoByID(cityinfo.Code_)
 	if ok {
 		SevenDaysWeatherInfo.AlarmInfo_ = SevenDaysWeatherInfo.AlarmInfo_[:0]
 		for _, v := range locations {
-			GetAlarmDetails(ALARM_DETAILS+v.FileName, SevenDaysWeatherInfo)
+			if err := c.provider.Alarm(ctx, v.FileName, SevenDaysWeatherInfo); err != nil {
+				log.Println("get alarm details failed", v.FileName, err)
+			}
 		}
 	}
-	c.addWeatherInfoToCache(cityinfo.Code_, SevenDaysWeatherInfo)
-	return SevenDaysWeatherInfo, err
+	c.addWeatherInfoToCache(cityinfo.Code_, SevenDaysWeatherInfo, c.weatherlru.TTL)
+	return SevenDaysWeatherInfo, nil
+}
+
+// coalesce runs fn once for all the concurrent callers of key in g and counts the callers that shared a result.
+// A caller whose ctx is done gets an error matching ErrUpstreamUnavailable.
+func (c *Weather) coalesce(ctx context.Context, g *singleflight.Group, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	v, err, shared := g.Do(ctx, key, fn)
+	if shared {
+		atomic.AddInt64(&c.ncoalesced, 1)
+	}
+	if nil != err && err == ctx.Err() {
+		err = fmt.Errorf("%w: %s: %w", ErrUpstreamUnavailable, key, err)
+	}
+	return v, err
+}
+
+// refreshCurrentInfo refreshes the current weather of the cached old into a copy and caches the copy in its place,
+// the cached value is shared by other requests and the cache snapshots, it is never changed
+func (c *Weather) refreshCurrentInfo(ctx context.Context, code string, old *WeatherInfo) *WeatherInfo {
+	cur := *old
+	c.updateCurrentInfo(ctx, code, &cur)
+	/*期间被整个刷新的数据更新，不再替换*/
+	c.weatherlru.Update(code, func(v *WeatherInfo) (*WeatherInfo, bool) {
+		return &cur, v == old
+	})
+	c.publishCurrent(code, old.CurrentInfo, cur.CurrentInfo)
+	return &cur
+}
+
+// updateCurrentInfo refreshes the current weather and the hourly predict of r,
+// a cancelled request does not delay the next refresh
+func (c *Weather) updateCurrentInfo(ctx context.Context, code string, r *WeatherInfo) {
+	if err := c.provider.Current(ctx, code, r); err != nil {
+		log.Println("get current weather failed", code, err)
+	} else if err := c.provider.Hours(ctx, code, r); err != nil {
+		log.Println("get hourly weather failed", code, err)
+	}
+	if nil == ctx.Err() {
+		c.currentlru.Add(code, struct{}{})
+	}
 }
 
 /*
@@ -584,102 +410,54 @@ func implTraversal(region *TreeRegionInfo) {
 	}
 }
 
+// Stats returns the usage of every cache, the top level counters are the ones of the weather cache
 func (c *Weather) Stats() CacheStats {
-	c.weatherMu.RLock()
-	defer c.weatherMu.RUnlock()
-	return CacheStats{
-		Bytes:       c.nbytes,
-		Items:       c.itemsLocked(),
-		Gets:        c.nget,
-		Hits:        c.nhit,
-		Evictions:   c.nevict,
+	weather := cacheUsage(c.weatherlru)
+	stats := CacheStats{
+		Bytes:       weather.Bytes,
+		Items:       weather.Items,
+		Gets:        weather.Gets,
+		Hits:        weather.Hits,
+		Evictions:   weather.Evictions,
+		Expired:     weather.Expired,
+		Coalesced:   atomic.LoadInt64(&c.ncoalesced),
 		RefreshRate: UPDATE_WEATHERINFO_GAP_MINUTES,
+		Caches: map[string]CacheUsage{
+			"weather": weather,
+			"forty":   cacheUsage(c.fortydayslru),
+			"current": cacheUsage(c.currentlru),
+		},
+	}
+	if p, ok := c.provider.(*WeatherCom); ok {
+		stats.Breakers = p.upstream().Breakers()
 	}
+	return stats
 }
 
-func (c *Weather) addWeatherInfoToCache(key string, value *WeatherInfo) {
-	c.weatherMu.Lock()
-	defer c.weatherMu.Unlock()
-	if c.weatherlru.OnEvicted == nil {
-		c.weatherlru.OnEvicted = func(key lrucache.Key, value interface{}) {
-			val := value.(*WeatherInfo)
-			c.nbytes -= int64(len(key.(string))) + int64(unsafe.Sizeof(val))
-			c.nevict++
-		}
+func cacheUsage[K comparable, V any](lru *lrucache.Cache[K, V]) CacheUsage {
+	s := lru.Stats()
+	return CacheUsage{
+		Bytes:     s.Bytes,
+		MaxBytes:  lru.MaxBytes,
+		Items:     s.Items,
+		Gets:      s.Gets,
+		Hits:      s.Hits,
+		Evictions: s.Evictions,
+		Expired:   s.Expired,
 	}
-	c.weatherlru.Add(key, value)
-	c.nbytes += int64(len(key)) + int64(unsafe.Sizeof(value))
 }
 
-func (c *Weather) getWeatherInfoForCache(key string) (value *WeatherInfo, ok bool) {
-	c.weatherMu.RLock()
-	defer c.weatherMu.RUnlock()
-	c.nget++
-	if c.weatherlru == nil {
-		return
-	}
-	vi, ok := c.weatherlru.Get(key)
-	if !ok {
-		return
-	}
-	c.nhit++
-	return vi.(*WeatherInfo), true
+// addWeatherInfoToCache caches the weather of a city for ttl, it is kept WEATHER_STALE_KEEP longer as stale data
+func (c *Weather) addWeatherInfoToCache(key string, value *WeatherInfo, ttl time.Duration) {
+	old, _, _ := c.weatherlru.Stale(key)
+	c.weatherlru.AddWithTTL(key, value, ttl)
+	c.publishWeather(key, old, value)
 }
 
 func (c *Weather) RemoveOldest() {
-	c.weatherMu.Lock()
-	defer c.weatherMu.Unlock()
-	if c.weatherlru != nil {
-		c.weatherlru.RemoveOldest()
-	}
+	c.weatherlru.RemoveOldest()
 }
 
 func (c *Weather) items() int64 {
-	c.weatherMu.RLock()
-	defer c.weatherMu.RUnlock()
-	return c.itemsLocked()
-}
-
-func (c *Weather) itemsLocked() int64 {
-	if c.weatherlru == nil {
-		return 0
-	}
 	return int64(c.weatherlru.Len())
 }
-
-func (c *Weather) saveRegionData(path string) error {
-	err := os.Remove(path)
-	if err != nil {
-		log.Println(err)
-	}
-
-	saveTo, err := os.Create(path)
-	if err != nil {
-		log.Println("Cannot create", path, err)
-		return err
-	}
-	defer saveTo.Close()
-
-	encoder := gob.NewEncoder(saveTo)
-	err = encoder.Encode(c.treeRegion)
-	if err != nil {
-		log.Println("Cannot save to", path, err)
-		return err
-	}
-	return nil
-}
-
-func (c *Weather) loadRegionData(path string) error {
-	loadFrom, err := os.Open(path)
-	defer loadFrom.Close()
-	if err != nil {
-		log.Println("Load region data from ", path, "failed", err)
-		return err
-	}
-
-	decoder := gob.NewDecoder(loadFrom)
-	if nil == c.treeRegion {
-		c.treeRegion = &TreeRegionInfo{Regions: make(map[string]*TreeRegionInfo)}
-	}
-	return decoder.Decode(c.treeRegion)
-}
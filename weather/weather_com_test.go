@@ -0,0 +1,255 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var shanghai = RegionInfo{
+	Name_:     "上海",
+	FullName_: "上海,上海,上海",
+	Code_:     "101020100",
+	Url_:      "/weather/101020100.shtml",
+	Spell_:    "shanghai,shanghai,shanghai",
+}
+
+func day(date, sun string, low, high int, from, to, level, sunrise, sunset string) *BriefWeatherInfo {
+	return &BriefWeatherInfo{
+		Date_:        date,
+		Sun_:         sun,
+		Temperature_: [2]int{low, high},
+		Wind_:        Wind{From_: from, To_: to, Level_: level},
+		Turn_:        Turn{Sunrise: sunrise, Sunset: sunset},
+	}
+}
+
+func hour(weather string, temp int, direction, level string, y, m, d, h int) HourInfos {
+	return HourInfos{Weather: weather, Temp: temp, WindDirection: direction, WindLevel: level, Year: y, Month: m, Day: d, Hour: h}
+}
+
+func TestSevenDays(t *testing.T) {
+	got, err := newFixtureWeatherCom().SevenDays(context.Background(), shanghai)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &WeatherInfo{
+		Code_:       "101020100",
+		Url_:        "/weather/101020100.shtml",
+		Name_:       "上海",
+		Spell_:      "shanghai,shanghai,shanghai",
+		FullName_:   "上海,上海,上海",
+		UpdateTime_: "11:30",
+		LiveIndex_: [LIVE_INDEX_INFO_COUNT]*LiveIndexInfo{
+			{Name_: "感冒指数", Level_: "少发", Stars_: "☆", Tips: "各项气象条件适宜，发生感冒机率较低。"},
+			{Name_: "运动指数", Level_: "较不宜", Stars_: "☆☆", Tips: "天气较热，建议停止户外运动。"},
+			{Name_: "过敏指数", Level_: "不易发", Stars_: "☆", Tips: "除特殊体质，无需担心过敏问题。"},
+			{Name_: "穿衣指数", Level_: "炎热", Stars_: "☆☆☆☆☆", Tips: "建议着短衫、短裙、短裤等清凉夏季服装。"},
+			{Name_: "洗车指数", Level_: "较适宜", Stars_: "☆☆☆", Tips: "无雨且风力较小，易保持清洁度。"},
+			{Name_: "紫外线指数", Level_: "强", Stars_: "☆☆☆☆", Tips: "涂擦SPF大于15、PA+防晒护肤品。"},
+		},
+		/*页面的脚本数组第一项为昨天(04:59/19:02)，与温度一样跳过*/
+		Weather_: [WEATHER_DAYS]*BriefWeatherInfo{
+			day("今天(12日)", "多云", 28, 34, "东南风", "南风", "小于3级", "05:00", "19:01"),
+			day("明天(13日)", "多云转晴", 28, 35, "南风", "南风", "3-4级", "05:01", "19:00"),
+			day("周日(14日)", "晴", 29, 36, "西南风", "南风", "小于3级", "05:02", "19:00"),
+			day("周一(15日)", "阵雨", 27, 33, "东风", "东北风", "3-4级", "05:02", "19:00"),
+			day("周二(16日)", "小雨", 26, 31, "北风", "东北风", "4-5级", "05:03", "18:59"),
+			day("周三(17日)", "阴转多云", 26, 32, "东风", "东风", "小于3级", "05:04", "18:59"),
+			day("周四(18日)", "多云", 27, 33, "东南风", "东风", "3-4级", "05:04", "18:58"),
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SevenDays()\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestSevenDaysMalformed(t *testing.T) {
+	p := newFixtureWeatherCom()
+	for _, tc := range []struct {
+		code    string
+		section string
+	}{
+		{"101020200", "weather"},     /*页面在天气列表中截断*/
+		{"101020300", "temperature"}, /*脚本中缺少夜间温度*/
+	} {
+		city := shanghai
+		city.Code_ = tc.code
+		city.Url_ = "/weather/" + tc.code + ".shtml"
+		got, err := p.SevenDays(context.Background(), city)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("SevenDays(%s) = %v, %v; want a *ParseError", tc.code, got, err)
+			continue
+		}
+		if perr.Section != tc.section {
+			t.Errorf("SevenDays(%s) failed in %q, want %q", tc.code, perr.Section, tc.section)
+		}
+		if !errors.Is(err, ErrUpstreamParse) || ErrorCode(err) != ECODE_UPSTREAM_PARSE {
+			t.Errorf("SevenDays(%s) = %v, want an error matching ErrUpstreamParse", tc.code, err)
+		}
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	var got WeatherInfo
+	if err := newFixtureWeatherCom().Current(context.Background(), "101020100", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := WeatherInfo{
+		CurrentInfo: BriefCurrentWeatherInfo{
+			Temperature:   "30.6",
+			TemperatureF:  "87.1",
+			WindDirection: "东南风",
+			WindLevel:     "2级",
+			WindSpeed:     "9km/h",
+			Humidity:      "67%",
+			Pressure:      "1004",
+			Visibility:    "18km",
+			Time:          "14:25",
+			AirQuality:    "38",
+			Weather:       "多云",
+			Date:          "07月12日(星期五)",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Current()\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestHours(t *testing.T) {
+	var got WeatherInfo
+	if err := newFixtureWeatherCom().Hours(context.Background(), "101020100", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]HourInfos{
+		{
+			hour("多云", 34, "东南风", "小于3级", 2024, 7, 12, 14),
+			hour("多云", 33, "东南风", "小于3级", 2024, 7, 12, 17),
+			hour("阴", 30, "南风", "小于3级", 2024, 7, 12, 20),
+			hour("阴", 29, "南风", "小于3级", 2024, 7, 12, 23),
+		},
+		{
+			hour("多云", 28, "南风", "小于3级", 2024, 7, 13, 2),
+			hour("晴", 29, "南风", "3-4级", 2024, 7, 13, 5),
+			hour("晴", 33, "南风", "3-4级", 2024, 7, 13, 8),
+			hour("多云", 35, "西南风", "3-4级", 2024, 7, 13, 11),
+		},
+		{
+			hour("晴", 34, "西南风", "3-4级", 2024, 7, 13, 14),
+			hour("阵雨", 32, "西南风", "4-5级", 2024, 7, 13, 17),
+			hour("小雨", 29, "北风", "小于3级", 2024, 7, 13, 20),
+			hour("阴", 28, "无持续风向", "小于3级", 2024, 7, 13, 23),
+		},
+	}
+	if !reflect.DeepEqual(got.HoursPredict_, want) {
+		t.Errorf("Hours()\n got %+v\nwant %+v", got.HoursPredict_, want)
+	}
+}
+
+func TestHoursMalformed(t *testing.T) {
+	p := newFixtureWeatherCom()
+	for _, code := range []string{
+		"101020200", /*只有两天的逐时数据*/
+		"101020300", /*时间字段太短*/
+		"101020400", /*脚本中没有赋值*/
+	} {
+		var got WeatherInfo
+		err := p.Hours(context.Background(), code, &got)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Section != "hours" {
+			t.Errorf("Hours(%s) = %v, want a *ParseError of hours", code, err)
+		}
+		if nil != got.HoursPredict_ {
+			t.Errorf("Hours(%s) filled %+v", code, got.HoursPredict_)
+		}
+	}
+}
+
+func TestFortyDays(t *testing.T) {
+	var got []FortyDaysInfo
+	p := newFixtureWeatherCom()
+	if err := p.FortyDays(context.Background(), 2024, 7, "101020100", &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.FortyDays(context.Background(), 2024, 8, "101020100", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []FortyDaysInfo{
+		{Date: "20240711", Week: "周四", Ripe: "嫁娶.出行", Avoid: "动土.安葬", Lunar: "六月 初六",
+			Weather: "雷阵雨转多云", WCodeOne: "04", WCodeTwo: "01", Wind: "小于3级",
+			HTemp: "32", MTemp: "27", HMax: "33", HMin: "26", HRate: "45%", HRain: "12.3"},
+		{Date: "20240712", Week: "周五", Ripe: "祭祀.祈福", Avoid: "开市", Lunar: "六月 初七",
+			Weather: "多云", WCodeOne: "01", WCodeTwo: "01", Wind: "3-4级",
+			HTemp: "34", MTemp: "28", HMax: "34", HMin: "26", HRate: "13%"},
+		{Date: "20240715", Week: "周一", Ripe: "订盟", Avoid: "破土", Lunar: "六月 初十", SubSolarTerm: "初伏第1天",
+			Weather: "晴", WCodeOne: "00", WCodeTwo: "00", Wind: "小于3级",
+			HTemp: "36", MTemp: "29", HMax: "35", HMin: "27", HRate: "10%"},
+		{Date: "20240801", Week: "周四", Lunar: "六月 廿七", Festival: "建军节",
+			Weather: "小雨", WCodeOne: "07", WCodeTwo: "01", Wind: "小于3级",
+			HTemp: "33", MTemp: "27", HMax: "35", HMin: "26", HRate: "38%"},
+		{Date: "20240807", Week: "周三", Lunar: "七月 初四", SolarTerm: "立秋",
+			Weather: "多云", Wind: "3-4级",
+			HTemp: "31", MTemp: "26", HMax: "34", HMin: "25", HRate: "30%"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FortyDays()\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestAlarm(t *testing.T) {
+	var got WeatherInfo
+	if err := newFixtureWeatherCom().Alarm(context.Background(), "101020100-20240712103000-0702.html", &got); err != nil {
+		t.Fatal(err)
+	}
+	want := WeatherInfo{
+		Alarm_: true,
+		AlarmInfo_: []AlarmDetails{{
+			Title:       "高温橙色预警",
+			Details:     "上海中心气象台2024年7月12日10时30分发布高温橙色预警：今天本市大部地区最高气温将达37℃以上，请注意防暑降温。",
+			Standard:    "24小时内最高气温将升至37℃以上。",
+			Manual:      "1.有关部门和单位按照职责落实防暑降温保障措施；2.尽量避免在高温时段进行户外活动。",
+			TypeCode:    "07",
+			LevelCode:   "02",
+			SignalType:  "高温",
+			SignalLevel: "橙色",
+			IssueTime:   "2024-07-12 10:30",
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Alarm()\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestAlarmList(t *testing.T) {
+	got, err := newFixtureWeatherCom().getAlarmList(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]Location{
+		"101020100": {{Name: "上海市中心气象台", FileName: "101020100-20240712103000-0702.html", Longitude: "121.47", Latitude: "31.23", Code: "31", Code2: "0"}},
+		"10102090":  {{Name: "松江区气象台", FileName: "10102090-20240712110000-0701.html", Longitude: "121.22", Latitude: "31.03", Code: "31", Code2: "0"}},
+		"101270101": {{Name: "成都市气象台", FileName: "101270101-20240712080000-0203.html", Longitude: "104.06", Latitude: "30.67", Code: "51", Code2: "0"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getAlarmList()\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestMissingPage(t *testing.T) {
+	p := newFixtureWeatherCom()
+	missing := shanghai
+	missing.Code_ = "101999999"
+	missing.Url_ = "/weather/101999999.shtml"
+	if _, err := p.SevenDays(context.Background(), missing); !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("SevenDays() of a missing page = %v, want ErrUpstreamUnavailable", err)
+	}
+	var r WeatherInfo
+	if err := p.Current(context.Background(), missing.Code_, &r); !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("Current() of a missing page = %v, want ErrUpstreamUnavailable", err)
+	}
+	if err := p.Hours(context.Background(), missing.Code_, &r); !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("Hours() of a missing page = %v, want ErrUpstreamUnavailable", err)
+	}
+}
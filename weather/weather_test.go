@@ -0,0 +1,63 @@
+package weather
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestCurrentRefreshCopies makes sure the refresh of the current weather never changes a cached value,
+// which is shared by the other requests and the cache snapshots
+func TestCurrentRefreshCopies(t *testing.T) {
+	c := New(0, newFixtureWeatherCom())
+	defer c.Close()
+	ctx := context.Background()
+	if _, err := c.showRegionWeather(ctx, shanghai); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := c.weatherlru.Peek(shanghai.Code_)
+	old.CurrentInfo.Temperature = "0"
+	expire := c.weatherlru.Snapshot()[0].Expire
+
+	c.currentlru.Remove(shanghai.Code_)
+	got, err := c.showRegionWeather(ctx, shanghai)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if "0" != old.CurrentInfo.Temperature {
+		t.Errorf("the cached value was changed to %s", old.CurrentInfo.Temperature)
+	}
+	if "30.6" != got.CurrentInfo.Temperature {
+		t.Errorf("got the temperature %s, want the refreshed 30.6", got.CurrentInfo.Temperature)
+	}
+	cur, fresh := c.weatherlru.Peek(shanghai.Code_)
+	if cur == old || !fresh || "30.6" != cur.CurrentInfo.Temperature {
+		t.Errorf("the refreshed copy is not cached")
+	}
+	if items := c.weatherlru.Snapshot(); 1 != len(items) || !items[0].Expire.Equal(expire) {
+		t.Errorf("the refresh changed the expiry of the weather from %v", expire)
+	}
+	if want := c.weatherlru.Size(shanghai.Code_, cur); c.weatherlru.Bytes() != want {
+		t.Errorf("cache bytes %d, want %d of the refreshed copy", c.weatherlru.Bytes(), want)
+	}
+
+	/*-race: 刷新与快照同时进行*/
+	path := filepath.Join(t.TempDir(), CACHE_SNAPSHOT_FILE)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			c.currentlru.Remove(shanghai.Code_)
+			c.showRegionWeather(ctx, shanghai)
+		}()
+		go func() {
+			defer wg.Done()
+			if err := c.SaveCache(path); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
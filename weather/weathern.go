@@ -1,113 +1,71 @@
 package weather
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"golang.org/x/net/html"
 	"log"
-	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 )
 
 const (
-	Seven_DAY_INFO_START = "<div class=\"weather_7d\">"
-	Seven_DAY_INFO_END   = "</script>"
-	UPDATE_TIME_FOUND    = "<input type=\"hidden\" id=\"update_time\" value=\"(.*?)\""
-	DATE_CONTAINER_START = "<ul class=\"date-container\">"
-	DATE_CONTAINER_END   = "</ul>"
-	SUN_CONTAINER_START  = "<ul class=\"blue-container sky\">"
-
-	SUN_CONTAINER_END = "<li class=\"drawTwo-container\">"
-	DATE_NUM          = "<p class=\"date\">(.*?)</p>"
-	DATE_NAME         = "<p class=\"date-info\">(.*?)</p>"
-
-	DATE_WEATHER         = "<p class=\"weather-info\" title=\"(.*?)</p>"
-	DATE_WINDY_LEVEL     = "<p class=\"wind-info\">(.*?)</p>"
-	DATE_WINDY_DIRECTION = "<i class=\"wind-icon (.*?)\"></i>"
-
-	LIVE_INDEX_START = "<div class=\"weather_shzs\">"
-	LIVE_INDEX_END   = "</div>\n</div>"
-	LIVE_INDEX_NAME  = "<h2>(.*?)</h2>"
-	LIVE_INDEX_LEVEL = "<em>(.*?)</em>"
-	LIVE_INDEX_TIPS  = "<dd>(.*?)</dd>"
-	LIVE_INDEX_STARS = "(?s)<p[^>]*>(.*?)</p>"
-	STAR             = "☆"
+	SEVEN_DAY_CLASS      = "weather_7d"
+	UPDATE_TIME_ID       = "update_time"
+	DATE_CONTAINER_CLASS = "date-container"
+	SUN_CONTAINER_CLASS  = "blue-container"
+	YESTERDAY_CLASS      = "yestoday"
+	DATE_NUM_CLASS       = "date"
+	DATE_NAME_CLASS      = "date-info"
+	DATE_WEATHER_CLASS   = "weather-info"
+	DATE_WINDY_LEVEL     = "wind-info"
+	DATE_WINDY_DIRECTION = "wind-icon"
+	LIVE_INDEX_CLASS     = "weather_shzs"
+	LIVE_INDEX_STARS     = "star"
+	LIVE_INDEX_ACTIVE    = "active"
+	SCRIPT_ARRAY         = `var\s+%s\s*=\s*(\[[^\]]*\])`
+	STAR                 = "☆"
 )
 
-var ()
+// ParseError is returned when a page does not have the expected structure
+type ParseError struct {
+	Page    string /*页面地址*/
+	Section string /*出错的区块*/
+	Reason  string /*原因*/
+}
 
-func (c *Weather) get7DaysWeatherInfoByCityNew(cityinfo RegionInfo, isFirst bool) (Resp *WeatherInfo, err error) {
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse %s of %s failed: %s", e.Section, e.Page, e.Reason)
+}
 
-	req, err := http.NewRequest("GET", WEATHER_SITE+strings.Replace(cityinfo.Url_, "weather", "weathern", 1), nil)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	handle := &http.Client{Timeout: 10 * time.Second}
-	resp, err := handle.Do(req)
-	if nil != err || resp.StatusCode != http.StatusOK {
-		log.Println(req.URL, resp.Status)
-		return
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+func (p *WeatherCom) get7DaysWeatherInfoByCityNew(ctx context.Context, cityinfo RegionInfo) (Resp *WeatherInfo, err error) {
+
+	pageURL := WEATHER_SITE + strings.Replace(cityinfo.Url_, "weather", "weathern", 1)
+	body, err := p.upstream().Get(ctx, ENDPOINT_SEVEN_DAYS, pageURL)
 	if err != nil {
-		log.Println("read resp.body error", err)
-		return
+		return nil, err
 	}
 
 	/*save to file*/
 	//ioutil.WriteFile(fmt.Sprintf("weather_file/%s_%s.shtml", cityinfo.FullName_, cityinfo.Code_), body, 0644)
-	//找到相关大块
-	day7_start_re := regexp.MustCompile(Seven_DAY_INFO_START)
-	day7_end_re := regexp.MustCompile(Seven_DAY_INFO_END)
-	update_time_re := regexp.MustCompile(UPDATE_TIME_FOUND)
-	date_container_start_re := regexp.MustCompile(DATE_CONTAINER_START)
-
-	date_container_end_re := regexp.MustCompile(DATE_CONTAINER_END)
-	//sun_constainer_start_re := regexp.MustCompile(SUN_CONTAINER_START)
-	//sun_container_end_re := regexp.MustCompile(SUN_CONTAINER_END)
-	live_index_start_re := regexp.MustCompile(LIVE_INDEX_START)
-	live_index_end_re := regexp.MustCompile(LIVE_INDEX_END)
-	//大块中过滤小块
-	//日期
-	date_num_re := regexp.MustCompile(DATE_NUM)
-	date_name_re := regexp.MustCompile(DATE_NAME)
-	//生活指数
-	live_index_name_re := regexp.MustCompile(LIVE_INDEX_NAME)
-	live_index_level_re := regexp.MustCompile(LIVE_INDEX_LEVEL)
-	live_index_tips_re := regexp.MustCompile(LIVE_INDEX_TIPS)
-	live_index_stars_re := regexp.MustCompile(LIVE_INDEX_STARS)
-	//天气
-	date_weather_re := regexp.MustCompile(DATE_WEATHER)
-	date_windy_level := regexp.MustCompile(DATE_WINDY_LEVEL)
-	date_windy_driection := regexp.MustCompile(DATE_WINDY_DIRECTION)
-	//温度与日出日落
-	day7_start_index := day7_start_re.FindStringIndex(string(body))
-	day7_end_index := day7_end_re.FindStringIndex(string(body[day7_start_index[0]:]))
-	weather_str := body[day7_start_index[1] : day7_start_index[0]+day7_end_index[1]]
-
-	dayInfos := strings.Split(string(weather_str), "\r\n")
-	dayInfos = strings.Split(dayInfos[2], "\n")
-	var eventDay = make([]int, 0)
-	var eventNight = make([]int, 0)
-	var sunrise = make([]string, 0)
-	var sunset = make([]string, 0)
-	for i, v := range dayInfos {
-		switch i {
-		case 0: //eventDay
-			eventDay = getTemperatureFromGroup(v)
-		case 1: //eventNight
-			eventNight = getTemperatureFromGroup(v)
-		case 4: //sunup
-			sunrise = getSunsetFromGroup(v)
-		case 5: //sunset
-			sunset = getSunsetFromGroup(v)
-		default:
-			// do nothing
-		}
+	Resp, err = parse7DaysWeatherInfo(pageURL, body, cityinfo)
+	if err != nil {
+		log.Println(err)
+	}
+	return Resp, err
+}
+
+// parse7DaysWeatherInfo extracts the seven days predict and the live index from a weathern page
+func parse7DaysWeatherInfo(page string, body []byte, cityinfo RegionInfo) (*WeatherInfo, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, &ParseError{Page: page, Section: "document", Reason: err.Error()}
+	}
+	fail := func(section, format string, a ...interface{}) (*WeatherInfo, error) {
+		return nil, &ParseError{Page: page, Section: section, Reason: fmt.Sprintf(format, a...)}
 	}
 
 	SevenDaysWeatherInfo := &WeatherInfo{
@@ -118,110 +76,235 @@ func (c *Weather) get7DaysWeatherInfoByCityNew(cityinfo RegionInfo, isFirst bool
 		Spell_:    cityinfo.Spell_,
 	}
 
-	//查询当前信息
-	GetCurrentWeatherInfo(cityinfo.Code_, fmt.Sprintf(CURRENT_INFO_API, cityinfo.Code_, time.Now().Nanosecond()), SevenDaysWeatherInfo)
-	SevenDaysWeatherInfo.curGetTime_ = time.Now()
+	//温度与日出日落
+	day7 := findNode(doc, hasClass(SEVEN_DAY_CLASS))
+	if nil == day7 {
+		return fail("seven days", "no %s block", SEVEN_DAY_CLASS)
+	}
+	script := findNode(day7, isElement("script"))
+	if nil == script {
+		return fail("seven days", "no script in %s block", SEVEN_DAY_CLASS)
+	}
+	scriptText := nodeText(script)
+	eventDay, err := scriptArray(scriptText, "eventDay")
+	if err != nil {
+		return fail("temperature", "%v", err)
+	}
+	eventNight, err := scriptArray(scriptText, "eventNight")
+	if err != nil {
+		return fail("temperature", "%v", err)
+	}
+	sunrise, err := scriptArray(scriptText, "sunup")
+	if err != nil {
+		return fail("sunrise", "%v", err)
+	}
+	sunset, err := scriptArray(scriptText, "sunset")
+	if err != nil {
+		return fail("sunset", "%v", err)
+	}
 
-	/*parse 7days weather*/
 	//更新时间
-	tmpIndex := day7_start_index[1] + day7_end_index[1] + 300
-	uptime := update_time_re.FindStringSubmatch(string(body[(day7_start_index[1] + day7_end_index[0]):tmpIndex]))
-	SevenDaysWeatherInfo.UpdateTime_ = uptime[1]
-
-	//7天具体日期 1500:这段数据大概1500个字符吧
-	dateStr := body[tmpIndex-100 : tmpIndex+1500]
-	s := date_container_start_re.FindAllIndex(dateStr, 1)
-	e := date_container_end_re.FindAllIndex(dateStr, 1)
-	dateStr = dateStr[s[0][0]:e[0][0]]
-	dateNum := date_num_re.FindAllStringSubmatch(string(dateStr), -1)
-	dateName := date_name_re.FindAllStringSubmatch(string(dateStr), -1)
-
-	//天气、风，大概3500字符吧
-	sunStr := body[tmpIndex+800 : tmpIndex+5000]
-	//s = sun_constainer_start_re.FindAllIndex(sunStr, 1)
-	//e = sun_container_end_re.FindAllIndex(sunStr[s[0][0]:], 1)
-	//windInfos := sunStr[s[0][0]:e[0][1]]
-	weatherStrs := date_weather_re.FindAllStringSubmatch(string(sunStr), -1)
-	weatherWindyLevels := date_windy_level.FindAllStringSubmatch(string(sunStr), -1)
-	weatherWindyDirections := date_windy_driection.FindAllStringSubmatch(string(sunStr), -1)
+	uptime := findNode(doc, func(n *html.Node) bool { return isElement("input")(n) && attr(n, "id") == UPDATE_TIME_ID })
+	if nil == uptime {
+		return fail("update time", "no #%s input", UPDATE_TIME_ID)
+	}
+	SevenDaysWeatherInfo.UpdateTime_ = attr(uptime, "value")
 
-	//生活指数
-	s = live_index_start_re.FindAllIndex(body, 1)
-	e = live_index_end_re.FindAllIndex(body[s[0][0]:], 1)
-	liveStr := body[s[0][0] : e[0][0]+s[0][0]]
-	liveNames := live_index_name_re.FindAllStringSubmatch(string(liveStr), 8)
-	liveLevels := live_index_level_re.FindAllStringSubmatch(string(liveStr), 8)
-	liveTips := live_index_tips_re.FindAllStringSubmatch(string(liveStr), 8)
-	liveStarS := live_index_stars_re.FindAllStringSubmatch(string(liveStr), 8)
+	//7天具体日期，第一项为昨天
+	dateContainer := findNode(doc, hasClass(DATE_CONTAINER_CLASS))
+	if nil == dateContainer {
+		return fail("date", "no %s list", DATE_CONTAINER_CLASS)
+	}
+	dates, skip := dayItems(dateContainer)
+	if len(dates) < WEATHER_DAYS {
+		return fail("date", "%d days found, want %d", len(dates), WEATHER_DAYS)
+	}
+
+	//天气、风
+	sunContainer := findNode(doc, hasClass(SUN_CONTAINER_CLASS))
+	if nil == sunContainer {
+		return fail("weather", "no %s list", SUN_CONTAINER_CLASS)
+	}
+	suns, _ := dayItems(sunContainer)
+	if len(suns) < WEATHER_DAYS {
+		return fail("weather", "%d days found, want %d", len(suns), WEATHER_DAYS)
+	}
+	if len(eventDay) < WEATHER_DAYS+skip || len(eventNight) < WEATHER_DAYS+skip {
+		return fail("temperature", "%d/%d temperatures found, want %d", len(eventDay), len(eventNight), WEATHER_DAYS+skip)
+	}
+	if len(sunrise) < WEATHER_DAYS+skip || len(sunset) < WEATHER_DAYS+skip {
+		return fail("sunrise", "%d/%d sunrise and sunset found, want %d", len(sunrise), len(sunset), WEATHER_DAYS+skip)
+	}
 
 	for i := 0; i < WEATHER_DAYS; i++ {
 		var brief_weather_info = &BriefWeatherInfo{}
-		brief_weather_info.Date_ = fmt.Sprintf("%s(%s)", dateNum[i+1][1], dateName[i+1][1])
-		brief_weather_info.Sun_ = strings.Split(weatherStrs[i][1], ">")[1]
-		brief_weather_info.Temperature_[0] = eventNight[i+1]
-		brief_weather_info.Temperature_[1] = eventDay[i+1]
-		brief_weather_info.Turn_.Sunrise = sunrise[i]
-		brief_weather_info.Turn_.Sunset = sunset[i]
-		brief_weather_info.Wind_.From_ = strings.Split(weatherWindyDirections[i+2][1], "\"")[2]
-		brief_weather_info.Wind_.To_ = strings.Split(weatherWindyDirections[i+3][1], "\"")[2]
-		
+		dateNum := findNode(dates[i], hasClass(DATE_NUM_CLASS))
+		dateName := findNode(dates[i], hasClass(DATE_NAME_CLASS))
+		if nil == dateNum || nil == dateName {
+			return fail("date", "day %d has no date", i)
+		}
+		brief_weather_info.Date_ = fmt.Sprintf("%s(%s)", nodeText(dateNum), nodeText(dateName))
+
+		sun := findNode(suns[i], hasClass(DATE_WEATHER_CLASS))
+		if nil == sun {
+			return fail("weather", "day %d has no %s", i, DATE_WEATHER_CLASS)
+		}
+		brief_weather_info.Sun_ = nodeText(sun)
+
+		brief_weather_info.Temperature_[0], _ = strconv.Atoi(eventNight[i+skip])
+		brief_weather_info.Temperature_[1], _ = strconv.Atoi(eventDay[i+skip])
+		brief_weather_info.Turn_.Sunrise = sunrise[i+skip]
+		brief_weather_info.Turn_.Sunset = sunset[i+skip]
+
+		winds := findNodes(suns[i], hasClass(DATE_WINDY_DIRECTION))
+		if len(winds) < 2 {
+			return fail("wind", "day %d has %d wind directions, want 2", i, len(winds))
+		}
+		brief_weather_info.Wind_.From_ = attr(winds[0], "title")
+		brief_weather_info.Wind_.To_ = attr(winds[1], "title")
+
 		// 处理风级格式
-		windLevel := weatherWindyLevels[i][1]
+		level := findNode(suns[i], hasClass(DATE_WINDY_LEVEL))
+		if nil == level {
+			return fail("wind", "day %d has no %s", i, DATE_WINDY_LEVEL)
+		}
+		windLevel := nodeText(level)
 		if strings.Contains(windLevel, "<") {
 			windLevel = strings.Replace(windLevel, "<", "小于", -1)
 		}
 		brief_weather_info.Wind_.Level_ = windLevel
-		
+
 		SevenDaysWeatherInfo.Weather_[i] = brief_weather_info
 	}
+
+	//生活指数
+	liveIndex := findNode(doc, hasClass(LIVE_INDEX_CLASS))
+	if nil == liveIndex {
+		return fail("live index", "no %s block", LIVE_INDEX_CLASS)
+	}
+	lives := findNodes(liveIndex, isElement("dl"))
+	if len(lives) < LIVE_INDEX_INFO_COUNT {
+		return fail("live index", "%d items found, want %d", len(lives), LIVE_INDEX_INFO_COUNT)
+	}
 	for i := 0; i < LIVE_INDEX_INFO_COUNT; i++ {
+		name := findNode(lives[i], isElement("h2"))
+		level := findNode(lives[i], isElement("em"))
+		tips := findNode(lives[i], func(n *html.Node) bool { return isElement("dd")(n) && nil == findNode(n, isElement("h2")) })
+		if nil == name || nil == level || nil == tips {
+			return fail("live index", "item %d is incomplete", i)
+		}
+		var stars int
+		if starNode := findNode(lives[i], hasClass(LIVE_INDEX_STARS)); nil != starNode {
+			stars = len(findNodes(starNode, hasClass(LIVE_INDEX_ACTIVE)))
+		}
 		SevenDaysWeatherInfo.LiveIndex_[i] = &LiveIndexInfo{
-			Name_:  liveNames[i][1],
-			Level_: liveLevels[i][1],
-			Tips:   liveTips[i][1],
-			Stars_: fillStars(strings.Count(liveStarS[i][1], "active")),
+			Name_:  nodeText(name),
+			Level_: nodeText(level),
+			Tips:   nodeText(tips),
+			Stars_: fillStars(stars),
 		}
 	}
+	return SevenDaysWeatherInfo, nil
+}
 
-	//设定更新时间
-	SevenDaysWeatherInfo.getime_ = time.Now()
+// dayItems returns the <li> of a day list without the leading yesterday item, and how many were skipped
+func dayItems(list *html.Node) (days []*html.Node, skip int) {
+	for c := list.FirstChild; c != nil; c = c.NextSibling {
+		if !isElement("li")(c) {
+			continue
+		}
+		if hasClass(YESTERDAY_CLASS)(c) {
+			skip++
+			continue
+		}
+		days = append(days, c)
+	}
+	return days, skip
+}
 
-	//查询是需要获取告警信息
-	locations, ok := GetLocationInfoByID(cityinfo.Code_)
-	if ok {
-		SevenDaysWeatherInfo.AlarmInfo_ = SevenDaysWeatherInfo.AlarmInfo_[:0]
-		for _, v := range locations {
-			GetAlarmDetails(ALARM_DETAILS+v.FileName, SevenDaysWeatherInfo)
+// scriptArray gets the values of a `var name = [...]` array in a script
+func scriptArray(script, name string) ([]string, error) {
+	m := regexp.MustCompile(fmt.Sprintf(SCRIPT_ARRAY, name)).FindStringSubmatch(script)
+	if nil == m {
+		return nil, fmt.Errorf("no %s in script", name)
+	}
+	var values []string
+	if err := json.Unmarshal([]byte(m[1]), &values); err != nil {
+		return nil, fmt.Errorf("bad %s: %v", name, err)
+	}
+	return values, nil
+}
+
+func isElement(tag string) func(n *html.Node) bool {
+	return func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == tag
+	}
+}
+
+func hasClass(class string) func(n *html.Node) bool {
+	return func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return false
+		}
+		for _, c := range strings.Fields(attr(n, "class")) {
+			if c == class {
+				return true
+			}
 		}
+		return false
 	}
-	c.addWeatherInfoToCache(cityinfo.Code_, SevenDaysWeatherInfo)
-	return SevenDaysWeatherInfo, err
 }
 
-func getTemperatureFromGroup(str string) []int {
-	s := strings.Index(str, "[")
-	strTmp := str[s+1 : len(str)-2]
-	t := make([]int, 0)
-	var st = strings.Split(strTmp, ",")
-	for _, v := range st {
-		v = strings.Trim(v, "\"")
-		nt, _ := strconv.ParseInt(v, 10, 64)
-		t = append(t, int(nt))
-	}
-	return t
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
 }
 
-func getSunsetFromGroup(str string) []string {
-	s := strings.Index(str, "[")
-	strTmp := str[s+1 : len(str)-2]
-	t := make([]string, 0)
-	var st = strings.Split(strTmp, ",")
-	for _, v := range st {
-		v = strings.Trim(v, "\"")
-		t = append(t, v)
+// findNode returns the first node below n (depth first) that matches
+func findNode(n *html.Node, match func(*html.Node) bool) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if match(c) {
+			return c
+		}
+		if found := findNode(c, match); nil != found {
+			return found
+		}
 	}
-	return t
+	return nil
 }
+
+// findNodes returns all the nodes below n that match, the matched nodes are not searched again
+func findNodes(n *html.Node, match func(*html.Node) bool) (nodes []*html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if match(c) {
+			nodes = append(nodes, c)
+			continue
+		}
+		nodes = append(nodes, findNodes(c, match)...)
+	}
+	return nodes
+}
+
+// nodeText returns the trimmed text content of n
+func nodeText(n *html.Node) string {
+	var buf strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(buf.String())
+}
+
 func fillStars(c int) string {
 	var stars string
 	for i := 0; i < c; i++ {
@@ -0,0 +1,38 @@
+// Package weathertest answers the requests of the weather package with the pages recorded under weather/testdata,
+// so the tests of every package read the same fixtures.
+package weathertest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+)
+
+/*weather/testdata，与测试所在的目录无关*/
+var testdata = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "testdata")
+}()
+
+// Transport answers the requests with the pages recorded under weather/testdata/<host>/<path>, the others get 404
+type Transport struct{}
+
+func (Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := ioutil.ReadFile(filepath.Join(testdata, req.URL.Host, filepath.FromSlash(req.URL.Path)))
+	if err != nil {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Status:     "404 Not Found",
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			Request:    req,
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}